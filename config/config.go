@@ -0,0 +1,462 @@
+//Package config load the configuration of the server from the flags, the APIATEX_*
+//environment variables and a YAML file.
+//
+//The precedence from the lowest is the Default, the file, the environment variables
+//and the flags. Every field has a path, e.g. db.max_open_conns, that is the key on
+//the file, the flag name (-db.max_open_conns) and the environment variable
+//(APIATEX_DB_MAX_OPEN_CONNS). The list is comma separated on the flags and the
+//environment variables.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	//EnvPrefix is the prefix of the environment variables.
+	EnvPrefix = "APIATEX_"
+	//FileFlag is the flag of the config file, the environment variable is APIATEX_CONFIG.
+	FileFlag = "config"
+	//Redacted replace the value of the secret fields on Print.
+	Redacted = "[REDACTED]"
+)
+
+//Config is the configuration of the server.
+type Config struct {
+	//Debug log the error of the requests with more detailed information.
+	Debug   bool    `yaml:"debug" usage:"log the error of the requests with more detail"`
+	Server  Server  `yaml:"server"`
+	DB      DB      `yaml:"db"`
+	Tenant  Tenant  `yaml:"tenant"`
+	CORS    CORS    `yaml:"cors"`
+	Auth    Auth    `yaml:"auth"`
+	Tracing Tracing `yaml:"tracing"`
+	Log     Log     `yaml:"log"`
+}
+
+//Server is the configuration of the http server.
+type Server struct {
+	Addr            string        `yaml:"addr" usage:"address the server listen on"`
+	TLSCert         string        `yaml:"tls_cert" usage:"TLS certificate file, the server use https if it is set"`
+	TLSKey          string        `yaml:"tls_key" usage:"TLS private key file of the tls_cert"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" usage:"maximum duration to read the request, 0 is no timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" usage:"maximum duration to write the response, 0 is no timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" usage:"maximum duration to drain the in-flight requests on shutdown"`
+	MaxPageSize     int           `yaml:"max_page_size" usage:"maximum records of the list endpoint, 0 is unlimited"`
+	Diagnostics     bool          `yaml:"diagnostics" usage:"serve the connection pool stats on /debug/db"`
+	HealthTimeout   time.Duration `yaml:"health_timeout" usage:"timeout of the readiness checks of /readyz"`
+	IdempotencyTTL  time.Duration `yaml:"idempotency_ttl" usage:"how long the response of an Idempotency-Key is replayed, 0 is disabled"`
+}
+
+//DB is the configuration of the database, DSN replace the Host, Name, User and Password.
+type DB struct {
+	DSN             string        `yaml:"dsn" secret:"true" usage:"data source name of the database, it replace host, name, user and password"`
+	Host            string        `yaml:"host" usage:"host of the database, e.g. 127.0.0.1:3306, tcp(db:3306) or unix(/tmp/mysql.sock)"`
+	Name            string        `yaml:"name" usage:"name of the database"`
+	User            string        `yaml:"user" usage:"user of the database"`
+	Password        string        `yaml:"password" secret:"true" usage:"password of the database user"`
+	Replicas        []string      `yaml:"replicas" usage:"comma separated host of the replica databases"`
+	MaxOpenConns    int           `yaml:"max_open_conns" usage:"maximum open connections, 0 is unlimited"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" usage:"maximum idle connections"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" usage:"maximum duration a connection is reused, 0 is forever"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" usage:"maximum duration a connection is idle, 0 is forever"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout" usage:"timeout to dial the database"`
+	ConnectRetries  int           `yaml:"connect_retries" usage:"retries to connect while the database is starting"`
+	ConnectBackoff  time.Duration `yaml:"connect_backoff" usage:"wait before the first connect retry, doubled on every retry"`
+	Migrate         bool          `yaml:"migrate" usage:"apply the pending schema migrations on start"`
+	LogQueries      bool          `yaml:"log_queries" usage:"log every statement at the debug level"`
+	LogArgs         bool          `yaml:"log_args" usage:"log the args of the statements instead of redacting them"`
+	SlowQuery       time.Duration `yaml:"slow_query" usage:"log the statements that take at least the duration, 0 is disabled"`
+	ExplainSlow     bool          `yaml:"explain_slow" usage:"log the EXPLAIN of the slow SELECT statements"`
+	ExplainFormat   string        `yaml:"explain_format" usage:"FORMAT of the EXPLAIN of explain_slow, e.g. TREE or JSON"`
+	StmtCacheSize   int           `yaml:"stmt_cache_size" usage:"prepared statements cached for the primary database, 0 is disabled"`
+}
+
+//Database return the name of the database of the DSN or the Name.
+func (c DB) Database() string {
+	if c.DSN != "" {
+		if mc, err := mysql.ParseDSN(c.DSN); err == nil {
+			return mc.DBName
+		}
+	}
+	return c.Name
+}
+
+//DataSource return the DSN of the database name on the host, empty host and name is
+//the host and name of the config or the DSN if it is set.
+func (c DB) DataSource(host, name string) (string, error) {
+	mc := mysql.NewConfig()
+	if c.DSN != "" {
+		var err error
+		if mc, err = mysql.ParseDSN(c.DSN); err != nil {
+			return "", err
+		}
+	} else {
+		mc.User = c.User
+		mc.Passwd = c.Password
+		if host == "" {
+			host = c.Host
+		}
+		if name == "" {
+			name = c.Name
+		}
+	}
+	if host != "" {
+		mc.Net, mc.Addr = "tcp", host
+		if i := strings.Index(host, "("); i > 0 && strings.HasSuffix(host, ")") {
+			mc.Net, mc.Addr = host[:i], host[i+1:len(host)-1]
+		}
+	}
+	if name != "" {
+		mc.DBName = name
+	}
+	mc.ParseTime = true
+	if c.ConnectTimeout > 0 {
+		mc.Timeout = c.ConnectTimeout
+	}
+	return mc.FormatDSN(), nil
+}
+
+//Tenant is where the tenant of the request is resolved.
+type Tenant struct {
+	Header string `yaml:"header" usage:"request header of the tenant ID"`
+	Domain string `yaml:"domain" usage:"domain of the tenant subdomain"`
+}
+
+//CORS is the cross-origin policy, CORS is disabled if AllowedOrigins is empty.
+type CORS struct {
+	AllowedOrigins []string      `yaml:"allowed_origins" usage:"comma separated origins allowed to call the API, * is any origin"`
+	AllowedMethods []string      `yaml:"allowed_methods" usage:"comma separated methods allowed for the cross-origin requests"`
+	AllowedHeaders []string      `yaml:"allowed_headers" usage:"comma separated headers allowed for the cross-origin requests"`
+	MaxAge         time.Duration `yaml:"max_age" usage:"duration the preflight response can be cached"`
+}
+
+//Auth is the secrets of the authentication.
+type Auth struct {
+	TokenSecret string `yaml:"token_secret" secret:"true" usage:"HS256 secret of the bearer token"`
+	TenantClaim string `yaml:"tenant_claim" usage:"claim of the bearer token that has the tenant ID"`
+}
+
+//Tracing is the exporter of the traces, the tracing is disabled if Endpoint is empty.
+type Tracing struct {
+	Endpoint    string        `yaml:"endpoint" usage:"OTLP/HTTP traces endpoint, e.g. http://localhost:4318/v1/traces"`
+	ServiceName string        `yaml:"service_name" usage:"service.name of the exported spans"`
+	BatchSize   int           `yaml:"batch_size" usage:"maximum spans of an export request"`
+	Interval    time.Duration `yaml:"interval" usage:"maximum duration a span wait before it is exported"`
+}
+
+//Log is the configuration of the log and the access log of the requests.
+type Log struct {
+	Level        string   `yaml:"level" usage:"minimum level of the log: debug, info, warn or error"`
+	Format       string   `yaml:"format" usage:"format of the log: json or text"`
+	Sample       int      `yaml:"sample" usage:"log one of every sample successful requests, the failed request is always logged"`
+	RedactParams []string `yaml:"redact_params" usage:"comma separated query parameters redacted on the access log"`
+}
+
+//Default return the Config used for the field that is not set.
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:            ":8082",
+			ShutdownTimeout: 30 * time.Second,
+			MaxPageSize:     100,
+			HealthTimeout:   2 * time.Second,
+			IdempotencyTTL:  24 * time.Hour,
+		},
+		DB: DB{
+			Name:            "atex",
+			User:            "root",
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: 5 * time.Minute,
+			ConnectTimeout:  5 * time.Second,
+			ConnectRetries:  10,
+			ConnectBackoff:  500 * time.Millisecond,
+			Migrate:         true,
+			SlowQuery:       time.Second,
+			StmtCacheSize:   100,
+		},
+		Tenant:  Tenant{Header: "X-Tenant-ID"},
+		Tracing: Tracing{ServiceName: "apiatex", BatchSize: 512, Interval: 5 * time.Second},
+		Log: Log{
+			Level:        "info",
+			Format:       "json",
+			Sample:       1,
+			RedactParams: []string{"cursor", "token", "access_token", "api_key", "password", "secret"},
+		},
+		CORS: CORS{
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Content-Type", "Authorization", "X-Tenant-ID", "Idempotency-Key"},
+		},
+	}
+}
+
+//Load register the flags of the Config on fs, parse the args and return the Config.
+//The Config is not validated, call Validate before it is used.
+func Load(fs *flag.FlagSet, args []string) (Config, error) {
+	c := Default()
+	fields := fieldsOf(&c)
+	flags := make(map[string]*flagValue, len(fields))
+	for _, f := range fields {
+		fv := &flagValue{field: f, raw: f.String()}
+		fs.Var(fv, f.path, f.usage)
+		flags[f.path] = fv
+	}
+	file := fs.String(FileFlag, os.Getenv(EnvPrefix+"CONFIG"), "YAML config `file`")
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	if *file != "" {
+		if err := loadFile(&c, *file); err != nil {
+			return c, err
+		}
+	}
+	for _, f := range fields {
+		if s, ok := os.LookupEnv(f.env()); ok {
+			if err := f.set(s); err != nil {
+				return c, fmt.Errorf("config: env %s: %w", f.env(), err)
+			}
+		}
+	}
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		fv, ok := flags[fl.Name]
+		if !ok || err != nil {
+			return
+		}
+		if serr := fv.field.set(fv.raw); serr != nil {
+			err = fmt.Errorf("config: flag -%s: %w", fl.Name, serr)
+		}
+	})
+	return c, err
+}
+
+//loadFile decode the YAML file to c, the field that is not on the file is unchanged.
+func loadFile(c *Config, name string) error {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+	default:
+		return fmt.Errorf("config: file %s: format is not supported, use YAML", name)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("config: file %s: %w", name, err)
+	}
+	return nil
+}
+
+//Validate return all the invalid fields of the Config.
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(path, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("config: %s %s", path, fmt.Sprintf(format, args...)))
+	}
+	if c.Server.Addr == "" {
+		invalid("server.addr", "is required")
+	}
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		invalid("server.tls_cert", "and server.tls_key must be set together")
+	}
+	if c.DB.DSN == "" && c.DB.Name == "" {
+		invalid("db.name", "is required when db.dsn is not set")
+	}
+	if c.DB.DSN != "" {
+		if _, err := mysql.ParseDSN(c.DB.DSN); err != nil {
+			invalid("db.dsn", "is not valid: %v", err)
+		}
+	}
+	if c.DB.MaxOpenConns > 0 && c.DB.MaxIdleConns > c.DB.MaxOpenConns {
+		invalid("db.max_idle_conns", "must not be greater than db.max_open_conns")
+	}
+	for _, f := range fieldsOf(&c) {
+		switch v := f.value.Interface().(type) {
+		case int:
+			if v < 0 {
+				invalid(f.path, "must not be negative")
+			}
+		case time.Duration:
+			if v < 0 {
+				invalid(f.path, "must not be negative")
+			}
+		}
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			invalid("cors.allowed_origins", "origin %q must be * or scheme://host", origin)
+		}
+	}
+	if c.Auth.TokenSecret != "" && len(c.Auth.TokenSecret) < 32 {
+		invalid("auth.token_secret", "must be at least 32 bytes")
+	}
+	if c.Tracing.Endpoint != "" {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("tracing.endpoint", "must be an http or https URL")
+		}
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		invalid("log.level", "must be debug, info, warn or error")
+	}
+	switch strings.ToUpper(c.DB.ExplainFormat) {
+	case "", "TRADITIONAL", "TREE", "JSON":
+	default:
+		invalid("db.explain_format", "must be TRADITIONAL, TREE or JSON")
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		invalid("log.format", "must be json or text")
+	}
+	if c.Auth.TenantClaim != "" && c.Auth.TokenSecret == "" {
+		invalid("auth.tenant_claim", "require auth.token_secret")
+	}
+	return errors.Join(errs...)
+}
+
+//Redact return the copy of the Config with the secret fields replaced by Redacted.
+func (c Config) Redact() Config {
+	c.DB.Replicas = append([]string(nil), c.DB.Replicas...)
+	for _, f := range fieldsOf(&c) {
+		if f.secret && f.value.String() != "" {
+			f.value.SetString(Redacted)
+		}
+	}
+	return c
+}
+
+//Print write the Config as YAML with the secrets redacted.
+func (c Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redact()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+//field is a configurable field of the Config.
+type field struct {
+	path   string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+//fieldsOf return the fields of the struct v point to, the nested struct is flatten
+//with the path separated by dot.
+func fieldsOf(v interface{}) []field {
+	return appendFields(nil, "", reflect.ValueOf(v).Elem())
+}
+
+func appendFields(fields []field, prefix string, v reflect.Value) []field {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		path := prefix + strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Duration(0)) {
+			fields = appendFields(fields, path+".", v.Field(i))
+			continue
+		}
+		fields = append(fields, field{
+			path:   path,
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return fields
+}
+
+//env return the environment variable of the field.
+func (f field) env() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(f.path, ".", "_"))
+}
+
+//set parse s to the field value.
+func (f field) set(s string) error {
+	switch f.value.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(d))
+	case string:
+		f.value.SetString(s)
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(n))
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(b)
+	case []string:
+		var list []string
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+		f.value.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("type %s is not supported", f.value.Type())
+	}
+	return nil
+}
+
+//String return the value of the field in the format of set.
+func (f field) String() string {
+	if list, ok := f.value.Interface().([]string); ok {
+		return strings.Join(list, ",")
+	}
+	return fmt.Sprint(f.value.Interface())
+}
+
+//flagValue keep the flag value until it is set to the Config after the file and
+//the environment variables.
+type flagValue struct {
+	field field
+	raw   string
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.raw
+}
+
+func (v *flagValue) Set(s string) error {
+	v.raw = s
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.field.value.Kind() == reflect.Bool
+}
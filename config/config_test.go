@@ -0,0 +1,212 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	name = filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoad(t *testing.T) {
+	file := writeFile(t, "apiatex.yaml", `
+debug: true
+server:
+  addr: ":9000"
+  read_timeout: 5s
+db:
+  host: tcp(db:3306)
+  user: file
+  max_open_conns: 10
+  replicas: [r1, r2]
+`)
+	t.Run("Precedence", func(t *testing.T) {
+		t.Setenv("APIATEX_DB_USER", "env")
+		t.Setenv("APIATEX_SERVER_ADDR", ":9001")
+		t.Setenv("APIATEX_CORS_ALLOWED_ORIGINS", "https://a.example.com, https://b.example.com")
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		c, err := Load(fs, []string{"-config", file, "-server.addr", ":9002", "-db.max_idle_conns=5"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Default()
+		want.Debug = true
+		want.Server.Addr = ":9002"
+		want.Server.ReadTimeout = 5 * time.Second
+		want.DB.Host = "tcp(db:3306)"
+		want.DB.User = "env"
+		want.DB.MaxOpenConns = 10
+		want.DB.MaxIdleConns = 5
+		want.DB.Replicas = []string{"r1", "r2"}
+		want.CORS.AllowedOrigins = []string{"https://a.example.com", "https://b.example.com"}
+		if !reflect.DeepEqual(c, want) {
+			t.Errorf("got config:\n%+v\nwant:\n%+v", c, want)
+		}
+		if err := c.Validate(); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Default", func(t *testing.T) {
+		c, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c, Default()) {
+			t.Errorf("got config:%+v want the default", c)
+		}
+	})
+	t.Run("EnvFile", func(t *testing.T) {
+		t.Setenv("APIATEX_CONFIG", file)
+		c, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-debug=false"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Debug || c.DB.Host != "tcp(db:3306)" {
+			t.Errorf("got debug:%t host:%s want false and the host of the file", c.Debug, c.DB.Host)
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		testCase := []struct {
+			name string
+			args []string
+			env  string
+			file string
+		}{
+			{name: "flag", args: []string{"-db.max_open_conns", "many"}},
+			{name: "env", env: "APIATEX_SERVER_READ_TIMEOUT"},
+			{name: "unknown field", file: writeFile(t, "unknown.yaml", "db:\n  hots: x\n")},
+			{name: "format", file: writeFile(t, "apiatex.toml", "debug = true\n")},
+			{name: "missing file", file: filepath.Join(t.TempDir(), "missing.yaml")},
+		}
+		for _, tc := range testCase {
+			if tc.env != "" {
+				os.Setenv(tc.env, "soon")
+			}
+			args := tc.args
+			if tc.file != "" {
+				args = append(args, "-config", tc.file)
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			if _, err := Load(fs, args); err == nil {
+				t.Errorf("%s got nil want error", tc.name)
+			}
+			if tc.env != "" {
+				os.Unsetenv(tc.env)
+			}
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.Server.Addr = ""
+	c.Server.TLSCert = "cert.pem"
+	c.DB.Name = ""
+	c.DB.MaxOpenConns = 5
+	c.DB.MaxIdleConns = 10
+	c.DB.ConnMaxLifetime = -time.Second
+	c.CORS.AllowedOrigins = []string{"*", "example.com"}
+	c.Auth.TokenSecret = "short"
+	c.Tracing.Endpoint = "localhost:4318"
+	c.Log.Level = "verbose"
+	c.Log.Format = "xml"
+	c.DB.ExplainFormat = "yaml"
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("got nil want error")
+	}
+	for _, path := range []string{"server.addr", "server.tls_cert", "db.name", "db.max_idle_conns",
+		"db.conn_max_lifetime", "cors.allowed_origins", "auth.token_secret", "tracing.endpoint",
+		"log.level", "log.format", "db.explain_format"} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("got err:%v want error of %s", err, path)
+		}
+	}
+	if err := Default().Validate(); err != nil {
+		t.Errorf("default got err:%v", err)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	c := Default()
+	c.DB.Password = "p@ss:word"
+	c.DB.DSN = "root:p@ss:word@tcp(db)/atex"
+	c.Auth.TokenSecret = strings.Repeat("s", 32)
+	var b bytes.Buffer
+	if err := c.Print(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	if strings.Contains(out, "p@ss") || strings.Contains(out, "sss") {
+		t.Errorf("secret is printed:\n%s", out)
+	}
+	if strings.Count(out, Redacted) != 3 || !strings.Contains(out, "addr: :8082") {
+		t.Errorf("got:\n%s", out)
+	}
+	if c.DB.Password != "p@ss:word" {
+		t.Error("Print change the config")
+	}
+	//the printed config can be loaded back.
+	file := writeFile(t, "print.yml", out)
+	got, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Server.ShutdownTimeout != c.Server.ShutdownTimeout {
+		t.Errorf("got shutdown timeout:%s want:%s", got.Server.ShutdownTimeout, c.Server.ShutdownTimeout)
+	}
+}
+
+func TestDataSource(t *testing.T) {
+	c := Default()
+	c.DB.User = "app"
+	c.DB.Password = "p@ss:w/rd?"
+	c.DB.Host = "db.local:3307"
+	c.DB.ConnectTimeout = 2 * time.Second
+	testCase := []struct {
+		db         DB
+		host, name string
+		net, addr  string
+		dbname     string
+	}{
+		{db: c.DB, net: "tcp", addr: "db.local:3307", dbname: "atex"},
+		{db: c.DB, host: "unix(/tmp/mysql.sock)", name: "mysql", net: "unix", addr: "/tmp/mysql.sock", dbname: "mysql"},
+		{db: DB{DSN: "app:p@ss:w/rd?@tcp(dsn:3306)/fromdsn", Name: "atex"}, net: "tcp", addr: "dsn:3306", dbname: "fromdsn"},
+		{db: DB{DSN: "app:p@ss:w/rd?@tcp(dsn:3306)/fromdsn"}, host: "replica:3306", net: "tcp", addr: "replica:3306", dbname: "fromdsn"},
+	}
+	for i, tc := range testCase {
+		dsn, err := tc.db.DataSource(tc.host, tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mc, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			t.Fatalf("tc:%d dsn:%s err:%v", i, dsn, err)
+		}
+		if mc.User != "app" || mc.Passwd != "p@ss:w/rd?" || mc.Net != tc.net || mc.Addr != tc.addr ||
+			mc.DBName != tc.dbname || !mc.ParseTime {
+			t.Errorf("tc:%d got user:%s password:%s net:%s addr:%s db:%s parse time:%t", i,
+				mc.User, mc.Passwd, mc.Net, mc.Addr, mc.DBName, mc.ParseTime)
+		}
+		if got := tc.db.Database(); tc.name == "" && got != tc.dbname {
+			t.Errorf("tc:%d got database:%s want:%s", i, got, tc.dbname)
+		}
+	}
+	if _, err := (DB{DSN: "not a dsn"}).DataSource("", ""); err == nil {
+		t.Error("invalid dsn want error got nil")
+	}
+}
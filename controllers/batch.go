@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/riyan/apiatex/controllers/dbaccess"
+	"github.com/riyan/apiatex/controllers/webserver/webhandler"
+)
+
+//MaxBatchOperations is the maximum operations of a batch request.
+const MaxBatchOperations = 100
+
+//errBatchFailed rollback the transaction of the batch that has a failed operation.
+var errBatchFailed = errors.New("batch operation failed")
+
+//BatchOperation is a request of the batch, Path is relative to the pattern of the
+//handler or start with the pattern, e.g. employed/11111111 or /api/v1/e/employed.
+type BatchOperation struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	//ContentType is the content type of the body, default application/json.
+	ContentType string `json:"content_type,omitempty"`
+}
+
+//BatchResult is the response of an operation, Status zero mean the operation is not
+//run because the previous operation failed.
+type BatchResult struct {
+	Status int         `json:"status"`
+	Data   interface{} `json:"data,omitempty"`
+	Err    string      `json:"err,omitempty"`
+}
+
+//BatchReport is the result of the batch, the operations is rolled back if Committed
+//is false.
+type BatchReport struct {
+	Committed bool          `json:"committed"`
+	Results   []BatchResult `json:"results"`
+}
+
+//batchWriter is the http.ResponseWriter of an operation, the response of the handler
+//is the Response so the written body is discarded.
+type batchWriter struct {
+	header http.Header
+}
+
+func (bw *batchWriter) Header() http.Header         { return bw.header }
+func (bw *batchWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (bw *batchWriter) WriteHeader(code int)        {}
+
+//handleBatch run the operations of the body with the handler in one transaction, the
+//transaction is rolled back when an operation failed and the next operations is not
+//run.
+func (wh eHandler) handleBatch(w http.ResponseWriter, r *http.Request) webhandler.Response {
+	res := webhandler.Response{}
+	var ops []BatchOperation
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		res.Error(err, http.StatusBadRequest)
+		return res
+	}
+	if len(ops) == 0 || len(ops) > MaxBatchOperations {
+		res.Error(fmt.Errorf("batch must have 1 to %d operations", MaxBatchOperations), http.StatusBadRequest)
+		return res
+	}
+	urls := make([]*url.URL, len(ops))
+	for i, op := range ops {
+		u, err := wh.batchURL(op)
+		if err != nil {
+			res.Error(fmt.Errorf("operation %d: %v", i, err), http.StatusBadRequest)
+			return res
+		}
+		urls[i] = u
+	}
+	db, err := webhandler.DBFromContext(r.Context())
+	if err != nil {
+		res.Error(err, http.StatusInternalServerError)
+		return res
+	}
+	var report BatchReport
+	failed := -1
+	err = dbaccess.WithTx(r.Context(), db, nil, func(tx dbaccess.DBExecer) error {
+		//the transaction may be retried, so the results is reset.
+		report.Results = make([]BatchResult, len(ops))
+		failed = -1
+		ctx := webhandler.NewContextWithTx(r.Context(), tx)
+		for i, op := range ops {
+			or, err := http.NewRequestWithContext(ctx, op.Method, urls[i].String(), bytes.NewReader(op.Body))
+			if err != nil {
+				return err
+			}
+			or.Header = r.Header.Clone()
+			or.Header.Del(webhandler.IdempotencyHeader)
+			or.Header.Set("Content-Type", "application/json")
+			if op.ContentType != "" {
+				or.Header.Set("Content-Type", op.ContentType)
+			}
+			or.RemoteAddr = r.RemoteAddr
+			if report.Results[i], err = batchResult(wh.Handle(&batchWriter{header: http.Header{}}, or)); err != nil {
+				return err
+			}
+			if result := report.Results[i]; result.Status >= http.StatusBadRequest || result.Err != "" {
+				failed = i
+				return errBatchFailed
+			}
+		}
+		return nil
+	})
+	if err != nil && err != errBatchFailed {
+		res.Error(err, http.StatusOK)
+		return res
+	}
+	report.Committed = err == nil
+	if failed >= 0 {
+		for i := failed + 1; i < len(ops); i++ {
+			report.Results[i].Err = fmt.Sprintf("not run, operation %d failed", failed)
+		}
+		res.Error(fmt.Errorf("operation %d failed, the batch is rolled back", failed), http.StatusOK)
+	}
+	res.Data = report
+	return res
+}
+
+//batchURL return the url of the operation, the operation can not be another batch,
+//import or export.
+func (wh eHandler) batchURL(op BatchOperation) (*url.URL, error) {
+	switch op.Method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return nil, fmt.Errorf("method %q is not supported", op.Method)
+	}
+	path := op.Path
+	if !strings.HasPrefix(path, wh.pattern) {
+		path = wh.pattern + strings.TrimPrefix(path, "/")
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	//Handle route on the decoded path, so the decoded segments is checked.
+	for _, segment := range strings.Split(strings.TrimPrefix(u.Path, wh.pattern), "/") {
+		switch segment {
+		case "_batch", "_import", "_export":
+			return nil, fmt.Errorf("%s can not be run on a batch", segment)
+		}
+	}
+	return u, nil
+}
+
+//batchResult return the result of the response of the operation, the iterator is
+//read before the next operation use the transaction.
+func batchResult(res webhandler.Response) (BatchResult, error) {
+	result := BatchResult{Status: res.Status(), Data: res.Data, Err: res.ErrMessage}
+	it, ok := res.Data.(webhandler.TableIterator)
+	if !ok {
+		return result, nil
+	}
+	defer it.Close()
+	tables := []dbaccess.Table{}
+	for it.Next() {
+		tables = append(tables, it.Table())
+	}
+	result.Data = tables
+	return result, it.Err()
+}
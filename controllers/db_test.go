@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/riyan/apiatex/controllers/dbaccess"
 	"github.com/riyan/apiatex/models"
 )
 
@@ -65,6 +66,9 @@ func createTable(db *sql.DB, table table) error {
 	_, err := db.Exec(query)
 	return err
 }
+//testTenant is the tenant of the test records.
+const testTenant = "T01"
+
 func deleteDB() {
 	//DeleteDatabase if exist
 	db, err := connectDB(defaultdb, user, password, "")
@@ -91,8 +95,19 @@ func PrepareTest() *sql.DB {
 	if _, err := db.Exec(models.EmployedTable); err != nil {
 		log.Fatal(err)
 	}
+	if _, err := db.Exec(models.EmployedHistoryTable); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Exec(models.DepartmentTable); err != nil {
+		log.Fatal(err)
+	}
+	tdb := dbaccess.WithTenant(db, testTenant)
+	department := &models.Department{Id: "D01", NameDepartment: "Finance"}
+	if err := department.Insert(tdb); err != nil {
+		log.Fatal(err)
+	}
 	for _, emp := range em {
-		if err := emp.Insert(db); err != nil {
+		if err := emp.Insert(tdb); err != nil {
 			log.Fatal(err)
 		}
 	}
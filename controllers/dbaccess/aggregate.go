@@ -0,0 +1,229 @@
+package dbaccess
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//Aggregation is an aggregate function over a field of the table.
+type Aggregation struct {
+	//Func is one of count, min, max, sum or avg.
+	Func string
+	//Field is the field to aggregate, "*" can be used with count to count the records.
+	Field string
+}
+
+//Alias return the name of the aggregation on the result, func_field or only func
+//for count(*).
+func (a Aggregation) Alias() string {
+	if a.Field == "*" {
+		return a.Func
+	}
+	return a.Func + "_" + a.Field
+}
+
+func (a Aggregation) isValid(t Table) error {
+	switch a.Func {
+	case "count", "min", "max", "sum", "avg":
+	default:
+		return fmt.Errorf("invalid aggregate func:%s", a.Func)
+	}
+	if a.Field == "*" {
+		if a.Func != "count" {
+			return fmt.Errorf("aggregate func:%s can not use *", a.Func)
+		}
+		return nil
+	}
+	return isFieldsOK(t, []string{a.Field})
+}
+
+func (a Aggregation) expr() string {
+	return fmt.Sprintf("%s(%s)", strings.ToUpper(a.Func), a.Field)
+}
+
+//groupFuncs is the function that can be used on AggregateQuery.GroupBy.
+var groupFuncs = map[string]string{
+	//domain group the email by the domain.
+	"domain": "SUBSTRING_INDEX(%s,'@',-1)",
+	"lower":  "LOWER(%s)",
+}
+
+//AggregateQuery is the query for Aggregate.
+type AggregateQuery struct {
+	//GroupBy is the fields to group the records, the field can be wrapped with a
+	//group function: domain(email) or lower(name), the alias of the wrapped field
+	//is func_field (domain_email).
+	GroupBy      []string
+	Aggregations []Aggregation
+	//Filters filter the records before grouped.
+	Filters []Filter
+	//Having filter the groups, the Field is the alias of the aggregation or the group.
+	Having []Filter
+	//OrderBy is the alias of the aggregation or the group.
+	OrderBy    []string
+	Descending bool
+	Limit      int
+}
+
+//AggregateRow is one group of the Aggregate result, the key is the alias of the group
+//and the aggregation.
+type AggregateRow map[string]interface{}
+
+//column is an expression on the select with its alias.
+type column struct {
+	expr  string
+	alias string
+}
+
+func groupColumn(t Table, group string) (column, error) {
+	field := group
+	expr := group
+	alias := group
+	if i := strings.Index(group, "("); i > 0 && strings.HasSuffix(group, ")") {
+		fn := group[:i]
+		field = group[i+1 : len(group)-1]
+		f, ok := groupFuncs[fn]
+		if !ok {
+			return column{}, fmt.Errorf("invalid group func:%s", fn)
+		}
+		expr = fmt.Sprintf(f, field)
+		alias = fn + "_" + field
+	}
+	if err := isFieldsOK(t, []string{field}); err != nil {
+		return column{}, err
+	}
+	return column{expr: expr, alias: alias}, nil
+}
+
+//Aggregate group the records of the table and compute the aggregations of every group.
+func Aggregate(db DBExecer, t Table, q AggregateQuery) ([]AggregateRow, error) {
+	db = operation(db, "aggregate", t)
+	if len(q.Aggregations) == 0 {
+		return nil, errors.New("no aggregation")
+	}
+	var cols []column
+	var groups []string
+	for _, g := range q.GroupBy {
+		c, err := groupColumn(t, g)
+		if err != nil {
+			return nil, err
+		}
+		cols = append(cols, c)
+		groups = append(groups, c.expr)
+	}
+	for _, a := range q.Aggregations {
+		if err := a.isValid(t); err != nil {
+			return nil, err
+		}
+		cols = append(cols, column{expr: a.expr(), alias: a.Alias()})
+	}
+	exprOf := func(alias string) (string, error) {
+		for _, c := range cols {
+			if c.alias == alias {
+				return c.expr, nil
+			}
+		}
+		return "", fmt.Errorf("unknown aggregate alias:%s", alias)
+	}
+
+	selects := make([]string, len(cols))
+	for i, c := range cols {
+		selects[i] = fmt.Sprintf("%s AS %s", c.expr, c.alias)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selects, ","), t.Name())
+	fs, err := withTenant(db, t, q.Filters)
+	if err != nil {
+		return nil, err
+	}
+	where, args, err := whereClause(t, 1, fs...)
+	if err != nil {
+		return nil, err
+	}
+	if where != "" {
+		query = fmt.Sprintf("%s WHERE %s", query, where)
+	}
+	if len(groups) != 0 {
+		query = fmt.Sprintf("%s GROUP BY %s", query, strings.Join(groups, ","))
+	}
+	var having []string
+	for _, h := range q.Having {
+		if err = h.IsValidOp(); err != nil {
+			return nil, err
+		}
+		expr, err := exprOf(h.Field)
+		if err != nil {
+			return nil, err
+		}
+		having = append(having, fmt.Sprintf("%s %s ?", expr, h.Op))
+		args = append(args, h.Value)
+	}
+	if len(having) != 0 {
+		query = fmt.Sprintf("%s HAVING %s", query, strings.Join(having, " AND "))
+	}
+	var orders []string
+	for _, o := range q.OrderBy {
+		expr, err := exprOf(o)
+		if err != nil {
+			return nil, err
+		}
+		if q.Descending {
+			expr += " DESC"
+		}
+		orders = append(orders, expr)
+	}
+	if len(orders) != 0 {
+		query = fmt.Sprintf("%s ORDER BY %s", query, strings.Join(orders, ","))
+	}
+	if q.Limit != 0 {
+		query = fmt.Sprintf("%s LIMIT %d", query, q.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	var result []AggregateRow
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		dst := make([]interface{}, len(cols))
+		for i := range values {
+			dst[i] = &values[i]
+		}
+		if err = rows.Scan(dst...); err != nil {
+			return nil, err
+		}
+		row := make(AggregateRow, len(cols))
+		for i, c := range cols {
+			row[c.alias] = aggregateValue(values[i], types[i].DatabaseTypeName())
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+//aggregateValue convert the raw value from the driver, the number returned as text
+//is converted to int64 or float64 based on the database type.
+func aggregateValue(v interface{}, dbType string) interface{} {
+	b, ok := v.([]byte)
+	if !ok {
+		return v
+	}
+	switch dbType {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "INTEGER", "INT2", "INT4", "INT8":
+		if i, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			return i
+		}
+	case "DECIMAL", "NUMERIC", "FLOAT", "DOUBLE", "REAL", "FLOAT4", "FLOAT8":
+		if f, err := strconv.ParseFloat(string(b), 64); err == nil {
+			return f
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,79 @@
+package dbaccess
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAggregate(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	nd := time.Now()
+	now := time.Date(nd.Year(), nd.Month(), nd.Day(), 0, 0, 0, 0, time.UTC)
+	data := []*testTable{
+		{Code: "AA", Description: "Karawaci", TransactionDate: now, Amount: 100.00, Count: 1},
+		{Code: "AB", Description: "Karawaci", TransactionDate: now, Amount: 200.00, Count: 2},
+		{Code: "AC", Description: "Cikupa", TransactionDate: now, Amount: 300.00, Count: 3},
+	}
+	for _, v := range data {
+		if err := Insert(db, v); err != nil {
+			t.Fatalf("insert  data:%v err:%v", v, err)
+		}
+	}
+	testCase := []struct {
+		q    AggregateQuery
+		want []AggregateRow
+	}{
+		{
+			q: AggregateQuery{
+				Aggregations: []Aggregation{{Func: "count", Field: "*"}, {Func: "max", Field: "code"}},
+			},
+			want: []AggregateRow{{"count": int64(3), "max_code": "AC"}},
+		},
+		{
+			q: AggregateQuery{
+				GroupBy:      []string{"description"},
+				Aggregations: []Aggregation{{Func: "count", Field: "*"}, {Func: "sum", Field: "count"}},
+				OrderBy:      []string{"count"},
+				Descending:   true,
+			},
+			want: []AggregateRow{
+				{"description": "Karawaci", "count": int64(2), "sum_count": float64(3)},
+				{"description": "Cikupa", "count": int64(1), "sum_count": float64(3)},
+			},
+		},
+		{
+			q: AggregateQuery{
+				GroupBy:      []string{"lower(description)"},
+				Aggregations: []Aggregation{{Func: "count", Field: "*"}},
+				Filters:      []Filter{{Field: "count", Op: ">", Value: 1}},
+				Having:       []Filter{{Field: "count", Op: ">", Value: 1}},
+			},
+			want: nil,
+		},
+	}
+	for i, tc := range testCase {
+		got, err := Aggregate(db, data[0], tc.q)
+		if err != nil {
+			t.Fatalf("tc:%d err:%v", i, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("tc:%d got:%v want:%v", i, got, tc.want)
+		}
+	}
+
+	invalid := []AggregateQuery{
+		{},
+		{Aggregations: []Aggregation{{Func: "median", Field: "count"}}},
+		{Aggregations: []Aggregation{{Func: "sum", Field: "*"}}},
+		{Aggregations: []Aggregation{{Func: "sum", Field: "not_exist"}}},
+		{GroupBy: []string{"upper(code)"}, Aggregations: []Aggregation{{Func: "count", Field: "*"}}},
+		{Aggregations: []Aggregation{{Func: "count", Field: "*"}}, OrderBy: []string{"code"}},
+	}
+	for i, q := range invalid {
+		if _, err := Aggregate(db, data[0], q); err == nil {
+			t.Errorf("tc:%d invalid query want error got nil", i)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package dbaccess
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+//Pool is the limits of the connection pool, zero value keep the database/sql default.
+type Pool struct {
+	//MaxOpenConns is the maximum open connections, zero is unlimited.
+	MaxOpenConns int
+	//MaxIdleConns is the maximum idle connections, zero use the default (2).
+	MaxIdleConns int
+	//ConnMaxLifetime and ConnMaxIdleTime is the maximum time a connection is reused
+	//and is idle before it is closed, zero is forever.
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+//Apply set the limits of the pool on the db.
+func (p Pool) Apply(db *sql.DB) {
+	db.SetMaxOpenConns(p.MaxOpenConns)
+	if p.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(p.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(p.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+}
+
+//ConnectOptions is the option for Connect, nil ConnectOptions use the default value.
+type ConnectOptions struct {
+	Pool
+	//Retries is how many times the ping is retried when the database can not be
+	//reached. Default 10, negative value disable the retry.
+	Retries int
+	//Backoff is the wait before the first retry, it is doubled on every retry until
+	//MaxBackoff. Default 500ms and 30s.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	//OnRetry is called before waiting for the next retry.
+	OnRetry func(attempt int, wait time.Duration, err error)
+}
+
+//Connect open the database of the dsn, set the pool limits and ping the database.
+//The ping is retried with backoff while the database can not be reached (e.g. it is
+//starting), the error from the database like access denied is not retried.
+func Connect(ctx context.Context, driverName, dsn string, opts *ConnectOptions) (*sql.DB, error) {
+	o := ConnectOptions{Retries: 10, Backoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second}
+	if opts != nil {
+		o.Pool = opts.Pool
+		o.OnRetry = opts.OnRetry
+		if opts.Retries != 0 {
+			o.Retries = opts.Retries
+		}
+		if opts.Backoff > 0 {
+			o.Backoff = opts.Backoff
+		}
+		if opts.MaxBackoff > 0 {
+			o.MaxBackoff = opts.MaxBackoff
+		}
+	}
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	o.Pool.Apply(db)
+	wait := o.Backoff
+	for attempt := 0; ; attempt++ {
+		err = db.PingContext(ctx)
+		if err == nil {
+			return db, nil
+		}
+		if attempt >= o.Retries || !isConnError(err) {
+			break
+		}
+		if o.OnRetry != nil {
+			o.OnRetry(attempt+1, wait, err)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			db.Close()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+		if wait *= 2; wait > o.MaxBackoff {
+			wait = o.MaxBackoff
+		}
+	}
+	db.Close()
+	return nil, err
+}
@@ -0,0 +1,64 @@
+package dbaccess
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestConnect(t *testing.T) {
+	pool := Pool{MaxOpenConns: 3, MaxIdleConns: 2, ConnMaxLifetime: time.Minute}
+	t.Run("Pool", func(t *testing.T) {
+		db, err := Connect(context.Background(), "mysql", "root@/"+defaultdb, &ConnectOptions{Pool: pool})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if got := db.Stats().MaxOpenConnections; got != pool.MaxOpenConns {
+			t.Errorf("got max open connections:%d want:%d", got, pool.MaxOpenConns)
+		}
+	})
+	t.Run("Retry", func(t *testing.T) {
+		var waits []time.Duration
+		opts := &ConnectOptions{Retries: 3, Backoff: time.Millisecond, MaxBackoff: 3 * time.Millisecond,
+			OnRetry: func(attempt int, wait time.Duration, err error) {
+				waits = append(waits, wait)
+			}}
+		//nothing listen on the port 1.
+		db, err := Connect(context.Background(), "mysql", "root@tcp(127.0.0.1:1)/test", opts)
+		if err == nil || db != nil {
+			t.Fatalf("got db:%v err:%v want error", db, err)
+		}
+		want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond}
+		if len(waits) != len(want) {
+			t.Fatalf("got waits:%v want:%v", waits, want)
+		}
+		for i := range want {
+			if waits[i] != want[i] {
+				t.Errorf("got waits:%v want:%v", waits, want)
+			}
+		}
+	})
+	t.Run("Cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := &ConnectOptions{Backoff: time.Hour, OnRetry: func(int, time.Duration, error) { cancel() }}
+		if _, err := Connect(ctx, "mysql", "root@tcp(127.0.0.1:1)/test", opts); err != context.Canceled {
+			t.Errorf("got err:%v want:%v", err, context.Canceled)
+		}
+	})
+	t.Run("NotRetried", func(t *testing.T) {
+		retried := false
+		opts := &ConnectOptions{Backoff: time.Millisecond, OnRetry: func(int, time.Duration, error) { retried = true }}
+		_, err := Connect(context.Background(), "mysql", "root@/test_dbaccess_not_exist", opts)
+		var me *mysql.MySQLError
+		if !errors.As(err, &me) {
+			t.Errorf("got err:%v want the database error", err)
+		}
+		if retried {
+			t.Error("the database error is retried")
+		}
+	})
+}
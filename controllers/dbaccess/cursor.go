@@ -6,8 +6,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Filter struct {
@@ -38,10 +40,31 @@ type Cursor struct {
 	Descending bool
 	//LastArgs store last value of the item to use for the filter on the next fetch.
 	LastArgs []interface{}
+	//AsOf fetch the version of the records valid at that time from the history
+	//table, the table must be a Historian. Zero value fetch the current records.
+	AsOf time.Time
+	//Include is the name of the relations to load by Fetch.
+	Include []string
+	//Page fetch the page number (start from 1) of Limit records using offset instead
+	//of continue from the LastArgs. The Cursor returned by Fetch is the next page, it
+	//is the zero Cursor after the last page.
+	Page int
+	//Search fetch the records that match the search text, the table must be a
+	//Searchable. The records is ranked by the relevance if OrderBy is empty. Search
+	//can not be used with AsOf.
+	Search string
 }
 
 var sep = []byte("\n")
 
+//IsZero return true if the cursor is the zero Cursor, the Cursor returned by Fetch is
+//zero when there is no next records set.
+func (c Cursor) IsZero() bool {
+	return len(c.Fields) == 0 && len(c.Filters) == 0 && len(c.OrderBy) == 0 && c.Limit == 0 &&
+		!c.Descending && len(c.LastArgs) == 0 && c.AsOf.IsZero() && len(c.Include) == 0 &&
+		c.Page == 0 && c.Search == ""
+}
+
 func (c Cursor) String() string {
 	buf := &bytes.Buffer{}
 	buf.WriteString(strings.Join(c.Fields, ","))
@@ -57,6 +80,16 @@ func (c Cursor) String() string {
 	fmt.Fprintf(buf, "%t", c.Descending)
 	buf.Write(sep)
 	buf.WriteString(siToString(c.LastArgs))
+	buf.Write(sep)
+	if !c.AsOf.IsZero() {
+		buf.WriteString(c.AsOf.Format(time.RFC3339Nano))
+	}
+	buf.Write(sep)
+	buf.WriteString(strings.Join(c.Include, ","))
+	buf.Write(sep)
+	fmt.Fprintf(buf, "%d", c.Page)
+	buf.Write(sep)
+	buf.WriteString(url.QueryEscape(c.Search))
 	return base64.StdEncoding.EncodeToString(buf.Bytes())
 }
 
@@ -124,6 +157,30 @@ func Decode(s string) (Cursor, error) {
 			}
 		}
 	}
+	if len(ds) > 6 && len(ds[6]) != 0 {
+		asOf, err := time.Parse(time.RFC3339Nano, string(ds[6]))
+		if err != nil {
+			return c, err
+		}
+		c.AsOf = asOf
+	}
+	if len(ds) > 7 {
+		c.Include = decodeToSS(ds[7])
+	}
+	if len(ds) > 8 {
+		page, err := strconv.Atoi(string(ds[8]))
+		if err != nil {
+			return c, err
+		}
+		c.Page = page
+	}
+	if len(ds) > 9 {
+		search, err := url.QueryUnescape(string(ds[9]))
+		if err != nil {
+			return c, err
+		}
+		c.Search = search
+	}
 	return c, nil
 }
 
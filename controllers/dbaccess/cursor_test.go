@@ -3,6 +3,7 @@ package dbaccess
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestCursor(t *testing.T) {
@@ -27,6 +28,29 @@ func TestCursor(t *testing.T) {
 			Limit:    50,
 			LastArgs: []interface{}{"PCS", "1000"},
 		},
+		{
+			Fields:  []string{"id", "name"},
+			OrderBy: []string{"name"},
+			Limit:   50,
+			AsOf:    time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			Fields:  []string{"id", "name"},
+			OrderBy: []string{"name"},
+			Include: []string{"department"},
+		},
+		{
+			Fields:  []string{"id", "name"},
+			OrderBy: []string{"name"},
+			Limit:   20,
+			Page:    17,
+		},
+		{
+			Fields:   []string{"id", "name"},
+			OrderBy:  []string{ScoreField, "id"},
+			LastArgs: []interface{}{"0.5", "1000"},
+			Search:   "agus, tony\nlorem",
+		},
 	}
 	for i, c := range testData {
 		enc := c.String()
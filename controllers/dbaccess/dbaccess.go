@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 )
 
 //DBExecer is an interface that can run query or execute tot the database.
@@ -41,17 +42,34 @@ type Filterer interface {
 
 //Insert data from table to the database.
 func Insert(db DBExecer, t Table) error {
+	db = operation(db, "insert", t)
+	err := setTenant(db, t)
+	if err != nil {
+		return err
+	}
 	if t.HasAutoIncrementField() {
-		return insertAutoIncr(db, t)
+		err = insertAutoIncr(db, t)
+	} else {
+		fields, dst := t.Fields()
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", t.Name(), strings.Join(fields, ","), makePlaceHolder(1, len(dst)))
+		_, err = db.Exec(query, dst...)
 	}
-	fields, dst := t.Fields()
-	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", t.Name(), strings.Join(fields, ","), makePlaceHolder(1, len(dst)))
-	_, err := db.Exec(query, dst...)
-	return err
+	if err != nil {
+		return err
+	}
+	if h, ok := t.(Historian); ok {
+		return openVersion(db, h, now())
+	}
+	return nil
 }
 
 //Exists return true if there is a record match with the filters.
 func Exists(db DBExecer, t Table, filters ...Filter) (bool, error) {
+	db = operation(db, "exists", t)
+	filters, err := withTenant(db, t, filters)
+	if err != nil {
+		return false, err
+	}
 	where, args, err := whereClause(t, 1, filters...)
 	if err != nil {
 		return false, err
@@ -74,16 +92,33 @@ func insertAutoIncr(db DBExecer, t Table) error {
 
 //Update update the data on the database with the change.
 func Update(db DBExecer, t Table, change map[string]interface{}) error {
+	db = operation(db, "update", t)
 	set, args, err := setQuery(t, change)
 	if err != nil {
 		return err
 	}
-	fields, dst := t.PrimaryKey()
-	w := where(fields)
-	args = append(args, dst...)
+	w, wargs, err := keyWhere(db, t)
+	if err != nil {
+		return err
+	}
+	args = append(args, wargs...)
 	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s", t.Name(), set, w)
-	_, err = db.Exec(query, args...)
-	return err
+	if _, err = db.Exec(query, args...); err != nil {
+		return err
+	}
+	if h, ok := t.(Historian); ok {
+		return newVersion(db, h, now())
+	}
+	return nil
+}
+
+//newVersion close the current version of the record on the history table and copy
+//the record as the new current version.
+func newVersion(db DBExecer, h Historian, at time.Time) error {
+	if err := closeVersion(db, h, at); err != nil {
+		return err
+	}
+	return openVersion(db, h, at)
 }
 
 func setQuery(t Table, change map[string]interface{}) (set string, args []interface{}, err error) {
@@ -91,8 +126,15 @@ func setQuery(t Table, change map[string]interface{}) (set string, args []interf
 		err = errors.New("no changes to update")
 		return set, args, err
 	}
+	if err = checkTenantChange(t, change); err != nil {
+		return set, args, err
+	}
 	var sets []string
 	for k, v := range change {
+		//the key is put on the query, so it must be a field.
+		if err = isFieldsOK(t, []string{k}); err != nil {
+			return set, args, err
+		}
 		sets = append(sets, fmt.Sprintf("%s = ?", k))
 		args = append(args, v)
 
@@ -104,10 +146,14 @@ func setQuery(t Table, change map[string]interface{}) (set string, args []interf
 //DeleteAll delete all the records mathc with the filters,
 //if there is no filters, this will delete all the data from the table.
 func UpdateAll(db DBExecer, t Table, change map[string]interface{}, fs ...Filter) error {
+	db = operation(db, "update_all", t)
 	set, args, err := setQuery(t, change)
 	if err != nil {
 		return err
 	}
+	if fs, err = withTenant(db, t, fs); err != nil {
+		return err
+	}
 	count := len(change) + 1
 	c := Cursor{
 		Filters: fs,
@@ -123,102 +169,303 @@ func UpdateAll(db DBExecer, t Table, change map[string]interface{}, fs ...Filter
 	} else {
 		query = fmt.Sprintf("UPDATE %s SET %s", t.Name(), set)
 	}
-	_, err = db.Exec(query, args...)
-	return err
+	h, ok := t.(Historian)
+	if !ok {
+		_, err = db.Exec(query, args...)
+		return err
+	}
+	//the filters may not match after the update, so get the keys first.
+	keys, err := matchingKeys(db, h, fs...)
+	if err != nil {
+		return err
+	}
+	if _, err = db.Exec(query, args...); err != nil {
+		return err
+	}
+	at := now()
+	for _, key := range keys {
+		if err = newVersion(db, key.(Historian), at); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 //Fetch get all the records that match with the cursor.
 //Zero value cursor mean fetch will get all the records from the database.
 //Fetch support paging using limint on Cursor, and return the Cursor to get the nex records set.
+//The relations on Cursor.Include is loaded with Preload.
 func Fetch(db DBExecer, t Table, option Cursor) (result []Table, c Cursor, err error) {
+	db = operation(db, "fetch", t)
+	rows, err := FetchIter(db, t, option)
+	if err != nil {
+		return nil, option, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		result = append(result, rows.Table())
+	}
+	if err = rows.Err(); err != nil {
+		return nil, rows.Cursor(), err
+	}
+	if err = Preload(db, result, option.Include...); err != nil {
+		return nil, rows.Cursor(), err
+	}
+	return result, rows.Cursor(), nil
+}
+
+//FetchIter is like Fetch but return an iterator that scan the records one by one
+//instead of loading all the records to the memory. The Rows must be closed.
+//FetchIter does not load the relations on Cursor.Include, the caller can Preload
+//the tables.
+func FetchIter(db DBExecer, t Table, option Cursor) (*Rows, error) {
+	db = operation(db, "fetch", t)
 	if len(option.Fields) == 0 {
 		option.Fields, _ = t.Fields()
 	}
-	if err = isFieldsOK(t, option.Fields); err != nil {
-		return result, option, err
+	if len(option.Include) != 0 {
+		relFields, err := relationFields(t, option.Include)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range relFields {
+			if _, exist := fieldExist(option.Fields, field); !exist {
+				option.Fields = append(option.Fields, field)
+			}
+		}
+	}
+	if err := isFieldsOK(t, option.Fields); err != nil {
+		return nil, err
 	}
-	if err = isFieldsOK(t, option.OrderBy); err != nil {
-		return result, option, err
+	if option.Search != "" && len(option.OrderBy) == 0 {
+		//rank by the relevance.
+		option.OrderBy = []string{ScoreField}
+		option.Descending = true
+	}
+	if err := isOrderOK(t, option); err != nil {
+		return nil, err
 	}
 	option.OrderBy = addUniqueField(t, option.OrderBy)
-	query, whereArgs, err := queryFromCursor(t, option)
+	if option.Page > 0 {
+		if option.Limit < 1 {
+			return nil, errors.New("page pagination need limit")
+		}
+		//page pagination does not continue from the last record.
+		option.LastArgs = nil
+	}
+	//the tenant filter is not kept on the Cursor returned to the caller.
+	q := option
+	var err error
+	if q.Filters, err = withTenant(db, t, option.Filters); err != nil {
+		return nil, err
+	}
+	query, whereArgs, err := queryFromCursor(t, q)
 	if err != nil {
-		return nil, option, err
+		return nil, err
 	}
-	// fmt.Printf("query:%s\n", query)
-	// for _, v := range option.LastArgs {
-	// 	fmt.Printf("%s\n", *v.(*string))
-	// }
-	// fmt.Println(option.LastArgs...)
 	rows, err := db.Query(query, append(whereArgs, option.LastArgs...)...)
 	if err != nil {
-		return nil, option, err
+		return nil, err
 	}
-	result, c, err = scanRows(t, rows, option)
-	return result, c, err
-}
-
-//scanRows scan the rows and close it.
-func scanRows(t Table, rows *sql.Rows, c Cursor) (result []Table, cursor Cursor, err error) {
-	defer rows.Close()
 	cols, err := rows.Columns()
 	if err != nil {
-		return result, c, err
+		rows.Close()
+		return nil, err
 	}
-	cursor = c
-	var last Table
-	for rows.Next() {
-		tbl := t.New()
-		fields, dst := tbl.Fields()
-		if len(fields) != len(cols) {
-			dst, err = scanArgs(tbl, cols)
-			if err != nil {
-				return result, cursor, err
+	if option.Search != "" {
+		//the last column is the ScoreField.
+		cols = cols[:len(cols)-1]
+	}
+	return &Rows{t: t, rows: rows, cols: cols, cursor: option}, nil
+}
+
+//isOrderOK check the OrderBy fields, ScoreField can only be used with Search.
+func isOrderOK(t Table, c Cursor) error {
+	for _, field := range c.OrderBy {
+		if field == ScoreField {
+			if c.Search == "" {
+				return fmt.Errorf("order by %s need search", ScoreField)
 			}
+			continue
 		}
-		if err = rows.Scan(dst...); err != nil {
-			return nil, cursor, err
+		if err := isFieldsOK(t, []string{field}); err != nil {
+			return err
 		}
-		result = append(result, tbl)
-		last = tbl
 	}
-	if err = rows.Err(); err != nil {
-		return result, cursor, err
+	return nil
+}
+
+//Rows is the iterator of the records returned by FetchIter.
+//	rows, err := FetchIter(db, t, cursor)
+//	...
+//	defer rows.Close()
+//	for rows.Next() {
+//		t := rows.Table()
+//		...
+//	}
+//	err = rows.Err()
+//	cursor = rows.Cursor()
+type Rows struct {
+	t       Table
+	rows    *sql.Rows
+	cols    []string
+	cursor  Cursor
+	current Table
+	last    Table
+	//score is the ScoreField of the last record.
+	score float64
+	//n is the count of the scanned records.
+	n   int
+	err error
+}
+
+//Next scan the next record, it return false when there is no more record or an
+//error happen, Err return the error.
+func (r *Rows) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	if !r.rows.Next() {
+		r.err = r.rows.Err()
+		r.rows.Close()
+		return false
+	}
+	tbl := r.t.New()
+	fields, dst := tbl.Fields()
+	if len(fields) != len(r.cols) {
+		dst, r.err = scanArgs(tbl, r.cols)
+		if r.err != nil {
+			r.rows.Close()
+			return false
+		}
 	}
-	if last != nil {
-		cursor.LastArgs, err = scanArgs(last, cursor.OrderBy)
+	if r.cursor.Search != "" {
+		dst = append(dst, &r.score)
+	}
+	if r.err = r.rows.Scan(dst...); r.err != nil {
+		r.rows.Close()
+		return false
+	}
+	r.current = tbl
+	r.last = tbl
+	r.n++
+	return true
+}
+
+//Table return the record scanned by Next.
+func (r *Rows) Table() Table {
+	return r.current
+}
+
+//Err return the error happen during the iteration.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+//Close close the rows, it is safe to call Close multiple time.
+func (r *Rows) Close() error {
+	return r.rows.Close()
+}
+
+//Cursor return the Cursor to get the next records set, call it after Next return false.
+//The Cursor of the page pagination is zero when the last page is read, there is no
+//next records set.
+func (r *Rows) Cursor() Cursor {
+	c := r.cursor
+	if c.Page > 0 {
+		if r.n < c.Limit {
+			return Cursor{}
+		}
+		c.Page++
+		return c
+	}
+	if r.last != nil {
+		args := make([]interface{}, len(c.OrderBy))
+		for i, field := range c.OrderBy {
+			if field == ScoreField {
+				args[i] = r.score
+				continue
+			}
+			arg, err := scanArgs(r.last, []string{field})
+			if err != nil {
+				return c
+			}
+			args[i] = arg[0]
+		}
+		c.LastArgs = args
 	}
-	return result, cursor, err
+	return c
+}
+
+//source return the table name used on the FROM of the cursor query, the history
+//table for AsOf and the searched records with the ScoreField for Search.
+func source(t Table, c Cursor) (string, []interface{}, error) {
+	name := t.Name()
+	h, ok := t.(Historian)
+	if !ok && !c.AsOf.IsZero() {
+		return "", nil, fmt.Errorf("table:%s does not keep history", t.Name())
+	}
+	if ok && !c.AsOf.IsZero() {
+		name = h.HistoryName()
+	}
+	if c.Search == "" {
+		return name, nil, nil
+	}
+	if !c.AsOf.IsZero() {
+		//the history table does not have the full-text index.
+		return "", nil, fmt.Errorf("table:%s can not be searched as of a time", t.Name())
+	}
+	fields, err := searchFields(t)
+	if err != nil {
+		return "", nil, err
+	}
+	//the searched records is wrapped so the ScoreField can be used on the filter and
+	//the order like the other fields.
+	from := fmt.Sprintf("(SELECT *, %s AS %s FROM %s WHERE %s) AS %s",
+		SearchDialect.SearchScore(name, fields), ScoreField, name,
+		SearchDialect.SearchMatch(name, fields), name)
+	return from, []interface{}{c.Search, c.Search}, nil
 }
 
 func queryFromCursor(t Table, c Cursor) (string, []interface{}, error) {
-	where, queryArgs, err := filters(t, c, 1)
+	name, queryArgs, err := source(t, c)
 	if err != nil {
 		return "", nil, err
 	}
+	where, whereArgs, err := filters(t, c, 1)
+	if err != nil {
+		return "", nil, err
+	}
+	queryArgs = append(queryArgs, whereArgs...)
+	fields := c.Fields
+	if c.Search != "" {
+		fields = append(fields[:len(fields):len(fields)], ScoreField)
+	}
 	var limit string
 	if c.Limit != 0 {
 		limit = fmt.Sprintf("LIMIT %d ", c.Limit)
 	}
+	if c.Page > 0 {
+		limit = fmt.Sprintf("%sOFFSET %d ", limit, (c.Page-1)*c.Limit)
+	}
 	var query string
 	if where == "" {
 		if c.Descending {
 			query = fmt.Sprintf("SELECT %s FROM %s ORDER BY (%s) DESC %s",
-				strings.Join(c.Fields, ","), t.Name(), strings.Join(c.OrderBy, ","), limit)
+				strings.Join(fields, ","), name, strings.Join(c.OrderBy, ","), limit)
 		} else {
 			query = fmt.Sprintf("SELECT %s FROM %s ORDER BY (%s) %s",
-				strings.Join(c.Fields, ","), t.Name(), strings.Join(c.OrderBy, ","), limit)
+				strings.Join(fields, ","), name, strings.Join(c.OrderBy, ","), limit)
 		}
 	} else {
 		if c.Descending {
 			query = fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY (%s) DESC %s",
-				strings.Join(c.Fields, ","), t.Name(), where, strings.Join(c.OrderBy, ","), limit)
+				strings.Join(fields, ","), name, where, strings.Join(c.OrderBy, ","), limit)
 		} else {
 			query = fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY (%s) %s",
-				strings.Join(c.Fields, ","), t.Name(), where, strings.Join(c.OrderBy, ","), limit)
+				strings.Join(fields, ","), name, where, strings.Join(c.OrderBy, ","), limit)
 		}
 	}
-	// fmt.Printf("query:%s\n", query)
 	return query, queryArgs, nil
 }
 
@@ -228,6 +475,16 @@ func filters(t Table, c Cursor, start int) (where string, queryArgs []interface{
 		return where, queryArgs, err
 	}
 	start += len(c.Filters)
+	if !c.AsOf.IsZero() {
+		asOf := fmt.Sprintf("%s <= ? AND %s > ?", ValidFromField, ValidToField)
+		if where == "" {
+			where = asOf
+		} else {
+			where = fmt.Sprintf("%s AND %s", where, asOf)
+		}
+		queryArgs = append(queryArgs, c.AsOf, c.AsOf)
+		start += 2
+	}
 	if len(c.LastArgs) != 0 {
 		desc := ">"
 		if c.Descending {
@@ -319,16 +576,41 @@ func scanArgs(t Table, fields []string) (args []interface{}, err error) {
 
 //Delete delete one record from the database that match with the PrimaryKey value.
 func Delete(db DBExecer, t Table) error {
-	fields, dst := t.PrimaryKey()
-	w := where(fields)
+	db = operation(db, "delete", t)
+	w, args, err := keyWhere(db, t)
+	if err != nil {
+		return err
+	}
 	query := fmt.Sprintf("DELETE FROM %s where %s", t.Name(), w)
-	_, err := db.Exec(query, dst...)
-	return err
+	if _, err := db.Exec(query, args...); err != nil {
+		return err
+	}
+	if h, ok := t.(Historian); ok {
+		return closeVersion(db, h, now())
+	}
+	return nil
 }
 
 //DeleteAll delete all the records mathc with the filters,
 //if there is no filters, this will delete all the data from the table.
 func DeleteAll(db DBExecer, t Table, fs ...Filter) error {
+	db = operation(db, "delete_all", t)
+	fs, err := withTenant(db, t, fs)
+	if err != nil {
+		return err
+	}
+	if h, ok := t.(Historian); ok {
+		keys, err := matchingKeys(db, h, fs...)
+		if err != nil {
+			return err
+		}
+		at := now()
+		for _, key := range keys {
+			if err = closeVersion(db, key.(Historian), at); err != nil {
+				return err
+			}
+		}
+	}
 	if len(fs) == 0 {
 		_, err := db.Exec("DELETE FROM " + t.Name())
 		return err
@@ -342,19 +624,25 @@ func DeleteAll(db DBExecer, t Table, fs ...Filter) error {
 		return err
 	}
 	query := fmt.Sprintf("DELETE FROM %s WHERE %s", t.Name(), where)
-	// fmt.Println(query)
 	_, err = db.Exec(query, args...)
 	return err
 }
 
-//Get get one record from the database that match with table PrimaryKey value.
-func Get(db DBExecer, t Table) error {
+//Get get one record from the database that match with table PrimaryKey value,
+//and load the relations on include.
+func Get(db DBExecer, t Table, include ...string) error {
+	db = operation(db, "get", t)
 	fields, dst := t.Fields()
-	pkf, pkd := t.PrimaryKey()
-	w := where(pkf)
+	w, args, err := keyWhere(db, t)
+	if err != nil {
+		return err
+	}
 	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(fields, ","), t.Name(), w)
-	err := db.QueryRow(query, pkd...).Scan(dst...)
-	return err
+	err = db.QueryRow(query, args...).Scan(dst...)
+	if err != nil || len(include) == 0 {
+		return err
+	}
+	return Preload(db, []Table{t}, include...)
 }
 
 func where(fields []string) string {
@@ -54,7 +54,7 @@ func (t *testTable) Name() string {
 
 func (t *testTable) PrimaryKey() (fields []string, dst []interface{}) {
 	fields = []string{"code"}
-	dst = []interface{}{t.Code}
+	dst = []interface{}{&t.Code}
 	return
 }
 
@@ -134,6 +134,9 @@ func prepareTest(t *testing.T) *sql.DB {
 	if err := createTable(db, testTableAutoSql); err != nil {
 		t.Fatal(err)
 	}
+	if err := createTable(db, testTableHistSql); err != nil {
+		t.Fatal(err)
+	}
 	return db
 }
 
@@ -563,6 +566,113 @@ func TestFetchFilter(t *testing.T) {
 	}
 }
 
+func TestFetchIter(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	nd := time.Now()
+	now := time.Date(nd.Year(), nd.Month(), nd.Day(), 0, 0, 0, 0, time.UTC)
+	data := []*testTable{
+		{Code: "AA", Description: "AA Karawaci", TransactionDate: now, Amount: 100.00, Count: 1},
+		{Code: "AB", Description: "AB Karawaci", TransactionDate: now, Amount: 100.00, Count: 1},
+		{Code: "AC", Description: "AC Karawaci", TransactionDate: now, Amount: 100.00, Count: 1},
+	}
+	for _, v := range data {
+		if err := Insert(db, v); err != nil {
+			t.Fatalf("insert  data:%v err:%v", v, err)
+		}
+	}
+	wants := [][]*testTable{data[:2], data[2:], nil}
+	c := Cursor{Limit: 2}
+	for i, want := range wants {
+		rows, err := FetchIter(db, data[0], c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []Table
+		for rows.Next() {
+			got = append(got, rows.Table())
+		}
+		if err = rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+		c = rows.Cursor()
+		compareResults(t, 0, i, got, want, nil)
+	}
+}
+
+func TestFetchPage(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	nd := time.Now()
+	now := time.Date(nd.Year(), nd.Month(), nd.Day(), 0, 0, 0, 0, time.UTC)
+	data := []*testTable{
+		{Code: "AA", Description: "AA Karawaci", TransactionDate: now, Amount: 100.00, Count: 1},
+		{Code: "AB", Description: "AB Karawaci", TransactionDate: now, Amount: 100.00, Count: 1},
+		{Code: "AC", Description: "AC Karawaci", TransactionDate: now, Amount: 100.00, Count: 2},
+		{Code: "BA", Description: "BA Karawaci", TransactionDate: now, Amount: 100.00, Count: 1},
+		{Code: "BB", Description: "BB Karawaci", TransactionDate: now, Amount: 100.00, Count: 1},
+	}
+	for _, v := range data {
+		if err := Insert(db, v); err != nil {
+			t.Fatalf("insert  data:%v err:%v", v, err)
+		}
+	}
+	testCase := []struct {
+		c     Cursor
+		page  Page
+		wants [][]*testTable
+	}{
+		{
+			c:     Cursor{Limit: 2, Page: 1},
+			page:  Page{Number: 1, PerPage: 2, Total: 5, TotalPages: 3},
+			wants: [][]*testTable{data[:2], data[2:4], data[4:]},
+		},
+		{
+			c:     Cursor{Limit: 2, Page: 3, Descending: true},
+			page:  Page{Number: 3, PerPage: 2, Total: 5, TotalPages: 3},
+			wants: [][]*testTable{{data[0]}},
+		},
+		{
+			c:     Cursor{Limit: 3, Page: 1, Filters: []Filter{{Field: "count", Op: "=", Value: 1}}},
+			page:  Page{Number: 1, PerPage: 3, Total: 4, TotalPages: 2},
+			wants: [][]*testTable{{data[0], data[1], data[3]}, {data[4]}},
+		},
+	}
+	for i, tc := range testCase {
+		page, err := PageOf(db, data[0], tc.c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page != tc.page {
+			t.Errorf("tc:%d got page:%+v want:%+v", i, page, tc.page)
+		}
+		c := tc.c
+		for j, want := range tc.wants {
+			var got []Table
+			got, c, err = Fetch(db, data[0], c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareResults(t, i, j, got, want, nil)
+		}
+		if !c.IsZero() {
+			t.Errorf("tc:%d got cursor:%+v after the last page want no next page", i, c)
+		}
+	}
+	//the full last page has the next page, it is empty.
+	got, c, err := Fetch(db, data[0], Cursor{Limit: 5, Page: 1})
+	if err != nil || len(got) != 5 || c.Page != 2 {
+		t.Errorf("got records:%d cursor:%+v err:%v want 5 records and page 2", len(got), c, err)
+	}
+	if got, c, err = Fetch(db, data[0], c); err != nil || len(got) != 0 || !c.IsZero() {
+		t.Errorf("got records:%d cursor:%+v err:%v want no records and no next page", len(got), c, err)
+	}
+	if _, _, err := Fetch(db, data[0], Cursor{Page: 1}); err == nil {
+		t.Error("page without limit want error got nil")
+	}
+}
+
 func compareResults(t *testing.T, tc, td int, got []Table, want []*testTable, fields []string) {
 	if len(got) != len(want) {
 		t.Fatalf("tc:%d td:%d data got:%d want:%d", tc, td, len(got), len(want))
@@ -0,0 +1,184 @@
+package dbaccess
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	//ValidFromField is the column on the history table that store the time
+	//the row version become valid.
+	ValidFromField = "valid_from"
+	//ValidToField is the column on the history table that store the time
+	//the row version replaced by the next version or deleted.
+	ValidToField = "valid_to"
+)
+
+//EndOfTime is the valid_to value of the current version of a record.
+var EndOfTime = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+//now return the time used as the validity boundary of the versions.
+var now = func() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+//Historian is a Table that keep every version of its records on a history table.
+//The history table has all the Fields of the table plus ValidFromField and
+//ValidToField (DATETIME(6)), Insert, Update, Delete, UpdateAll and DeleteAll
+//maintain the history table automatically.
+type Historian interface {
+	Table
+	//HistoryName return the name of the history table on the database.
+	HistoryName() string
+}
+
+//Change is the difference of one field between two versions of a record.
+type Change struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+//openVersion copy the current record that match the PrimaryKey to the history table.
+func openVersion(db DBExecer, h Historian, at time.Time) error {
+	fields, _ := h.Fields()
+	w, wargs, err := keyWhere(db, h)
+	if err != nil {
+		return err
+	}
+	cols := strings.Join(fields, ",")
+	query := fmt.Sprintf("INSERT INTO %s (%s,%s,%s) SELECT %s,?,? FROM %s WHERE %s",
+		h.HistoryName(), cols, ValidFromField, ValidToField, cols, h.Name(), w)
+	args := append([]interface{}{at, EndOfTime}, wargs...)
+	_, err = db.Exec(query, args...)
+	return err
+}
+
+//closeVersion end the validity of the current version of the record that match
+//the PrimaryKey.
+func closeVersion(db DBExecer, h Historian, at time.Time) error {
+	w, wargs, err := keyWhere(db, h)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s AND %s = ?",
+		h.HistoryName(), ValidToField, w, ValidToField)
+	args := append([]interface{}{at}, wargs...)
+	args = append(args, EndOfTime)
+	_, err = db.Exec(query, args...)
+	return err
+}
+
+//SeedHistory open the version of every record that does not have the current version
+//on the history table, the version is valid from now. It is used when the history is
+//enabled on the table that already has records, the records before it does not have
+//a version until the next write.
+func SeedHistory(db DBExecer, h Historian) error {
+	db = operation(db, "seed_history", h)
+	fields, _ := h.Fields()
+	keys, _ := h.PrimaryKey()
+	if ts, ok := h.(TenantScoped); ok {
+		keys = append(keys[:len(keys):len(keys)], ts.TenantField())
+	}
+	conds := make([]string, len(keys))
+	for i, k := range keys {
+		conds[i] = fmt.Sprintf("v.%s = t.%s", k, k)
+	}
+	cols := strings.Join(fields, ",")
+	query := fmt.Sprintf("INSERT INTO %s (%s,%s,%s) SELECT %s,?,? FROM %s t WHERE NOT EXISTS "+
+		"(SELECT 1 FROM %s v WHERE %s AND v.%s = ?)",
+		h.HistoryName(), cols, ValidFromField, ValidToField, cols, h.Name(),
+		h.HistoryName(), strings.Join(conds, " AND "), ValidToField)
+	_, err := db.Exec(query, now(), EndOfTime, EndOfTime)
+	return err
+}
+
+//matchingKeys return a Table for every record that match with the filters, only the
+//PrimaryKey of the returned Table is set.
+func matchingKeys(db DBExecer, h Historian, fs ...Filter) ([]Table, error) {
+	pkf, _ := h.PrimaryKey()
+	c := Cursor{Fields: pkf, OrderBy: pkf, Filters: fs}
+	result, _, err := Fetch(db, h, c)
+	return result, err
+}
+
+//GetAsOf get the version of the record that was valid at the time at.
+func GetAsOf(db DBExecer, t Table, at time.Time) error {
+	db = operation(db, "get_as_of", t)
+	h, ok := t.(Historian)
+	if !ok {
+		return fmt.Errorf("table:%s does not keep history", t.Name())
+	}
+	fields, dst := t.Fields()
+	w, args, err := keyWhere(db, t)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s AND %s <= ? AND %s > ?",
+		strings.Join(fields, ","), h.HistoryName(), w, ValidFromField, ValidToField)
+	args = append(args, at, at)
+	return db.QueryRow(query, args...).Scan(dst...)
+}
+
+//Diff compare the version of the record valid at from with the version valid at to,
+//and return the fields that differ. A field of a version that does not exist is nil.
+//Diff return sql.ErrNoRows if the record does not exist on both time.
+func Diff(db DBExecer, t Table, from, to time.Time) ([]Change, error) {
+	db = operation(db, "diff", t)
+	versions := make([]Table, 2)
+	found := false
+	for i, at := range []time.Time{from, to} {
+		v := t.New()
+		if err := copyKey(v, t); err != nil {
+			return nil, err
+		}
+		err := GetAsOf(db, v, at)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		versions[i] = v
+		found = true
+	}
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+	fields, _ := t.Fields()
+	var changes []Change
+	for i, field := range fields {
+		from := fieldValue(versions[0], i)
+		to := fieldValue(versions[1], i)
+		if !reflect.DeepEqual(from, to) {
+			changes = append(changes, Change{Field: field, From: from, To: to})
+		}
+	}
+	return changes, nil
+}
+
+//fieldValue return the value of the field at index, nil if t is nil.
+func fieldValue(t Table, index int) interface{} {
+	if t == nil {
+		return nil
+	}
+	_, dst := t.Fields()
+	return reflect.Indirect(reflect.ValueOf(dst[index])).Interface()
+}
+
+//copyKey set the PrimaryKey of dst with the PrimaryKey values of src.
+func copyKey(dst, src Table) error {
+	_, dd := dst.PrimaryKey()
+	_, sd := src.PrimaryKey()
+	for i := range dd {
+		dv := reflect.ValueOf(dd[i])
+		if dv.Kind() != reflect.Ptr {
+			return fmt.Errorf("table:%s PrimaryKey dst must be pointer", dst.Name())
+		}
+		dv.Elem().Set(reflect.Indirect(reflect.ValueOf(sd[i])))
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package dbaccess
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+var testTableHistSql = table{
+	Name: "test_table_history",
+	Fields: []string{
+		"code VARCHAR(30)",
+		"description VARCHAR(100)",
+		"transaction_date DATE",
+		"amount NUMERIC",
+		"count INTEGER",
+		"valid_from DATETIME(6)",
+		"valid_to DATETIME(6)",
+	},
+	PrimaryKey: "(code, valid_from)",
+}
+
+type testTableHist struct {
+	testTable
+}
+
+func (t *testTableHist) HistoryName() string {
+	return "test_table_history"
+}
+
+func (t *testTableHist) New() Table {
+	return &testTableHist{}
+}
+
+func TestHistory(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	defer func(f func() time.Time) { now = f }(now)
+	nd := time.Now()
+	day := time.Date(nd.Year(), nd.Month(), nd.Day(), 0, 0, 0, 0, time.UTC)
+	t1 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(24 * time.Hour)
+	t3 := t2.Add(24 * time.Hour)
+	td := &testTableHist{testTable{Code: "KW", Description: "Karawaci", TransactionDate: day, Amount: 100, Count: 1}}
+
+	now = func() time.Time { return t1 }
+	if err := Insert(db, td); err != nil {
+		t.Fatal(err)
+	}
+	now = func() time.Time { return t2 }
+	if err := Update(db, td, map[string]interface{}{"description": "Tangerang"}); err != nil {
+		t.Fatal(err)
+	}
+	now = func() time.Time { return t3 }
+	if err := Delete(db, td); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("GetAsOf", func(t *testing.T) {
+		testCase := []struct {
+			at   time.Time
+			want string
+			err  error
+		}{
+			{at: t1.Add(-time.Second), err: sql.ErrNoRows},
+			{at: t1, want: "Karawaci"},
+			{at: t2.Add(-time.Second), want: "Karawaci"},
+			{at: t2, want: "Tangerang"},
+			{at: t3, err: sql.ErrNoRows},
+		}
+		for i, tc := range testCase {
+			got := &testTableHist{testTable{Code: td.Code}}
+			err := GetAsOf(db, got, tc.at)
+			if err != tc.err {
+				t.Fatalf("tc:%d got err:%v want:%v", i, err, tc.err)
+			}
+			if err == nil && got.Description != tc.want {
+				t.Errorf("tc:%d got description:%s want:%s", i, got.Description, tc.want)
+			}
+		}
+	})
+
+	t.Run("FetchAsOf", func(t *testing.T) {
+		got, _, err := Fetch(db, td, Cursor{AsOf: t2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 {
+			t.Fatalf("got data:%d want:1", len(got))
+		}
+		if d := got[0].(*testTableHist).Description; d != "Tangerang" {
+			t.Errorf("got description:%s want:Tangerang", d)
+		}
+		if _, _, err = Fetch(db, &testTable{}, Cursor{AsOf: t2}); err == nil {
+			t.Error("fetch as of on table without history want error got nil")
+		}
+	})
+
+	t.Run("Diff", func(t *testing.T) {
+		changes, err := Diff(db, td, t1, t2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 1 {
+			t.Fatalf("got changes:%v want 1 change", changes)
+		}
+		if c := changes[0]; c.Field != "description" || c.From != "Karawaci" || c.To != "Tangerang" {
+			t.Errorf("got change:%+v", c)
+		}
+		changes, err = Diff(db, td, t2, t3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields, _ := td.Fields()
+		if len(changes) != len(fields) {
+			t.Errorf("got changes:%d want:%d", len(changes), len(fields))
+		}
+	})
+}
+
+func TestSeedHistory(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	defer func(f func() time.Time) { now = f }(now)
+	t1 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	nd := time.Now()
+	day := time.Date(nd.Year(), nd.Month(), nd.Day(), 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return t1 }
+	//the record inserted before the history is enabled.
+	old := &testTable{Code: "OLD", Description: "Old", TransactionDate: day, Amount: 100, Count: 1}
+	if err := Insert(db, old); err != nil {
+		t.Fatal(err)
+	}
+	kept := &testTableHist{testTable{Code: "NEW", Description: "New", TransactionDate: day, Amount: 100, Count: 1}}
+	if err := Insert(db, kept); err != nil {
+		t.Fatal(err)
+	}
+	now = func() time.Time { return t1.Add(time.Hour) }
+	//seed twice, the record that has the current version is not seeded again.
+	for i := 0; i < 2; i++ {
+		if err := SeedHistory(db, &testTableHist{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := &testTableHist{testTable{Code: old.Code}}
+	if err := GetAsOf(db, got, t1.Add(time.Hour)); err != nil || got.Description != old.Description {
+		t.Errorf("got:%+v err:%v want the seeded version", got, err)
+	}
+	if err := GetAsOf(db, got, t1); err != sql.ErrNoRows {
+		t.Errorf("got err:%v before the seed want:%v", err, sql.ErrNoRows)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM test_table_history").Scan(&n); err != nil || n != 2 {
+		t.Errorf("got versions:%d err:%v want:2", n, err)
+	}
+}
@@ -0,0 +1,209 @@
+package dbaccess
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//keySep separate the values of composite PrimaryKey on the url.
+const keySep = ","
+
+//SetKey set the PrimaryKey of the table with the values, the values must be in the
+//PrimaryKey fields order. The value is converted to the type of the PrimaryKey dst,
+//so the value from the url path (string) or from JSON (float64, json.Number) can be
+//used for any string, integer, float, bool or time.Time key.
+func SetKey(t Table, values ...interface{}) error {
+	fields, dst := t.PrimaryKey()
+	if len(values) != len(dst) {
+		return fmt.Errorf("table:%s PrimaryKey (%s) need %d values got %d", t.Name(),
+			strings.Join(fields, ","), len(dst), len(values))
+	}
+	for i, v := range values {
+		if err := convertKey(dst[i], v); err != nil {
+			return fmt.Errorf("table:%s field:%s %v", t.Name(), fields[i], err)
+		}
+	}
+	return nil
+}
+
+//SetFields set the fields of the table with the values, the value is converted like
+//SetKey and nil set the zero value of the field.
+func SetFields(t Table, values map[string]interface{}) error {
+	fields, dst := t.Fields()
+	for field, v := range values {
+		i := -1
+		for j, f := range fields {
+			if f == field {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return fmt.Errorf("table:%s does not have field:%s", t.Name(), field)
+		}
+		switch v.(type) {
+		case nil:
+			dv := reflect.ValueOf(dst[i]).Elem()
+			dv.Set(reflect.Zero(dv.Type()))
+			continue
+		case map[string]interface{}, []interface{}:
+			return fmt.Errorf("table:%s field:%s can not be set to %T", t.Name(), field, v)
+		}
+		if err := convertKey(dst[i], v); err != nil {
+			return fmt.Errorf("table:%s field:%s %v", t.Name(), field, err)
+		}
+	}
+	return nil
+}
+
+//KeyValues return the values of the PrimaryKey of the table.
+func KeyValues(t Table) []interface{} {
+	_, dst := t.PrimaryKey()
+	values := make([]interface{}, len(dst))
+	for i, d := range dst {
+		values[i] = reflect.Indirect(reflect.ValueOf(d)).Interface()
+	}
+	return values
+}
+
+//FormatKey return the PrimaryKey of the table as url path segment, the values of the
+//composite PrimaryKey is separated by comma.
+func FormatKey(t Table) string {
+	values := KeyValues(t)
+	s := make([]string, len(values))
+	for i, v := range values {
+		if tm, ok := v.(time.Time); ok {
+			v = tm.Format(time.RFC3339Nano)
+		}
+		s[i] = strings.Replace(url.PathEscape(fmt.Sprint(v)), keySep, "%2C", -1)
+	}
+	return strings.Join(s, keySep)
+}
+
+//ParseKey split the url path segment formatted by FormatKey to the PrimaryKey values.
+func ParseKey(segment string) ([]interface{}, error) {
+	parts := strings.Split(segment, keySep)
+	values := make([]interface{}, len(parts))
+	for i, p := range parts {
+		v, err := url.PathUnescape(p)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+//convertKey store the value v to the pointer dst.
+func convertKey(dst, v interface{}) error {
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("dst must be pointer got %T", dst)
+	}
+	dv = dv.Elem()
+	if n, ok := v.(json.Number); ok {
+		v = n.String()
+	}
+	if tm, ok := dv.Addr().Interface().(*time.Time); ok {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("can not convert %T to time", v)
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			if parsed, err = time.Parse("2006-01-02", s); err != nil {
+				return err
+			}
+		}
+		*tm = parsed
+		return nil
+	}
+	switch dv.Kind() {
+	case reflect.String:
+		switch x := v.(type) {
+		case string:
+			dv.SetString(x)
+		case float64:
+			dv.SetString(strconv.FormatFloat(x, 'f', -1, 64))
+		default:
+			dv.SetString(fmt.Sprint(x))
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch x := v.(type) {
+		case string:
+			var err error
+			if i, err = strconv.ParseInt(x, 10, dv.Type().Bits()); err != nil {
+				return err
+			}
+		case float64:
+			if x != float64(int64(x)) {
+				return fmt.Errorf("%v is not an integer", x)
+			}
+			i = int64(x)
+		default:
+			return fmt.Errorf("can not convert %T to %s", v, dv.Type())
+		}
+		if dv.OverflowInt(i) {
+			return fmt.Errorf("%d overflow %s", i, dv.Type())
+		}
+		dv.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		switch x := v.(type) {
+		case string:
+			var err error
+			if u, err = strconv.ParseUint(x, 10, dv.Type().Bits()); err != nil {
+				return err
+			}
+		case float64:
+			if x < 0 || x != float64(uint64(x)) {
+				return fmt.Errorf("%v is not an unsigned integer", x)
+			}
+			u = uint64(x)
+		default:
+			return fmt.Errorf("can not convert %T to %s", v, dv.Type())
+		}
+		if dv.OverflowUint(u) {
+			return fmt.Errorf("%d overflow %s", u, dv.Type())
+		}
+		dv.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch x := v.(type) {
+		case string:
+			f, err := strconv.ParseFloat(x, dv.Type().Bits())
+			if err != nil {
+				return err
+			}
+			dv.SetFloat(f)
+		case float64:
+			dv.SetFloat(x)
+		default:
+			return fmt.Errorf("can not convert %T to %s", v, dv.Type())
+		}
+		return nil
+	case reflect.Bool:
+		switch x := v.(type) {
+		case string:
+			b, err := strconv.ParseBool(x)
+			if err != nil {
+				return err
+			}
+			dv.SetBool(b)
+		case bool:
+			dv.SetBool(x)
+		default:
+			return fmt.Errorf("can not convert %T to %s", v, dv.Type())
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type %s", dv.Type())
+}
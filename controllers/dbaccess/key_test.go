@@ -0,0 +1,212 @@
+package dbaccess
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var testTableLineSql = table{
+	Name: "test_table_line",
+	Fields: []string{
+		"code VARCHAR(30)",
+		"line INTEGER",
+		"description VARCHAR(100)",
+	},
+	PrimaryKey: "(code, line)",
+}
+
+type testTableLine struct {
+	Code        string `json:"code"`
+	Line        int    `json:"line"`
+	Description string `json:"description"`
+}
+
+func (t *testTableLine) Name() string {
+	return "test_table_line"
+}
+
+func (t *testTableLine) PrimaryKey() (fields []string, dst []interface{}) {
+	fields = []string{"code", "line"}
+	dst = []interface{}{&t.Code, &t.Line}
+	return
+}
+
+func (t *testTableLine) HasAutoIncrementField() bool {
+	return false
+}
+
+func (t *testTableLine) New() Table {
+	return &testTableLine{}
+}
+
+func (t *testTableLine) Fields() (fields []string, dst []interface{}) {
+	fields = []string{"code", "line", "description"}
+	dst = []interface{}{&t.Code, &t.Line, &t.Description}
+	return
+}
+
+func TestCompositeKeyCRUD(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	if err := createTable(db, testTableLineSql); err != nil {
+		t.Fatal(err)
+	}
+	data := []*testTableLine{
+		{Code: "KW", Line: 1, Description: "Karawaci 1"},
+		{Code: "KW", Line: 2, Description: "Karawaci 2"},
+		{Code: "CK", Line: 1, Description: "Cikupa 1"},
+	}
+	for _, v := range data {
+		if err := Insert(db, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		for _, v := range data {
+			got := &testTableLine{}
+			values, err := ParseKey(FormatKey(v))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = SetKey(got, values...); err != nil {
+				t.Fatal(err)
+			}
+			if err = Get(db, got); err != nil {
+				t.Fatal(err)
+			}
+			if *got != *v {
+				t.Errorf("got:%v want:%v", got, v)
+			}
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		td := &testTableLine{}
+		//the key from JSON.
+		if err := SetKey(td, "KW", float64(2)); err != nil {
+			t.Fatal(err)
+		}
+		if err := Update(db, td, map[string]interface{}{"description": "Updated"}); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range data {
+			got := &testTableLine{Code: v.Code, Line: v.Line}
+			if err := Get(db, got); err != nil {
+				t.Fatal(err)
+			}
+			want := v.Description
+			if v.Code == "KW" && v.Line == 2 {
+				want = "Updated"
+			}
+			if got.Description != want {
+				t.Errorf("key:%s got description:%s want:%s", FormatKey(v), got.Description, want)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := Delete(db, &testTableLine{Code: "KW", Line: 1}); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range data {
+			err := Get(db, &testTableLine{Code: v.Code, Line: v.Line})
+			if v.Code == "KW" && v.Line == 1 {
+				if err != sql.ErrNoRows {
+					t.Errorf("got err:%v want:%v", err, sql.ErrNoRows)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("key:%s got err:%v want:nil", FormatKey(v), err)
+			}
+		}
+	})
+}
+
+type testTableKeys struct {
+	S  string
+	I  int16
+	U  uint
+	F  float64
+	B  bool
+	Tm time.Time
+}
+
+func (t *testTableKeys) Name() string { return "test_table_keys" }
+
+func (t *testTableKeys) PrimaryKey() ([]string, []interface{}) {
+	return []string{"s", "i", "u", "f", "b", "tm"}, []interface{}{&t.S, &t.I, &t.U, &t.F, &t.B, &t.Tm}
+}
+
+func (t *testTableKeys) HasAutoIncrementField() bool { return false }
+
+func (t *testTableKeys) New() Table { return &testTableKeys{} }
+
+func (t *testTableKeys) Fields() ([]string, []interface{}) { return t.PrimaryKey() }
+
+func TestSetKey(t *testing.T) {
+	tm := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := testTableKeys{S: "a,b", I: -2, U: 3, F: 1.5, B: true, Tm: tm}
+	testCase := []struct {
+		values []interface{}
+		err    bool
+	}{
+		{values: []interface{}{"a,b", "-2", "3", "1.5", "true", "2026-01-01"}},
+		{values: []interface{}{"a,b", float64(-2), float64(3), float64(1.5), true, "2026-01-01T00:00:00Z"}},
+		{values: []interface{}{"a,b", json.Number("-2"), json.Number("3"), json.Number("1.5"), true, "2026-01-01"}},
+		{values: []interface{}{"a,b", "1.5", "3", "1.5", "true", "2026-01-01"}, err: true},
+		{values: []interface{}{"a,b", "-2", float64(-3), "1.5", "true", "2026-01-01"}, err: true},
+		{values: []interface{}{"a,b", "70000", "3", "1.5", "true", "2026-01-01"}, err: true},
+		{values: []interface{}{"a,b", "-2"}, err: true},
+	}
+	for i, tc := range testCase {
+		got := testTableKeys{}
+		err := SetKey(&got, tc.values...)
+		if tc.err {
+			if err == nil {
+				t.Errorf("tc:%d want error got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("tc:%d err:%v", i, err)
+		}
+		if got != want {
+			t.Errorf("tc:%d got:%+v want:%+v", i, got, want)
+		}
+	}
+	values, err := ParseKey(FormatKey(&want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := testTableKeys{}
+	if err = SetKey(&got, values...); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("format and parse key got:%+v want:%+v", got, want)
+	}
+}
+
+func TestSetFields(t *testing.T) {
+	got := testTableKeys{S: "old", B: true}
+	err := SetFields(&got, map[string]interface{}{"s": "new", "i": json.Number("7"), "f": "2.5", "b": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (testTableKeys{S: "new", I: 7, F: 2.5}); got != want {
+		t.Errorf("got:%+v want:%+v", got, want)
+	}
+	for _, values := range []map[string]interface{}{
+		{"unknown": "a"},
+		{"i": "a"},
+		{"s": []interface{}{"a"}},
+	} {
+		if err := SetFields(&got, values); err == nil {
+			t.Errorf("values:%v want error got nil", values)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package dbaccess
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+//MigrationTable is the table that store the applied schema version.
+const MigrationTable = "schema_migrations"
+
+//Migration is a change of the database schema, the version of the Migration is its
+//index on the list plus one.
+type Migration func(db DBExecer) error
+
+//Exec return the Migration that execute the query.
+func Exec(query string) Migration {
+	return func(db DBExecer) error {
+		_, err := db.Exec(query)
+		return err
+	}
+}
+
+//Migrate apply the migrations after the schema version of the db in order, every
+//applied version is recorded on the MigrationTable. It return the schema version.
+//MySQL commit the DDL implicitly, a failed migration that has several DDL must be
+//fixed manually. Migrate must not run concurrently on the same database.
+func Migrate(db DBExecer, migrations []Migration) (int, error) {
+	ctx := context.Background()
+	_, err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s "+
+		"(version INT NOT NULL, applied_at DATETIME(6) NOT NULL, PRIMARY KEY (version))", MigrationTable))
+	if err != nil {
+		return 0, err
+	}
+	var version int
+	for {
+		err = WithTx(ctx, db, nil, func(tx DBExecer) error {
+			var err error
+			if version, err = SchemaVersion(tx); err != nil || version >= len(migrations) {
+				return err
+			}
+			if err = migrations[version](tx); err != nil {
+				return fmt.Errorf("migration %d: %w", version+1, err)
+			}
+			version++
+			_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (version, applied_at) VALUES (?, ?)", MigrationTable),
+				version, now())
+			return err
+		})
+		if err != nil || version >= len(migrations) {
+			return version, err
+		}
+	}
+}
+
+//SchemaVersion return the last version applied by Migrate, an error if the db is
+//not migrated.
+func SchemaVersion(db DBExecer) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRow(fmt.Sprintf("SELECT MAX(version) FROM %s", MigrationTable)).Scan(&version)
+	return int(version.Int64), err
+}
+
+//TableColumns return the columns of the table on the current database, the value is
+//true if the column is on the PrimaryKey. It is empty if the table does not exist, so
+//the Migration can check the schema that is created before the migrations.
+func TableColumns(db DBExecer, table string) (map[string]bool, error) {
+	rows, err := db.Query("SELECT COLUMN_NAME, COLUMN_KEY FROM information_schema.COLUMNS "+
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name, key string
+		if err = rows.Scan(&name, &key); err != nil {
+			return nil, err
+		}
+		columns[name] = key == "PRI"
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,42 @@
+package dbaccess
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	if _, err := SchemaVersion(db); err == nil {
+		t.Error("schema version of the db that is not migrated want error got nil")
+	}
+	migrations := []Migration{
+		Exec("CREATE TABLE test_migrate (id INT NOT NULL, PRIMARY KEY (id))"),
+		Exec("INSERT INTO test_migrate (id) VALUES (1)"),
+	}
+	version, err := Migrate(db, migrations)
+	if err != nil || version != 2 {
+		t.Fatalf("got version:%d err:%v want:2", version, err)
+	}
+	//the applied migrations is not run again.
+	if version, err = Migrate(db, migrations); err != nil || version != 2 {
+		t.Fatalf("got version:%d err:%v want:2", version, err)
+	}
+	fail := errors.New("migration failed")
+	migrations = append(migrations,
+		Exec("INSERT INTO test_migrate (id) VALUES (2)"),
+		func(db DBExecer) error { return fail },
+		Exec("INSERT INTO test_migrate (id) VALUES (3)"),
+	)
+	if version, err = Migrate(db, migrations); !errors.Is(err, fail) || version != 3 {
+		t.Errorf("got version:%d err:%v want:3 %v", version, err, fail)
+	}
+	if version, err = SchemaVersion(db); err != nil || version != 3 {
+		t.Errorf("got schema version:%d err:%v want:3", version, err)
+	}
+	var n int
+	if err = db.QueryRow("SELECT COUNT(*) FROM test_migrate").Scan(&n); err != nil || n != 2 {
+		t.Errorf("got rows:%d err:%v want:2", n, err)
+	}
+}
@@ -0,0 +1,132 @@
+package dbaccess
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+//QueryEvent is a statement executed through the DBExecer of WithObserver.
+type QueryEvent struct {
+	//Op is the dbaccess function that execute the statement, e.g. insert or fetch,
+	//and Table is the name of its Table. Op is empty if the DBExecer is used directly.
+	Op    string
+	Table string
+	Query string
+	Args  []interface{}
+	//Duration is the time to execute the statement, the rows of a query is read after.
+	Duration time.Duration
+	//RowsAffected is the rows affected by Exec, -1 for a query.
+	RowsAffected int64
+	Err          error
+	//Ctx is the context of the statement, e.g. of the request, nil if it is not known.
+	Ctx context.Context
+}
+
+//Observer receive the statements executed through the DBExecer of WithObserver.
+type Observer interface {
+	ObserveQuery(e QueryEvent)
+}
+
+//ObserverFunc is a function that implement Observer.
+type ObserverFunc func(e QueryEvent)
+
+//ObserveQuery implement Observer.
+func (f ObserverFunc) ObserveQuery(e QueryEvent) {
+	f(e)
+}
+
+//Observers return the Observer that send the event to every observers in order.
+func Observers(observers ...Observer) Observer {
+	return ObserverFunc(func(e QueryEvent) {
+		for _, o := range observers {
+			o.ObserveQuery(e)
+		}
+	})
+}
+
+//observedExecer is the DBExecer that send every statement to the observer, op and
+//table is the outermost dbaccess function that use it.
+type observedExecer struct {
+	DBExecer
+	o     Observer
+	op    string
+	table string
+}
+
+//WithObserver return the DBExecer that send every statement to the observer, the
+//transaction of WithTx keep the observer. WithTenant should wrap the DBExecer of
+//WithObserver and not the reverse.
+func WithObserver(db DBExecer, o Observer) DBExecer {
+	if x, ok := db.(*observedExecer); ok {
+		return &observedExecer{DBExecer: x.DBExecer, o: Observers(x.o, o)}
+	}
+	return &observedExecer{DBExecer: db, o: o}
+}
+
+//operation label the statements of db with the dbaccess function op of the table t,
+//the label of the outer function is kept.
+func operation(db DBExecer, op string, t Table) DBExecer {
+	switch x := db.(type) {
+	case *tenantExecer:
+		inner := operation(x.DBExecer, op, t)
+		if inner == x.DBExecer {
+			return db
+		}
+		return &tenantExecer{DBExecer: inner, tenant: x.tenant}
+	case *observedExecer:
+		if x.op != "" {
+			return db
+		}
+		return &observedExecer{DBExecer: x.DBExecer, o: x.o, op: op, table: t.Name()}
+	}
+	return db
+}
+
+func (x *observedExecer) observe(query string, args []interface{}, d time.Duration, rows int64, err error) {
+	x.o.ObserveQuery(QueryEvent{
+		Op:           x.op,
+		Table:        x.table,
+		Query:        query,
+		Args:         args,
+		Duration:     d,
+		RowsAffected: rows,
+		Err:          err,
+	})
+}
+
+//Query implement DBExecer.
+func (x *observedExecer) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := x.DBExecer.Query(query, args...)
+	x.observe(query, args, time.Since(start), -1, err)
+	return rows, err
+}
+
+//QueryRow implement DBExecer.
+func (x *observedExecer) QueryRow(query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := x.DBExecer.QueryRow(query, args...)
+	d := time.Since(start)
+	err := row.Err()
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	x.observe(query, args, d, -1, err)
+	return row
+}
+
+//Exec implement DBExecer.
+func (x *observedExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := x.DBExecer.Exec(query, args...)
+	d := time.Since(start)
+	rows := int64(-1)
+	if err == nil {
+		if n, rerr := res.RowsAffected(); rerr == nil {
+			rows = n
+		}
+	}
+	x.observe(query, args, d, rows, err)
+	return res, err
+}
@@ -0,0 +1,83 @@
+package dbaccess
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestObserver(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	var events []QueryEvent
+	o := ObserverFunc(func(e QueryEvent) { events = append(events, e) })
+	odb := WithObserver(db, o)
+	data := &testTable{Code: "AA", Description: "Karawaci", TransactionDate: time.Now().UTC(), Amount: 100, Count: 1}
+	if err := Insert(odb, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := Get(odb, &testTable{Code: "AA"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Get(odb, &testTable{Code: "XX"}); err == nil {
+		t.Fatal("get not exist record must fail")
+	}
+	err := WithTx(context.Background(), odb, nil, func(tx DBExecer) error {
+		return Update(tx, data, map[string]interface{}{"count": 2})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = odb.Exec("DELETE FROM test_table"); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		op    string
+		table string
+		rows  int64
+	}{
+		{"insert", "test_table", 1},
+		{"get", "test_table", -1},
+		{"get", "test_table", -1},
+		{"update", "test_table", 1},
+		{"", "", 1},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events:%+v want:%d", len(events), events, len(want))
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Op != w.op || e.Table != w.table || e.RowsAffected != w.rows || e.Err != nil {
+			t.Errorf("event %d got op:%q table:%q rows:%d err:%v want:%+v", i, e.Op, e.Table, e.RowsAffected, e.Err, w)
+		}
+		if e.Query == "" || e.Duration <= 0 {
+			t.Errorf("event %d got query:%q duration:%v", i, e.Query, e.Duration)
+		}
+	}
+}
+
+func TestObserverTenant(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	if err := createTable(db, testTenantSql); err != nil {
+		t.Fatal(err)
+	}
+	var events []QueryEvent
+	tdb := WithTenant(WithObserver(db, ObserverFunc(func(e QueryEvent) { events = append(events, e) })), "T1")
+	err := WithTx(context.Background(), tdb, nil, func(tx DBExecer) error {
+		return Insert(tx, &testTenantTable{Code: "AA", Description: "Karawaci"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &testTenantTable{Code: "AA"}
+	if err = Get(tdb, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.TenantId != "T1" {
+		t.Errorf("got tenant:%q want:T1", got.TenantId)
+	}
+	if len(events) != 2 || events[0].Op != "insert" || events[1].Op != "get" {
+		t.Errorf("got events:%+v", events)
+	}
+}
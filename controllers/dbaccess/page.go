@@ -0,0 +1,55 @@
+package dbaccess
+
+import (
+	"fmt"
+)
+
+//Page is the metadata of the page pagination.
+type Page struct {
+	Number     int `json:"number"`
+	PerPage    int `json:"per_page"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+//Count return the number of records that match with the filters, AsOf and Search of
+//the cursor.
+func Count(db DBExecer, t Table, c Cursor) (int, error) {
+	db = operation(db, "count", t)
+	name, args, err := source(t, c)
+	if err != nil {
+		return 0, err
+	}
+	fs, err := withTenant(db, t, c.Filters)
+	if err != nil {
+		return 0, err
+	}
+	where, whereArgs, err := filters(t, Cursor{Filters: fs, AsOf: c.AsOf}, 1)
+	if err != nil {
+		return 0, err
+	}
+	args = append(args, whereArgs...)
+	query := "SELECT COUNT(*) FROM " + name
+	if where != "" {
+		query = fmt.Sprintf("%s WHERE %s", query, where)
+	}
+	count := 0
+	err = db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+//PageOf return the Page of the cursor that use page pagination.
+func PageOf(db DBExecer, t Table, c Cursor) (Page, error) {
+	db = operation(db, "count", t)
+	p := Page{Number: c.Page, PerPage: c.Limit}
+	if c.Page < 1 || c.Limit < 1 {
+		return p, fmt.Errorf("cursor does not use page pagination")
+	}
+	total, err := Count(db, t, c)
+	if err != nil {
+		return p, err
+	}
+	p.Total = total
+	p.TotalPages = (total + c.Limit - 1) / c.Limit
+	return p, nil
+}
@@ -0,0 +1,117 @@
+package dbaccess
+
+import (
+	"context"
+)
+
+//DefaultPageSize is the records fetched by every query of FetchPages when the Limit
+//of the cursor is zero.
+const DefaultPageSize = 1000
+
+//FetchPages is like FetchIter but the iterator fetch every record that match with the
+//cursor page by page, every page is a query of Cursor.Limit (default DefaultPageSize)
+//records continued from the last record of the previous page. The memory and the
+//query does not grow with the table. The iteration stop with ctx.Err() when ctx is
+//done. Page and Include of the cursor is ignored.
+func FetchPages(ctx context.Context, db DBExecer, t Table, option Cursor) (*Pages, error) {
+	if option.Limit <= 0 {
+		option.Limit = DefaultPageSize
+	}
+	option.Page = 0
+	option.Include = nil
+	if option.Search != "" && len(option.OrderBy) == 0 {
+		option.OrderBy = []string{ScoreField}
+		option.Descending = true
+	}
+	option.OrderBy = addUniqueField(t, append([]string(nil), option.OrderBy...))
+	p := &Pages{ctx: ctx, db: db, t: t, fields: option.Fields}
+	if len(option.Fields) != 0 {
+		//the next page continue from the OrderBy fields of the last record, so they
+		//must be fetched.
+		option.Fields = append([]string(nil), option.Fields...)
+		for _, field := range option.OrderBy {
+			if _, exist := fieldExist(option.Fields, field); !exist && field != ScoreField {
+				option.Fields = append(option.Fields, field)
+			}
+		}
+	}
+	p.next = option
+	rows, err := FetchIter(db, t, option)
+	if err != nil {
+		return nil, err
+	}
+	p.rows = rows
+	return p, nil
+}
+
+//Pages is the iterator of the records returned by FetchPages, it is used like Rows.
+type Pages struct {
+	ctx    context.Context
+	db     DBExecer
+	t      Table
+	fields []string
+	//next is the cursor of the current page.
+	next Cursor
+	rows *Rows
+	//n is the records read from the current page.
+	n    int
+	done bool
+	err  error
+}
+
+//Next scan the next record, the next page is fetched when the current page is read.
+func (p *Pages) Next() bool {
+	for {
+		if p.done || p.err != nil {
+			return false
+		}
+		if p.err = p.ctx.Err(); p.err != nil {
+			p.rows.Close()
+			return false
+		}
+		if p.rows.Next() {
+			p.n++
+			return true
+		}
+		if p.err = p.rows.Err(); p.err != nil {
+			return false
+		}
+		if p.n < p.next.Limit {
+			p.done = true
+			return false
+		}
+		p.next = p.rows.Cursor()
+		p.n = 0
+		rows, err := FetchIter(p.db, p.t, p.next)
+		if err != nil {
+			p.err = err
+			return false
+		}
+		p.rows = rows
+	}
+}
+
+//Table return the record scanned by Next.
+func (p *Pages) Table() Table {
+	return p.rows.Table()
+}
+
+//Err return the error happen during the iteration.
+func (p *Pages) Err() error {
+	return p.err
+}
+
+//Close close the current page, it is safe to call Close multiple time.
+func (p *Pages) Close() error {
+	return p.rows.Close()
+}
+
+//Cursor return the fields, filters and order of the records. The Limit is zero
+//because there is no next records set.
+func (p *Pages) Cursor() Cursor {
+	c := p.next
+	c.Fields = p.fields
+	c.Limit = 0
+	c.LastArgs = nil
+	return c
+}
@@ -0,0 +1,66 @@
+package dbaccess
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFetchPages(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	for i := 0; i < 5; i++ {
+		tt := &testTable{Code: fmt.Sprintf("A%d", i), Description: fmt.Sprintf("D%d", 4-i), TransactionDate: time.Now().UTC()}
+		if err := Insert(db, tt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queries := 0
+	odb := WithObserver(db, ObserverFunc(func(e QueryEvent) {
+		if e.Op == "fetch" {
+			queries++
+		}
+	}))
+	c := Cursor{Fields: []string{"description"}, Limit: 2}
+	pages, err := FetchPages(context.Background(), odb, &testTable{}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for pages.Next() {
+		tt := pages.Table().(*testTable)
+		got = append(got, tt.Description+tt.Code)
+	}
+	if err = pages.Err(); err != nil {
+		t.Fatal(err)
+	}
+	pages.Close()
+	if want := "[D4A0 D3A1 D2A2 D1A3 D0A4]"; fmt.Sprint(got) != want {
+		t.Errorf("got records:%v want:%s", got, want)
+	}
+	//the last page has less than Limit records, so there is no empty page query.
+	if queries != 3 {
+		t.Errorf("got %d queries want:3", queries)
+	}
+	if pc := pages.Cursor(); pc.Limit != 0 || fmt.Sprint(pc.Fields) != "[description]" {
+		t.Errorf("got cursor:%+v", pc)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pages, err = FetchPages(ctx, db, &testTable{}, Cursor{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pages.Close()
+	n := 0
+	for pages.Next() {
+		if n++; n == 3 {
+			cancel()
+		}
+	}
+	if n != 3 || pages.Err() != context.Canceled {
+		t.Errorf("got %d records err:%v want 3 records and context.Canceled", n, pages.Err())
+	}
+}
@@ -0,0 +1,181 @@
+package dbaccess
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//RedactedArg replace the args of the statement on the log of QueryLogger.
+const RedactedArg = "[REDACTED]"
+
+//DefaultExplainTimeout is the timeout of the EXPLAIN of QueryLogger when the
+//ExplainTimeout is zero.
+const DefaultExplainTimeout = time.Second
+
+//RedactArgs replace every arg of the statement with RedactedArg, it is the default
+//Redact of QueryLogger.
+func RedactArgs(e QueryEvent) []interface{} {
+	args := make([]interface{}, len(e.Args))
+	for i := range args {
+		args[i] = RedactedArg
+	}
+	return args
+}
+
+//KeepArgs return the args of the statement as is.
+func KeepArgs(e QueryEvent) []interface{} {
+	return e.Args
+}
+
+//QueryLogger is the Observer that log the statements and the slow statements.
+type QueryLogger struct {
+	//Logger is where the statement is logged, slog.Default if it is nil.
+	Logger *slog.Logger
+	//LoggerFromContext return the logger of the Ctx of the statement, e.g. the logger
+	//of the request that has the request ID. Logger is used if it is nil or the
+	//statement does not have the Ctx.
+	LoggerFromContext func(ctx context.Context) *slog.Logger
+	//All log every statement at the debug level, otherwise only the slow and the
+	//failed statements is logged.
+	All bool
+	//SlowThreshold is the duration the statement is slow and logged at the warn
+	//level, zero disable the slow query log.
+	SlowThreshold time.Duration
+	//Redact return the args that is logged, RedactArgs if it is nil.
+	Redact func(e QueryEvent) []interface{}
+	//Explain log the EXPLAIN of the slow SELECT, it run on Explain with the args of
+	//the statement. It is a *sql.DB and not the transaction of the statement that may
+	//still read the rows of the statement. The EXPLAIN run in the background after the
+	//slow query is logged and is logged on its own record, only one EXPLAIN run at a
+	//time and the slow query while it is running is not explained.
+	Explain *sql.DB
+	//ExplainFormat is the FORMAT of the EXPLAIN, e.g. TREE on MySQL 8, the default
+	//format if it is empty.
+	ExplainFormat string
+	//ExplainTimeout is the timeout of the EXPLAIN, default DefaultExplainTimeout.
+	ExplainTimeout time.Duration
+
+	explaining atomic.Bool
+	wg         sync.WaitGroup
+}
+
+//ObserveQuery implement Observer.
+func (ql *QueryLogger) ObserveQuery(e QueryEvent) {
+	slow := ql.SlowThreshold > 0 && e.Duration >= ql.SlowThreshold
+	level, msg := slog.LevelDebug, "query"
+	switch {
+	case e.Err != nil:
+		level, msg = slog.LevelError, "query failed"
+	case slow:
+		level, msg = slog.LevelWarn, "slow query"
+	case !ql.All:
+		return
+	}
+	ctx := e.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	logger := ql.Logger
+	if ql.LoggerFromContext != nil && e.Ctx != nil {
+		logger = ql.LoggerFromContext(e.Ctx)
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	redact := ql.Redact
+	if redact == nil {
+		redact = RedactArgs
+	}
+	attrs := []slog.Attr{
+		slog.String("op", e.Op),
+		slog.String("table", e.Table),
+		slog.String("query", e.Query),
+		slog.Any("args", redact(e)),
+		slog.Float64("duration_ms", float64(e.Duration.Microseconds())/1000),
+	}
+	if e.RowsAffected >= 0 {
+		attrs = append(attrs, slog.Int64("rows_affected", e.RowsAffected))
+	}
+	if e.Err != nil {
+		attrs = append(attrs, slog.String("error", e.Err.Error()))
+	}
+	logger.LogAttrs(ctx, level, msg, attrs...)
+	if slow && ql.Explain != nil && isSelect(e.Query) && ql.explaining.CompareAndSwap(false, true) {
+		//the EXPLAIN is not run on the path of the slow query.
+		ql.wg.Add(1)
+		go func() {
+			defer ql.wg.Done()
+			defer ql.explaining.Store(false)
+			attrs := attrs[:3:3]
+			plan, err := ql.explain(e)
+			if err != nil {
+				attrs = append(attrs, slog.String("explain_error", err.Error()))
+			} else {
+				attrs = append(attrs, slog.Any("explain", plan))
+			}
+			logger.LogAttrs(context.WithoutCancel(ctx), slog.LevelWarn, "slow query plan", attrs...)
+		}()
+	}
+}
+
+//Wait wait the EXPLAIN of the slow statement that is running, e.g. before the
+//program exit.
+func (ql *QueryLogger) Wait() {
+	ql.wg.Wait()
+}
+
+//explain return the rows of the EXPLAIN of the statement.
+func (ql *QueryLogger) explain(e QueryEvent) ([]map[string]string, error) {
+	timeout := ql.ExplainTimeout
+	if timeout <= 0 {
+		timeout = DefaultExplainTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	explain := "EXPLAIN "
+	if ql.ExplainFormat != "" {
+		explain += "FORMAT=" + ql.ExplainFormat + " "
+	}
+	rows, err := ql.Explain.QueryContext(ctx, explain+e.Query, e.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var plan []map[string]string
+	for rows.Next() {
+		values := make([]sql.RawBytes, len(cols))
+		dst := make([]interface{}, len(cols))
+		for i := range values {
+			dst[i] = &values[i]
+		}
+		if err = rows.Scan(dst...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(cols))
+		for i, c := range cols {
+			if values[i] != nil {
+				row[c] = string(values[i])
+			}
+		}
+		plan = append(plan, row)
+	}
+	return plan, rows.Err()
+}
+
+//isSelect report whether the statement is a SELECT that can be explained.
+func isSelect(query string) bool {
+	q := strings.TrimSpace(query)
+	return len(q) >= 6 && strings.EqualFold(q[:6], "SELECT")
+}
@@ -0,0 +1,110 @@
+package dbaccess
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryLogger(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	var b bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&b, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	records := func() []map[string]interface{} {
+		var res []map[string]interface{}
+		dec := json.NewDecoder(&b)
+		for dec.More() {
+			var m map[string]interface{}
+			if err := dec.Decode(&m); err != nil {
+				t.Fatal(err)
+			}
+			res = append(res, m)
+		}
+		b.Reset()
+		return res
+	}
+	fast := QueryEvent{Op: "get", Table: "test_table", Query: "SELECT code FROM test_table WHERE code = ?",
+		Args: []interface{}{"AA"}, Duration: time.Millisecond, RowsAffected: -1}
+	slow := fast
+	slow.Duration = time.Second
+
+	ql := &QueryLogger{Logger: logger, SlowThreshold: 100 * time.Millisecond}
+	ql.ObserveQuery(fast)
+	if got := records(); len(got) != 0 {
+		t.Errorf("got log:%v of the fast query", got)
+	}
+	ql.ObserveQuery(slow)
+	got := records()
+	if len(got) != 1 {
+		t.Fatalf("got log:%v want the slow query", got)
+	}
+	r := got[0]
+	if r["level"] != "WARN" || r["msg"] != "slow query" || r["table"] != "test_table" || r["op"] != "get" ||
+		r["duration_ms"] != float64(1000) {
+		t.Errorf("got log:%v", r)
+	}
+	if args, _ := r["args"].([]interface{}); len(args) != 1 || args[0] != RedactedArg {
+		t.Errorf("got args:%v want redacted", r["args"])
+	}
+	ql.Explain = db
+	ql.ExplainFormat = "TREE"
+	ql.ObserveQuery(QueryEvent{Op: "fetch", Table: "test_table", Query: "SELECT code FROM test_table",
+		Duration: time.Second, RowsAffected: -1})
+	ql.Wait()
+	//the plan is logged after the slow query.
+	got = records()
+	if len(got) != 2 || got[0]["explain"] != nil || got[1]["msg"] != "slow query plan" || got[1]["query"] != "SELECT code FROM test_table" {
+		t.Fatalf("got log:%v want the slow query and its plan", got)
+	}
+	if plan, _ := got[1]["explain"].([]interface{}); len(plan) == 0 {
+		t.Errorf("got log:%v without explain", got[1])
+	}
+
+	//the logger of the context of the statement is used.
+	type ctxKey struct{}
+	ql = &QueryLogger{Logger: slog.New(slog.NewJSONHandler(io.Discard, nil)), SlowThreshold: 100 * time.Millisecond,
+		LoggerFromContext: func(ctx context.Context) *slog.Logger {
+			return logger.With("request_id", ctx.Value(ctxKey{}))
+		}}
+	slow.Ctx = context.WithValue(context.Background(), ctxKey{}, "r1")
+	ql.ObserveQuery(slow)
+	if got = records(); len(got) != 1 || got[0]["request_id"] != "r1" {
+		t.Errorf("got log:%v want the request_id of the context", got)
+	}
+	slow.Ctx = nil
+
+	ql = &QueryLogger{Logger: logger, All: true, Redact: KeepArgs}
+	ql.ObserveQuery(fast)
+	ql.ObserveQuery(QueryEvent{Query: "DELETE FROM test_table", RowsAffected: -1, Err: errors.New("failed")})
+	got = records()
+	if len(got) != 2 {
+		t.Fatalf("got log:%v want 2 records", got)
+	}
+	if got[0]["level"] != "DEBUG" || got[0]["msg"] != "query" || got[0]["explain"] != nil {
+		t.Errorf("got log:%v", got[0])
+	}
+	if args, _ := got[0]["args"].([]interface{}); len(args) != 1 || args[0] != "AA" {
+		t.Errorf("got args:%v want AA", got[0]["args"])
+	}
+	if got[1]["level"] != "ERROR" || got[1]["error"] != "failed" {
+		t.Errorf("got log:%v", got[1])
+	}
+
+	//the statements of the DBExecer is logged through WithObserver.
+	odb := WithObserver(db, &QueryLogger{Logger: logger, All: true})
+	if err := Insert(odb, &testTable{Code: "AA", TransactionDate: time.Now().UTC()}); err != nil {
+		t.Fatal(err)
+	}
+	got = records()
+	if len(got) != 1 || got[0]["op"] != "insert" || !strings.HasPrefix(got[0]["query"].(string), "INSERT") ||
+		got[0]["rows_affected"] != float64(1) {
+		t.Errorf("got log:%v", got)
+	}
+}
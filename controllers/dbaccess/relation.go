@@ -0,0 +1,163 @@
+package dbaccess
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+//RelationKind is the kind of the Relation.
+type RelationKind int
+
+const (
+	//BelongsTo the table has the foreign key to the related table,
+	//the table is related to at most one record.
+	BelongsTo RelationKind = iota
+	//HasMany the related table has the foreign key to the table.
+	HasMany
+)
+
+//Relation describe the relation between a table and the Target table, the records
+//is related if the value of Field on the table equal to the value of TargetField on
+//the Target.
+//	BelongsTo: Field is the foreign key (department_id), TargetField is the key of Target (id).
+//	HasMany: Field is the key (id), TargetField is the foreign key on Target (department_id).
+type Relation struct {
+	Name        string
+	Kind        RelationKind
+	Target      Table
+	Field       string
+	TargetField string
+	//Set store the related records on the table, called once for every table by Preload,
+	//related is empty if there is no related record.
+	Set func(related []Table)
+}
+
+//Relater is a Table that has relation with other tables.
+type Relater interface {
+	Relations() []Relation
+}
+
+func findRelation(t Table, name string) (Relation, error) {
+	r, ok := t.(Relater)
+	if !ok {
+		return Relation{}, fmt.Errorf("table:%s does not have relations", t.Name())
+	}
+	for _, rel := range r.Relations() {
+		if rel.Name == name {
+			return rel, nil
+		}
+	}
+	return Relation{}, fmt.Errorf("table:%s does not have relation:%s", t.Name(), name)
+}
+
+//relationFields return the fields that must be fetched to load the relations.
+func relationFields(t Table, names []string) ([]string, error) {
+	var fields []string
+	for _, name := range names {
+		rel, err := findRelation(t, name)
+		if err != nil {
+			return nil, err
+		}
+		if err = isFieldsOK(t, []string{rel.Field}); err != nil {
+			return nil, err
+		}
+		if err = isFieldsOK(rel.Target, []string{rel.TargetField}); err != nil {
+			return nil, err
+		}
+		fields = append(fields, rel.Field)
+	}
+	return fields, nil
+}
+
+//Preload load the relations by name for all the tables, it run one query for every
+//relation no matter how many the tables are.
+func Preload(db DBExecer, tables []Table, names ...string) error {
+	if len(tables) == 0 {
+		return nil
+	}
+	db = operation(db, "preload", tables[0])
+	if _, err := relationFields(tables[0], names); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := preload(db, tables, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func preload(db DBExecer, tables []Table, name string) error {
+	rels := make([]Relation, len(tables))
+	keys := make([]string, len(tables))
+	seen := make(map[string]bool)
+	var values []interface{}
+	for i, t := range tables {
+		rel, err := findRelation(t, name)
+		if err != nil {
+			return err
+		}
+		rels[i] = rel
+		v, err := fieldOf(t, rel.Field)
+		if err != nil {
+			return err
+		}
+		keys[i] = fmt.Sprint(v)
+		if !seen[keys[i]] {
+			seen[keys[i]] = true
+			values = append(values, v)
+		}
+	}
+
+	target := rels[0].Target
+	fields, _ := target.Fields()
+	pkf, _ := target.PrimaryKey()
+	tf, err := tenantFilters(db, target)
+	if err != nil {
+		return err
+	}
+	var tenant string
+	for _, f := range tf {
+		tenant = fmt.Sprintf("%s AND %s = ?", tenant, f.Field)
+		values = append(values, f.Value)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s IN (%s)%s ORDER BY %s",
+		strings.Join(fields, ","), target.Name(), rels[0].TargetField,
+		makePlaceHolder(1, len(values)-len(tf)), tenant, strings.Join(pkf, ","))
+	rows, err := db.Query(query, values...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	related := make(map[string][]Table)
+	for rows.Next() {
+		tbl := target.New()
+		_, dst := tbl.Fields()
+		if err = rows.Scan(dst...); err != nil {
+			return err
+		}
+		v, err := fieldOf(tbl, rels[0].TargetField)
+		if err != nil {
+			return err
+		}
+		key := fmt.Sprint(v)
+		related[key] = append(related[key], tbl)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for i, rel := range rels {
+		rel.Set(related[keys[i]])
+	}
+	return nil
+}
+
+//fieldOf return the value of the field of the table.
+func fieldOf(t Table, field string) (interface{}, error) {
+	args, err := scanArgs(t, []string{field})
+	if err != nil {
+		return nil, err
+	}
+	return reflect.Indirect(reflect.ValueOf(args[0])).Interface(), nil
+}
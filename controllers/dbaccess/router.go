@@ -0,0 +1,250 @@
+package dbaccess
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+//Router split the query between the primary and the replicas database, Query and
+//QueryRow (Fetch, FetchIter, Get, Exists, ...) is sent to a healthy replica, Exec and
+//the transaction (WithTx) is sent to the primary. The query is sent to the primary
+//if there is no healthy replica.
+//Write that read the records first (UpdateAll and DeleteAll of a Historian) should
+//be run with WithTx so the read is on the primary.
+type Router struct {
+	primary  *sql.DB
+	replicas []*replica
+	next     uint32
+	//Timeout is the timeout of the health check ping. Default 1s.
+	Timeout time.Duration
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+type replica struct {
+	db      *sql.DB
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replica) setHealthy(ok bool) {
+	var v int32
+	if ok {
+		v = 1
+	}
+	atomic.StoreInt32(&r.healthy, v)
+}
+
+//NewRouter return a Router of the primary and the replicas, the replicas is
+//considered healthy until the health check or a query fail.
+func NewRouter(primary *sql.DB, replicas ...*sql.DB) *Router {
+	r := &Router{primary: primary, Timeout: time.Second}
+	for _, db := range replicas {
+		r.replicas = append(r.replicas, &replica{db: db, healthy: 1})
+	}
+	return r
+}
+
+//Primary return the primary database.
+func (r *Router) Primary() *sql.DB {
+	return r.primary
+}
+
+//Reader return a healthy replica by round robin or the primary if there is no
+//healthy replica.
+func (r *Router) Reader() *sql.DB {
+	if rep := r.reader(); rep != nil {
+		return rep.db
+	}
+	return r.primary
+}
+
+func (r *Router) reader() *replica {
+	n := len(r.replicas)
+	start := atomic.AddUint32(&r.next, 1)
+	for i := 0; i < n; i++ {
+		rep := r.replicas[(int(start)+i)%n]
+		if rep.isHealthy() {
+			return rep
+		}
+	}
+	return nil
+}
+
+//Query implement DBExecer, the query is run on a replica, it is retried on the
+//primary if the replica connection fail.
+func (r *Router) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	rep := r.reader()
+	if rep == nil {
+		return r.primary.Query(query, args...)
+	}
+	rows, err := rep.db.Query(query, args...)
+	if err != nil && isConnError(err) {
+		rep.setHealthy(false)
+		return r.primary.Query(query, args...)
+	}
+	return rows, err
+}
+
+//QueryRow implement DBExecer, like Query it failover to the primary.
+func (r *Router) QueryRow(query string, args ...interface{}) *sql.Row {
+	rep := r.reader()
+	if rep == nil {
+		return r.primary.QueryRow(query, args...)
+	}
+	row := rep.db.QueryRow(query, args...)
+	if err := row.Err(); err != nil && isConnError(err) {
+		rep.setHealthy(false)
+		return r.primary.QueryRow(query, args...)
+	}
+	return row
+}
+
+//Exec implement DBExecer, it is run on the primary.
+func (r *Router) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return r.primary.Exec(query, args...)
+}
+
+//BeginTx implement TxBeginner, the transaction is started on the primary.
+func (r *Router) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return r.primary.BeginTx(ctx, opts)
+}
+
+//CheckHealth ping every replica and mark it healthy or not.
+func (r *Router) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, rep := range r.replicas {
+		wg.Add(1)
+		go func(rep *replica) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+			defer cancel()
+			rep.setHealthy(rep.db.PingContext(ctx) == nil)
+		}(rep)
+	}
+	wg.Wait()
+}
+
+//Healthy return the number of the healthy replicas.
+func (r *Router) Healthy() int {
+	n := 0
+	for _, rep := range r.replicas {
+		if rep.isHealthy() {
+			n++
+		}
+	}
+	return n
+}
+
+//Start check the health of the replicas every interval until Close is called.
+func (r *Router) Start(interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			r.CheckHealth(context.Background())
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}(r.stop, r.done)
+}
+
+//Close stop the health check, it does not close the databases.
+func (r *Router) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		close(r.stop)
+		<-r.done
+		r.stop = nil
+	}
+	return nil
+}
+
+//Session return a Session of the Router for one request.
+func (r *Router) Session() *Session {
+	return &Session{router: r}
+}
+
+//Session route like the Router until the first write (Exec, BeginTx or Stick),
+//after that every query is sent to the primary so the request read its own write.
+type Session struct {
+	router *Router
+	sticky int32
+}
+
+//Primary return the primary database of the Router.
+func (s *Session) Primary() *sql.DB {
+	return s.router.primary
+}
+
+//Stick send the next queries to the primary.
+func (s *Session) Stick() {
+	atomic.StoreInt32(&s.sticky, 1)
+}
+
+//Sticky return true if the session use the primary.
+func (s *Session) Sticky() bool {
+	return atomic.LoadInt32(&s.sticky) == 1
+}
+
+//Query implement DBExecer.
+func (s *Session) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	if s.Sticky() {
+		return s.router.primary.Query(query, args...)
+	}
+	return s.router.Query(query, args...)
+}
+
+//QueryRow implement DBExecer.
+func (s *Session) QueryRow(query string, args ...interface{}) *sql.Row {
+	if s.Sticky() {
+		return s.router.primary.QueryRow(query, args...)
+	}
+	return s.router.QueryRow(query, args...)
+}
+
+//Exec implement DBExecer.
+func (s *Session) Exec(query string, args ...interface{}) (sql.Result, error) {
+	s.Stick()
+	return s.router.primary.Exec(query, args...)
+}
+
+//BeginTx implement TxBeginner.
+func (s *Session) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	s.Stick()
+	return s.router.primary.BeginTx(ctx, opts)
+}
+
+//isConnError return true if the error is because the database can not be reached.
+func isConnError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
@@ -0,0 +1,135 @@
+package dbaccess
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+const dbreplica = "test_dbaccess_replica"
+
+//prepareReplica create the replica database with the test_table, the replication
+//is not set so the replica records is different from the primary.
+func prepareReplica(t *testing.T) *sql.DB {
+	db, err := connectDB(defaultdb, user, password, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dropDB(db, dbreplica); err != nil {
+		t.Fatal(err)
+	}
+	if err = createDB(db, dbreplica); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if db, err = connectDB(dbreplica, user, password, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = createTable(db, testTableSql); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestRouter(t *testing.T) {
+	primary := prepareTest(t)
+	defer primary.Close()
+	replica := prepareReplica(t)
+	defer replica.Close()
+	//nothing listen on the port 1.
+	down, err := connectDB(dbreplica, user, password, "tcp(127.0.0.1:1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer down.Close()
+
+	nd := time.Now()
+	now := time.Date(nd.Year(), nd.Month(), nd.Day(), 0, 0, 0, 0, time.UTC)
+	if err = Insert(replica, &testTable{Code: "RR", Description: "Replica", TransactionDate: now}); err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter(primary, replica)
+	if err = Insert(router, &testTable{Code: "PP", Description: "Primary", TransactionDate: now}); err != nil {
+		t.Fatal(err)
+	}
+	//exists returns which database answer the read.
+	exists := func(db DBExecer, code string) bool {
+		ok, err := Exists(db, &testTable{}, Filter{Field: "code", Op: "=", Value: code})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	t.Run("Route", func(t *testing.T) {
+		if !exists(primary, "PP") || exists(primary, "RR") {
+			t.Error("write is not sent to the primary")
+		}
+		if !exists(router, "RR") {
+			t.Error("read is not sent to the replica")
+		}
+		err := WithTx(context.Background(), router, nil, func(tx DBExecer) error {
+			if !exists(tx, "PP") {
+				t.Error("transaction is not on the primary")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Session", func(t *testing.T) {
+		s := router.Session()
+		if !exists(s, "RR") {
+			t.Error("session read before write is not sent to the replica")
+		}
+		change := map[string]interface{}{"description": "Primary Updated"}
+		if err := Update(s, &testTable{Code: "PP"}, change); err != nil {
+			t.Fatal(err)
+		}
+		if !s.Sticky() || !exists(s, "PP") {
+			t.Error("session read after write is not sent to the primary")
+		}
+		if !exists(router.Session(), "RR") {
+			t.Error("new session read is not sent to the replica")
+		}
+	})
+	t.Run("Failover", func(t *testing.T) {
+		r := NewRouter(primary, down, replica)
+		r.Timeout = 100 * time.Millisecond
+		r.CheckHealth(context.Background())
+		if r.Healthy() != 1 {
+			t.Fatalf("got healthy:%d want:1", r.Healthy())
+		}
+		for i := 0; i < 3; i++ {
+			if !exists(r, "RR") {
+				t.Error("read is sent to the unhealthy replica")
+			}
+		}
+
+		//the replica fail on the query before the health check.
+		r = NewRouter(primary, down)
+		if !exists(r, "PP") {
+			t.Error("read is not retried on the primary")
+		}
+		if r.Healthy() != 0 {
+			t.Errorf("got healthy:%d want:0", r.Healthy())
+		}
+		if r.Reader() != primary {
+			t.Error("Reader without healthy replica is not the primary")
+		}
+	})
+	t.Run("Start", func(t *testing.T) {
+		r := NewRouter(primary, down)
+		r.Timeout = 100 * time.Millisecond
+		r.Start(time.Hour)
+		defer r.Close()
+		deadline := time.Now().Add(time.Second)
+		for r.Healthy() != 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if r.Healthy() != 0 {
+			t.Error("health check does not mark the replica unhealthy")
+		}
+	})
+}
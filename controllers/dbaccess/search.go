@@ -0,0 +1,155 @@
+package dbaccess
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+//ScoreField is the relevance of the record to the Cursor.Search, it can be used on
+//the Cursor.OrderBy, the records is ordered by ScoreField descending if the OrderBy
+//is empty.
+const ScoreField = "_score"
+
+//Searchable is a Table that can be searched with Cursor.Search.
+type Searchable interface {
+	//SearchFields return the fields that is searched, the fields must have full-text
+	//index created by CreateSearchIndex.
+	SearchFields() []string
+}
+
+//Dialect build the full-text search query of the database.
+type Dialect interface {
+	//SearchIndex return the statements to create the full-text index of the fields.
+	SearchIndex(table string, fields []string) []string
+	//SearchScore return the relevance expression of the fields, the search text is
+	//the only placeholder.
+	SearchScore(table string, fields []string) string
+	//SearchMatch return the condition of the fields that match the search text, the
+	//search text is the only placeholder.
+	SearchMatch(table string, fields []string) string
+}
+
+//SearchDialect is the Dialect used by Fetch, Count and CreateSearchIndex.
+var SearchDialect Dialect = MySQL
+
+var (
+	//MySQL use FULLTEXT index and MATCH AGAINST in natural language mode.
+	MySQL Dialect = mysqlDialect{}
+	//Postgres use GIN index of the tsvector of the fields.
+	Postgres Dialect = postgresDialect{}
+	//SQLite use FTS5 virtual table table_fts that is synced by triggers.
+	SQLite Dialect = sqliteDialect{}
+)
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) SearchIndex(table string, fields []string) []string {
+	return []string{fmt.Sprintf("ALTER TABLE %s ADD FULLTEXT INDEX %s_search (%s)",
+		table, table, strings.Join(fields, ","))}
+}
+
+func (mysqlDialect) SearchScore(table string, fields []string) string {
+	return fmt.Sprintf("MATCH(%s) AGAINST (? IN NATURAL LANGUAGE MODE)", strings.Join(fields, ","))
+}
+
+func (d mysqlDialect) SearchMatch(table string, fields []string) string {
+	return d.SearchScore(table, fields) + " > 0"
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) vector(fields []string) string {
+	cols := make([]string, len(fields))
+	for i, f := range fields {
+		cols[i] = fmt.Sprintf("coalesce(%s,'')", f)
+	}
+	return fmt.Sprintf("to_tsvector('simple', %s)", strings.Join(cols, " || ' ' || "))
+}
+
+func (d postgresDialect) SearchIndex(table string, fields []string) []string {
+	return []string{fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_search ON %s USING GIN (%s)",
+		table, table, d.vector(fields))}
+}
+
+func (d postgresDialect) SearchScore(table string, fields []string) string {
+	return fmt.Sprintf("ts_rank(%s, plainto_tsquery('simple', ?))", d.vector(fields))
+}
+
+func (d postgresDialect) SearchMatch(table string, fields []string) string {
+	return fmt.Sprintf("%s @@ plainto_tsquery('simple', ?)", d.vector(fields))
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) SearchIndex(table string, fields []string) []string {
+	cols := strings.Join(fields, ",")
+	news := make([]string, len(fields))
+	olds := make([]string, len(fields))
+	for i, f := range fields {
+		news[i] = "new." + f
+		olds[i] = "old." + f
+	}
+	insert := fmt.Sprintf("INSERT INTO %s_fts(rowid,%s) VALUES (new.rowid,%s);",
+		table, cols, strings.Join(news, ","))
+	remove := fmt.Sprintf("INSERT INTO %s_fts(%s_fts,rowid,%s) VALUES ('delete',old.rowid,%s);",
+		table, table, cols, strings.Join(olds, ","))
+	return []string{
+		fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS %s_fts USING fts5(%s, content='%s')",
+			table, cols, table),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %s_fts_insert AFTER INSERT ON %s BEGIN %s END",
+			table, table, insert),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %s_fts_delete AFTER DELETE ON %s BEGIN %s END",
+			table, table, remove),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %s_fts_update AFTER UPDATE ON %s BEGIN %s %s END",
+			table, table, remove, insert),
+		fmt.Sprintf("INSERT INTO %s_fts(%s_fts) VALUES ('rebuild')", table, table),
+	}
+}
+
+func (sqliteDialect) SearchScore(table string, fields []string) string {
+	//bm25 is lower for the better match.
+	return fmt.Sprintf("(SELECT -bm25(%s_fts) FROM %s_fts WHERE %s_fts MATCH ? AND %s_fts.rowid = %s.rowid)",
+		table, table, table, table, table)
+}
+
+func (sqliteDialect) SearchMatch(table string, fields []string) string {
+	return fmt.Sprintf("%s.rowid IN (SELECT rowid FROM %s_fts WHERE %s_fts MATCH ?)", table, table, table)
+}
+
+//CreateSearchIndex create the full-text index of the SearchFields of the table, it
+//does nothing if the index already exist.
+func CreateSearchIndex(db DBExecer, t Table) error {
+	fields, err := searchFields(t)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range SearchDialect.SearchIndex(t.Name(), fields) {
+		if _, err = db.Exec(stmt); err != nil {
+			var me *mysql.MySQLError
+			//1061 duplicate key name, MySQL does not have ADD INDEX IF NOT EXISTS.
+			if errors.As(err, &me) && me.Number == 1061 {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func searchFields(t Table) ([]string, error) {
+	s, ok := t.(Searchable)
+	if !ok {
+		return nil, fmt.Errorf("table:%s is not searchable", t.Name())
+	}
+	fields := s.SearchFields()
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("table:%s does not have search fields", t.Name())
+	}
+	if err := isFieldsOK(t, fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
@@ -0,0 +1,115 @@
+package dbaccess
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func (t *testTable) SearchFields() []string {
+	return []string{"description"}
+}
+
+func TestSearchQuery(t *testing.T) {
+	c := Cursor{
+		Fields:     []string{"code", "description"},
+		Filters:    []Filter{{Field: "count", Op: "=", Value: 1}},
+		OrderBy:    []string{ScoreField, "code"},
+		Descending: true,
+		Limit:      2,
+		LastArgs:   []interface{}{0.5, "AB"},
+		Search:     "karawaci",
+	}
+	query, args, err := queryFromCursor(&testTable{}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SELECT code,description,_score FROM (SELECT *, MATCH(description) AGAINST (? IN NATURAL LANGUAGE MODE) AS _score " +
+		"FROM test_table WHERE MATCH(description) AGAINST (? IN NATURAL LANGUAGE MODE) > 0) AS test_table " +
+		"WHERE count = ? AND (_score,code) < (?,?) ORDER BY (_score,code) DESC LIMIT 2 "
+	if query != want {
+		t.Errorf("got query:%s\nwant:%s", query, want)
+	}
+	wantArgs := []interface{}{"karawaci", "karawaci", 1}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("got args:%v want:%v", args, wantArgs)
+	}
+
+	if _, err = FetchIter(nil, &testTableAuto{}, Cursor{Search: "karawaci"}); err == nil {
+		t.Error("search not searchable table want error got nil")
+	}
+	if _, err = FetchIter(nil, &testTable{}, Cursor{OrderBy: []string{ScoreField}}); err == nil {
+		t.Errorf("order by %s without search want error got nil", ScoreField)
+	}
+	if _, _, err = queryFromCursor(&testTableHist{}, Cursor{Search: "karawaci", AsOf: time.Now()}); err == nil {
+		t.Error("search as of a time want error got nil")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	if err := CreateSearchIndex(db, &testTable{}); err != nil {
+		t.Skipf("full-text index is not supported: %v", err)
+	}
+	//the index already exist.
+	if err := CreateSearchIndex(db, &testTable{}); err != nil {
+		t.Fatal(err)
+	}
+	nd := time.Now()
+	now := time.Date(nd.Year(), nd.Month(), nd.Day(), 0, 0, 0, 0, time.UTC)
+	data := []*testTable{
+		{Code: "AA", Description: "Karawaci Tangerang Karawaci", TransactionDate: now, Amount: 100.00, Count: 1},
+		{Code: "AB", Description: "Cikokol Tangerang", TransactionDate: now, Amount: 100.00, Count: 1},
+		{Code: "AC", Description: "Lippo Karawaci", TransactionDate: now, Amount: 100.00, Count: 2},
+		{Code: "BA", Description: "Serpong", TransactionDate: now, Amount: 100.00, Count: 1},
+	}
+	for _, v := range data {
+		if err := Insert(db, v); err != nil {
+			t.Fatalf("insert  data:%v err:%v", v, err)
+		}
+	}
+	testCase := []struct {
+		c     Cursor
+		total int
+		wants [][]*testTable
+	}{
+		{
+			c:     Cursor{Search: "karawaci", Limit: 1},
+			total: 2,
+			wants: [][]*testTable{{data[0]}, {data[2]}, nil},
+		},
+		{
+			c:     Cursor{Search: "tangerang", OrderBy: []string{"code"}},
+			total: 2,
+			wants: [][]*testTable{{data[0], data[1]}},
+		},
+		{
+			c:     Cursor{Search: "karawaci", Filters: []Filter{{Field: "count", Op: "=", Value: 2}}},
+			total: 1,
+			wants: [][]*testTable{{data[2]}},
+		},
+	}
+	for i, tc := range testCase {
+		total, err := Count(db, data[0], tc.c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != tc.total {
+			t.Errorf("tc:%d got total:%d want:%d", i, total, tc.total)
+		}
+		c := tc.c
+		for j, want := range tc.wants {
+			var got []Table
+			got, c, err = Fetch(db, data[0], c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareResults(t, i, j, got, want, nil)
+			//the cursor is used by the client as string.
+			if c, err = Decode(c.String()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package dbaccess
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+//StmtCache is a DBExecer that prepare the query on the first use and reuse the
+//prepared statement on the next call. Query built by this package is the same for
+//the same table and query shape, so the query is the key of the cache.
+//The least recently used statement is closed when the cache is full.
+//StmtCache is safe for concurrent use.
+type StmtCache struct {
+	db    *sql.DB
+	size  int
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+	stats StmtStats
+}
+
+//StmtStats is the statistic of the StmtCache. The hit inside a transaction is
+//counted on TxHits, Tx.Stmt prepare the cached statement again on the connection of
+//the transaction if the connection does not have it, so it does not save the prepare.
+type StmtStats struct {
+	Hits      uint64 `json:"hits"`
+	TxHits    uint64 `json:"tx_hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Size      int    `json:"size"`
+}
+
+type stmtEntry struct {
+	query string
+	stmt  *sql.Stmt
+	//refs is the number of caller using the stmt, evicted stmt is closed when
+	//there is no more caller using it.
+	refs    int
+	evicted bool
+}
+
+//NewStmtCache allocate a new StmtCache that hold at most size prepared statement.
+func NewStmtCache(db *sql.DB, size int) *StmtCache {
+	if size < 1 {
+		size = 1
+	}
+	return &StmtCache{
+		db:    db,
+		size:  size,
+		lru:   list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+//acquire return the prepared statement of the query, call release when done. The
+//query is prepared without holding the lock, so a slow prepare does not block the
+//other queries. tx is true if the statement is used inside a transaction.
+func (c *StmtCache) acquire(query string, tx bool) (*stmtEntry, error) {
+	c.mu.Lock()
+	entry := c.hit(query, tx)
+	c.mu.Unlock()
+	if entry != nil {
+		return entry, nil
+	}
+	stmt, err := c.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	//the other caller may cache the same query while it is prepared.
+	if entry = c.hit(query, tx); entry != nil {
+		stmt.Close()
+		return entry, nil
+	}
+	c.stats.Misses++
+	entry = &stmtEntry{query: query, stmt: stmt, refs: 1}
+	c.items[query] = c.lru.PushFront(entry)
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Remove(c.lru.Back()).(*stmtEntry)
+		delete(c.items, oldest.query)
+		oldest.evicted = true
+		if oldest.refs == 0 {
+			oldest.stmt.Close()
+		}
+		c.stats.Evictions++
+	}
+	return entry, nil
+}
+
+//hit return the cached statement of the query, nil if it is not cached. c.mu must be
+//held.
+func (c *StmtCache) hit(query string, tx bool) *stmtEntry {
+	e, ok := c.items[query]
+	if !ok {
+		return nil
+	}
+	if tx {
+		c.stats.TxHits++
+	} else {
+		c.stats.Hits++
+	}
+	c.lru.MoveToFront(e)
+	entry := e.Value.(*stmtEntry)
+	entry.refs++
+	return entry
+}
+
+func (c *StmtCache) release(entry *stmtEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.refs--
+	if entry.evicted && entry.refs == 0 {
+		//rows that still open keep the statement until the rows closed.
+		entry.stmt.Close()
+	}
+}
+
+//Query implement DBExecer.
+func (c *StmtCache) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	entry, err := c.acquire(query, false)
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(entry)
+	return entry.stmt.Query(args...)
+}
+
+//QueryRow implement DBExecer.
+func (c *StmtCache) QueryRow(query string, args ...interface{}) *sql.Row {
+	entry, err := c.acquire(query, false)
+	if err != nil {
+		//let the error returned by Scan.
+		return c.db.QueryRow(query, args...)
+	}
+	defer c.release(entry)
+	return entry.stmt.QueryRow(args...)
+}
+
+//Exec implement DBExecer.
+func (c *StmtCache) Exec(query string, args ...interface{}) (sql.Result, error) {
+	entry, err := c.acquire(query, false)
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(entry)
+	return entry.stmt.Exec(args...)
+}
+
+//BeginTx implement TxBeginner, WithTx on the StmtCache give the callback a DBExecer
+//that use the cached statement on the transaction.
+func (c *StmtCache) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return c.db.BeginTx(ctx, opts)
+}
+
+//Tx return a DBExecer that use the cached statement on the tx.
+func (c *StmtCache) Tx(tx *sql.Tx) DBExecer {
+	return &txExecer{Tx: tx, cache: c}
+}
+
+//Stats return the statistic of the cache.
+func (c *StmtCache) Stats() StmtStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	return stats
+}
+
+//Close close all the cached statement.
+func (c *StmtCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*stmtEntry)
+		entry.evicted = true
+		if entry.refs != 0 {
+			continue
+		}
+		if cerr := entry.stmt.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+	return err
+}
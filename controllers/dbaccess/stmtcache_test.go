@@ -0,0 +1,77 @@
+package dbaccess
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStmtCache(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	cache := NewStmtCache(db, 2)
+	defer cache.Close()
+	nd := time.Now()
+	now := time.Date(nd.Year(), nd.Month(), nd.Day(), 0, 0, 0, 0, time.UTC)
+	data := []*testTable{
+		{Code: "AA", Description: "AA Karawaci", TransactionDate: now, Amount: 100.00, Count: 1},
+		{Code: "AB", Description: "AB Karawaci", TransactionDate: now, Amount: 100.00, Count: 1},
+	}
+	err := WithTx(context.Background(), cache, nil, func(tx DBExecer) error {
+		for _, v := range data {
+			if err := Insert(tx, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//same INSERT query for both data, the hit is inside the transaction.
+	want := StmtStats{TxHits: 1, Misses: 1, Size: 1}
+	if got := cache.Stats(); got != want {
+		t.Errorf("got stats:%+v want:%+v", got, want)
+	}
+
+	for _, v := range data {
+		got := &testTable{Code: v.Code}
+		if err := Get(cache, got); err != nil {
+			t.Fatal(err)
+		}
+		compareData(t, got, v, nil)
+	}
+	result, _, err := Fetch(cache, data[0], Cursor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareResults(t, 0, 0, result, data, nil)
+	want = StmtStats{Hits: 1, TxHits: 1, Misses: 3, Evictions: 1, Size: 2}
+	if got := cache.Stats(); got != want {
+		t.Errorf("got stats:%+v want:%+v", got, want)
+	}
+}
+
+func TestStmtCacheConcurrent(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	cache := NewStmtCache(db, 2)
+	defer cache.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Count(cache, &testTable{}, Cursor{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	//the query prepared by the concurrent callers is cached once.
+	want := StmtStats{Hits: 9, Misses: 1, Size: 1}
+	if got := cache.Stats(); got != want {
+		t.Errorf("got stats:%+v want:%+v", got, want)
+	}
+}
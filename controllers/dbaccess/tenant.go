@@ -0,0 +1,105 @@
+package dbaccess
+
+import (
+	"errors"
+	"fmt"
+)
+
+//ErrNoTenant is returned when a TenantScoped table is used with a DBExecer that
+//does not have a tenant.
+var ErrNoTenant = errors.New("dbaccess: tenant is required")
+
+//TenantScoped is a Table that its records belong to a tenant. Every function of
+//dbaccess only see the records of the tenant of the DBExecer (WithTenant), Insert
+//set the tenant field and Update can not change it.
+type TenantScoped interface {
+	//TenantField return the field that store the tenant ID, it must be on the Fields.
+	TenantField() string
+}
+
+//tenantExecer is the DBExecer of one tenant.
+type tenantExecer struct {
+	DBExecer
+	tenant string
+}
+
+//WithTenant return the DBExecer that scope the TenantScoped tables to the tenant.
+func WithTenant(db DBExecer, tenant string) DBExecer {
+	if te, ok := db.(*tenantExecer); ok {
+		db = te.DBExecer
+	}
+	return &tenantExecer{DBExecer: db, tenant: tenant}
+}
+
+//TenantOf return the tenant of the DBExecer, false if it does not have a tenant.
+func TenantOf(db DBExecer) (string, bool) {
+	te, ok := db.(*tenantExecer)
+	if !ok || te.tenant == "" {
+		return "", false
+	}
+	return te.tenant, true
+}
+
+//tenantFilters return the filter of the tenant for the TenantScoped table, nil if
+//the table is not scoped.
+func tenantFilters(db DBExecer, t Table) ([]Filter, error) {
+	ts, ok := t.(TenantScoped)
+	if !ok {
+		return nil, nil
+	}
+	tenant, ok := TenantOf(db)
+	if !ok {
+		return nil, fmt.Errorf("table:%s %w", t.Name(), ErrNoTenant)
+	}
+	return []Filter{{Field: ts.TenantField(), Op: "=", Value: tenant}}, nil
+}
+
+//withTenant add the tenant filter to the filters.
+func withTenant(db DBExecer, t Table, fs []Filter) ([]Filter, error) {
+	tf, err := tenantFilters(db, t)
+	if err != nil || tf == nil {
+		return fs, err
+	}
+	return append(tf, fs...), nil
+}
+
+//keyWhere return the where of the PrimaryKey and the tenant of the table.
+func keyWhere(db DBExecer, t Table) (string, []interface{}, error) {
+	pkf, pkd := t.PrimaryKey()
+	w := where(pkf)
+	args := append([]interface{}{}, pkd...)
+	tf, err := tenantFilters(db, t)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, f := range tf {
+		w = fmt.Sprintf("%s AND %s = ?", w, f.Field)
+		args = append(args, f.Value)
+	}
+	return w, args, nil
+}
+
+//setTenant set the tenant field of the TenantScoped table to the tenant of db.
+func setTenant(db DBExecer, t Table) error {
+	tf, err := tenantFilters(db, t)
+	if err != nil || tf == nil {
+		return err
+	}
+	dst, err := scanArgs(t, []string{tf[0].Field})
+	if err != nil {
+		return err
+	}
+	return convertKey(dst[0], tf[0].Value)
+}
+
+//checkTenantChange return an error if the change update the tenant field.
+func checkTenantChange(t Table, change map[string]interface{}) error {
+	ts, ok := t.(TenantScoped)
+	if !ok {
+		return nil
+	}
+	if _, exist := change[ts.TenantField()]; exist {
+		return fmt.Errorf("table:%s field:%s can not be updated", t.Name(), ts.TenantField())
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package dbaccess
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var testTenantSql = table{
+	Name: "test_tenant",
+	Fields: []string{
+		"code VARCHAR(30) NOT NULL",
+		"description VARCHAR(100)",
+		"tenant_id VARCHAR(36) NOT NULL",
+	},
+	PrimaryKey: "(tenant_id, code)",
+}
+
+type testTenantTable struct {
+	Code        string
+	Description string
+	TenantId    string
+}
+
+func (t *testTenantTable) Name() string {
+	return "test_tenant"
+}
+
+func (t *testTenantTable) TenantField() string {
+	return "tenant_id"
+}
+
+func (t *testTenantTable) PrimaryKey() (fields []string, dst []interface{}) {
+	return []string{"code"}, []interface{}{&t.Code}
+}
+
+func (t *testTenantTable) HasAutoIncrementField() bool {
+	return false
+}
+
+func (t *testTenantTable) New() Table {
+	return &testTenantTable{}
+}
+
+func (t *testTenantTable) Fields() (fields []string, dst []interface{}) {
+	fields = []string{"code", "description", "tenant_id"}
+	dst = []interface{}{&t.Code, &t.Description, &t.TenantId}
+	return
+}
+
+func TestTenant(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	if err := createTable(db, testTenantSql); err != nil {
+		t.Fatal(err)
+	}
+	t1 := WithTenant(db, "T1")
+	t2 := WithTenant(db, "T2")
+	//the tenant on the record is replaced by the tenant of the DBExecer.
+	data := []*testTenantTable{
+		{Code: "A", Description: "T1 A", TenantId: "T2"},
+		{Code: "B", Description: "T1 B"},
+	}
+	for _, v := range data {
+		if err := Insert(t1, v); err != nil {
+			t.Fatal(err)
+		}
+		if v.TenantId != "T1" {
+			t.Errorf("got tenant:%s want:T1", v.TenantId)
+		}
+	}
+	if err := Insert(t2, &testTenantTable{Code: "A", Description: "T2 A"}); err != nil {
+		t.Fatal(err)
+	}
+	count := func(db DBExecer) int {
+		n, err := Count(db, &testTenantTable{}, Cursor{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	description := func(db DBExecer, code string) string {
+		got := &testTenantTable{Code: code}
+		if err := Get(db, got); err != nil {
+			t.Fatal(err)
+		}
+		return got.Description
+	}
+
+	t.Run("Read", func(t *testing.T) {
+		if n := count(t1); n != 2 {
+			t.Errorf("got T1 count:%d want:2", n)
+		}
+		got, c, err := Fetch(t2, &testTenantTable{}, Cursor{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].(*testTenantTable).Description != "T2 A" {
+			t.Errorf("got T2 fetch:%v", got)
+		}
+		if len(c.Filters) != 0 {
+			t.Errorf("got cursor filters:%v want none", c.Filters)
+		}
+		if d := description(t2, "A"); d != "T2 A" {
+			t.Errorf("got T2 A:%s", d)
+		}
+		ok, err := Exists(t2, &testTenantTable{}, Filter{Field: "code", Op: "=", Value: "B"})
+		if err != nil || ok {
+			t.Errorf("T2 exists B got:%t err:%v want false", ok, err)
+		}
+		//the tenant filter from the caller can not widen the scope.
+		fs := []Filter{{Field: "tenant_id", Op: "=", Value: "T1"}}
+		if got, _, err = Fetch(t2, &testTenantTable{}, Cursor{Filters: fs}); err != nil || len(got) != 0 {
+			t.Errorf("T2 fetch T1 got:%v err:%v want none", got, err)
+		}
+	})
+	t.Run("Write", func(t *testing.T) {
+		change := map[string]interface{}{"description": "T2 updated"}
+		if err := Update(t2, &testTenantTable{Code: "B"}, change); err != nil {
+			t.Fatal(err)
+		}
+		if err := Update(t2, &testTenantTable{Code: "A"}, change); err != nil {
+			t.Fatal(err)
+		}
+		if d := description(t1, "A"); d != "T1 A" {
+			t.Errorf("T2 update change T1 A:%s", d)
+		}
+		if err := UpdateAll(t2, &testTenantTable{}, map[string]interface{}{"description": "all"}); err != nil {
+			t.Fatal(err)
+		}
+		if d := description(t1, "B"); d != "T1 B" {
+			t.Errorf("T2 update all change T1 B:%s", d)
+		}
+		if err := Update(t2, &testTenantTable{Code: "A"}, map[string]interface{}{"tenant_id": "T1"}); err == nil {
+			t.Error("update tenant want error got nil")
+		}
+		bad := map[string]interface{}{"description = 'x', tenant_id": "T1"}
+		if err := Update(t2, &testTenantTable{Code: "A"}, bad); err == nil {
+			t.Error("update not a field want error got nil")
+		}
+		if err := Delete(t2, &testTenantTable{Code: "B"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := DeleteAll(t2, &testTenantTable{}); err != nil {
+			t.Fatal(err)
+		}
+		if n := count(t1); n != 2 {
+			t.Errorf("T2 delete change T1 count:%d want:2", n)
+		}
+		if n := count(t2); n != 0 {
+			t.Errorf("got T2 count:%d want:0", n)
+		}
+	})
+	t.Run("Tx", func(t *testing.T) {
+		err := WithTx(context.Background(), t1, nil, func(tx DBExecer) error {
+			if tenant, ok := TenantOf(tx); !ok || tenant != "T1" {
+				t.Errorf("got tx tenant:%s want:T1", tenant)
+			}
+			return Insert(tx, &testTenantTable{Code: "C", Description: "T1 C"})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := count(t1); n != 3 {
+			t.Errorf("got T1 count:%d want:3", n)
+		}
+	})
+	t.Run("NoTenant", func(t *testing.T) {
+		for _, dbe := range []DBExecer{db, WithTenant(db, "")} {
+			if _, _, err := Fetch(dbe, &testTenantTable{}, Cursor{}); !errors.Is(err, ErrNoTenant) {
+				t.Errorf("fetch got err:%v want:%v", err, ErrNoTenant)
+			}
+			if err := Insert(dbe, &testTenantTable{Code: "D"}); !errors.Is(err, ErrNoTenant) {
+				t.Errorf("insert got err:%v want:%v", err, ErrNoTenant)
+			}
+			if err := DeleteAll(dbe, &testTenantTable{}); !errors.Is(err, ErrNoTenant) {
+				t.Errorf("delete all got err:%v want:%v", err, ErrNoTenant)
+			}
+		}
+		//the table that is not scoped does not need a tenant.
+		if _, _, err := Fetch(db, &testTable{}, Cursor{}); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package dbaccess
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+//TxOptions is the option for WithTx, nil TxOptions use the default value.
+type TxOptions struct {
+	//Isolation is the transaction isolation level, zero value use the driver default.
+	Isolation sql.IsolationLevel
+	//ReadOnly start a read only transaction.
+	ReadOnly bool
+	//MaxRetries is how many times the transaction is retried when it fail because of
+	//deadlock, lock wait timeout or serialization failure. Default 3, negative
+	//value disable the retry.
+	MaxRetries int
+	//Backoff is the wait before the first retry, it is doubled on every retry.
+	//Default 10ms.
+	Backoff time.Duration
+}
+
+//TxBeginner is an interface that can start a transaction, *sql.DB implement TxBeginner.
+type TxBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+//txExecer is the DBExecer given to the WithTx callback, depth is the number of
+//savepoint of the nested WithTx. If cache is set the query use the cached statement.
+type txExecer struct {
+	*sql.Tx
+	depth int
+	cache *StmtCache
+}
+
+//Query implement DBExecer.
+func (t *txExecer) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	if t.cache == nil {
+		return t.Tx.Query(query, args...)
+	}
+	entry, err := t.cache.acquire(query, true)
+	if err != nil {
+		return nil, err
+	}
+	defer t.cache.release(entry)
+	return t.Tx.Stmt(entry.stmt).Query(args...)
+}
+
+//QueryRow implement DBExecer.
+func (t *txExecer) QueryRow(query string, args ...interface{}) *sql.Row {
+	if t.cache == nil {
+		return t.Tx.QueryRow(query, args...)
+	}
+	entry, err := t.cache.acquire(query, true)
+	if err != nil {
+		return t.Tx.QueryRow(query, args...)
+	}
+	defer t.cache.release(entry)
+	return t.Tx.Stmt(entry.stmt).QueryRow(args...)
+}
+
+//Exec implement DBExecer.
+func (t *txExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if t.cache == nil {
+		return t.Tx.Exec(query, args...)
+	}
+	entry, err := t.cache.acquire(query, true)
+	if err != nil {
+		return nil, err
+	}
+	defer t.cache.release(entry)
+	return t.Tx.Stmt(entry.stmt).Exec(args...)
+}
+
+//WithTx run fn inside a transaction, it commit if fn return nil and rollback if fn
+//return an error or panic (the panic is propagated after the rollback).
+//If db is a *StmtCache the callback DBExecer use the cached statement.
+//If db is a transaction (*sql.Tx or the DBExecer given to fn), WithTx use a savepoint
+//so the nested call can rollback without rolling back the outer transaction,
+//the opts is ignored for the nested call.
+//If db has a tenant (WithTenant) the callback DBExecer has the same tenant.
+//The transaction is retried on deadlock or serialization error, so fn should not have
+//side effect outside the transaction.
+func WithTx(ctx context.Context, db DBExecer, opts *TxOptions, fn func(tx DBExecer) error) error {
+	switch x := db.(type) {
+	case *tenantExecer:
+		//the transaction keep the tenant.
+		return WithTx(ctx, x.DBExecer, opts, func(tx DBExecer) error {
+			return fn(WithTenant(tx, x.tenant))
+		})
+	case *observedExecer:
+		//the transaction keep the observer.
+		return WithTx(ctx, x.DBExecer, opts, func(tx DBExecer) error {
+			return fn(&observedExecer{DBExecer: tx, o: x.o, op: x.op, table: x.table})
+		})
+	case *txExecer:
+		return withSavepoint(x, fn)
+	case *sql.Tx:
+		return withSavepoint(&txExecer{Tx: x}, fn)
+	case TxBeginner:
+		return withRetry(ctx, x, opts, fn)
+	}
+	return fmt.Errorf("dbaccess: %T can not start a transaction", db)
+}
+
+func withRetry(ctx context.Context, db TxBeginner, opts *TxOptions, fn func(tx DBExecer) error) error {
+	o := TxOptions{MaxRetries: 3, Backoff: 10 * time.Millisecond}
+	if opts != nil {
+		o.Isolation = opts.Isolation
+		o.ReadOnly = opts.ReadOnly
+		if opts.MaxRetries != 0 {
+			o.MaxRetries = opts.MaxRetries
+		}
+		if opts.Backoff != 0 {
+			o.Backoff = opts.Backoff
+		}
+	}
+	backoff := o.Backoff
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, db, o, fn)
+		if err == nil || attempt >= o.MaxRetries || !IsRetryable(err) {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func runTx(ctx context.Context, db TxBeginner, o TxOptions, fn func(tx DBExecer) error) (err error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: o.Isolation, ReadOnly: o.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+	cache, _ := db.(*StmtCache)
+	if err = fn(&txExecer{Tx: tx, cache: cache}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func withSavepoint(tx *txExecer, fn func(tx DBExecer) error) (err error) {
+	nested := &txExecer{Tx: tx.Tx, depth: tx.depth + 1, cache: tx.cache}
+	name := fmt.Sprintf("sp_%d", nested.depth)
+	if _, err = tx.Tx.Exec("SAVEPOINT " + name); err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Tx.Exec("ROLLBACK TO SAVEPOINT " + name)
+			panic(p)
+		}
+	}()
+	if err = fn(nested); err != nil {
+		tx.Tx.Exec("ROLLBACK TO SAVEPOINT " + name)
+		return err
+	}
+	_, err = tx.Tx.Exec("RELEASE SAVEPOINT " + name)
+	return err
+}
+
+//IsRetryable return true if err is a deadlock (MySQL 1213), lock wait timeout
+//(MySQL 1205) or serialization failure (Postgres 40001 and 40P01).
+func IsRetryable(err error) bool {
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		return me.Number == 1213 || me.Number == 1205
+	}
+	var se interface{ SQLState() string }
+	if errors.As(err, &se) {
+		state := se.SQLState()
+		return state == "40001" || state == "40P01"
+	}
+	return false
+}
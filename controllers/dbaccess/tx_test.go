@@ -0,0 +1,118 @@
+package dbaccess
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestWithTx(t *testing.T) {
+	db := prepareTest(t)
+	defer db.Close()
+	ctx := context.Background()
+	nd := time.Now()
+	now := time.Date(nd.Year(), nd.Month(), nd.Day(), 0, 0, 0, 0, time.UTC)
+	exist := func(t *testing.T, code string) bool {
+		err := Get(db, &testTable{Code: code})
+		if err == sql.ErrNoRows {
+			return false
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return true
+	}
+
+	t.Run("Commit", func(t *testing.T) {
+		err := WithTx(ctx, db, nil, func(tx DBExecer) error {
+			return Insert(tx, &testTable{Code: "AA", TransactionDate: now})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exist(t, "AA") {
+			t.Error("committed data does not exist")
+		}
+	})
+
+	t.Run("Rollback on error", func(t *testing.T) {
+		errFn := errors.New("fn error")
+		err := WithTx(ctx, db, nil, func(tx DBExecer) error {
+			if err := Insert(tx, &testTable{Code: "AB", TransactionDate: now}); err != nil {
+				return err
+			}
+			return errFn
+		})
+		if err != errFn {
+			t.Fatalf("got err:%v want:%v", err, errFn)
+		}
+		if exist(t, "AB") {
+			t.Error("rolled back data exist")
+		}
+	})
+
+	t.Run("Rollback on panic", func(t *testing.T) {
+		func() {
+			defer func() {
+				if p := recover(); p == nil {
+					t.Error("panic is not propagated")
+				}
+			}()
+			WithTx(ctx, db, nil, func(tx DBExecer) error {
+				if err := Insert(tx, &testTable{Code: "AC", TransactionDate: now}); err != nil {
+					return err
+				}
+				panic("fn panic")
+			})
+		}()
+		if exist(t, "AC") {
+			t.Error("rolled back data exist")
+		}
+	})
+
+	t.Run("Savepoint", func(t *testing.T) {
+		err := WithTx(ctx, db, nil, func(tx DBExecer) error {
+			if err := Insert(tx, &testTable{Code: "AD", TransactionDate: now}); err != nil {
+				return err
+			}
+			WithTx(ctx, tx, nil, func(tx DBExecer) error {
+				if err := Insert(tx, &testTable{Code: "AE", TransactionDate: now}); err != nil {
+					return err
+				}
+				return errors.New("rollback nested")
+			})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exist(t, "AD") {
+			t.Error("outer transaction data does not exist")
+		}
+		if exist(t, "AE") {
+			t.Error("rolled back savepoint data exist")
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		calls := 0
+		opts := &TxOptions{Isolation: sql.LevelSerializable, Backoff: time.Millisecond}
+		err := WithTx(ctx, db, opts, func(tx DBExecer) error {
+			calls++
+			if calls == 1 {
+				return &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls != 2 {
+			t.Errorf("got calls:%d want:2", calls)
+		}
+	})
+}
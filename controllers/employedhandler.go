@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/url"
+	"time"
 
 	"github.com/riyan/apiatex/controllers/dbaccess"
 	"github.com/riyan/apiatex/controllers/webserver/webhandler"
@@ -18,35 +18,41 @@ func (wh eHandler) handleGETEmployed(w http.ResponseWriter, r *http.Request) web
 	res := webhandler.Response{}
 	paths := UrlPath(r.URL, wh.pattern)
 
-	db, err := webhandler.DBFromContext(r.Context())
+	db, err := webhandler.ReaderFromContext(r.Context())
 	if err != nil {
 		res.Error(err, http.StatusInternalServerError)
 		return res
 	}
-	if len(paths) == 2 {
-		id, err := url.PathUnescape(paths[1])
-		// fmt.Printf("id : %s", id)
+	if len(paths) == 2 && paths[1] != "" {
+		asOf, err := QueryTime(r.URL.Query(), "as_of")
 		if err != nil {
-			res.Error(err, http.StatusInternalServerError)
+			res.Error(err, http.StatusBadRequest)
 			return res
 		}
-
-		if id != "" {
-			Em := &models.Employed{Id: id}
-			err := Em.Get(db)
-			if err != nil {
-				res.Error(err, http.StatusOK)
-				return res
-			}
-			res.Data = Em
+		Em := &models.Employed{}
+		if err = KeyFromPath(Em, paths[1]); err != nil {
+			res.Error(err, http.StatusBadRequest)
+			return res
+		}
+		include := QueryInclude(r.URL.Query())
+		if asOf.IsZero() {
+			err = Em.Get(db, include...)
+		} else if err = Em.GetAsOf(db, asOf); err == nil {
+			err = dbaccess.Preload(db, []dbaccess.Table{Em}, include...)
+		}
+		if err != nil {
+			res.Error(err, http.StatusOK)
 			return res
 		}
+		res.Data = Em
+		return res
 
 	}
 	var values = r.URL.Query()
 	set, cursor, err := QueryCursor(values)
 	if err != nil {
 		res.Error(err, http.StatusBadRequest)
+		return res
 	}
 	if !set {
 		flds, err := QueryFields(values)
@@ -69,72 +75,239 @@ func (wh eHandler) handleGETEmployed(w http.ResponseWriter, r *http.Request) web
 			res.Error(err, http.StatusBadRequest)
 			return res
 		}
+		asOf, err := QueryTime(values, "as_of")
+		if err != nil {
+			res.Error(err, http.StatusBadRequest)
+			return res
+		}
+		page, perPage, err := QueryPage(values)
+		if err != nil {
+			res.Error(err, http.StatusBadRequest)
+			return res
+		}
+		if page > 0 && perPage > 0 {
+			lmt = perPage
+		}
 		cursor = dbaccess.Cursor{
 			Fields:     flds,
 			Filters:    fil,
 			OrderBy:    srt,
 			Descending: asc,
 			Limit:      lmt,
+			AsOf:       asOf,
+			Include:    QueryInclude(values),
+			Page:       page,
+			Search:     QuerySearch(values),
 		}
 	}
+	cursor.Limit = wh.pageSize(cursor.Limit)
 
-	var employeds []dbaccess.Table
 	tem := &models.Employed{}
-	employeds, cursor, err = dbaccess.Fetch(db, tem, cursor)
+	if cursor.Page > 0 {
+		page, err := dbaccess.PageOf(db, tem, cursor)
+		if err != nil {
+			res.Error(err, http.StatusOK)
+			return res
+		}
+		res.Page = &page
+	}
+	if len(cursor.Include) != 0 {
+		//relations is loaded in one query for all the records, so the records can not
+		//be streamed.
+		var employeds []dbaccess.Table
+		employeds, cursor, err = dbaccess.Fetch(db, tem, cursor)
+		if err != nil {
+			res.Error(err, http.StatusOK)
+			return res
+		}
+		res.Data = employeds
+		res.Cursor = cursor
+		return res
+	}
+	rows, err := dbaccess.FetchIter(db, tem, cursor)
 	if err != nil {
 		res.Error(err, http.StatusOK)
 		return res
 	}
-	res.Data = employeds
-	res.Cursor = cursor
+	//rows is streamed and closed when the response written.
+	res.Data = rows
 	return res
 }
-func (wh eHandler) handlePOSTEmployed(w http.ResponseWriter, r *http.Request) webhandler.Response {
+//handleExportEmployed stream every employed record that match with the query, the
+//records is fetched page by page so the memory does not grow with the table.
+func (wh eHandler) handleExportEmployed(w http.ResponseWriter, r *http.Request) webhandler.Response {
 	res := webhandler.Response{}
-	body, err := ioutil.ReadAll(r.Body)
+	values := r.URL.Query()
+	flds, err := QueryFields(values)
 	if err != nil {
 		res.Error(err, http.StatusBadRequest)
 		return res
 	}
-	em := &models.Employed{}
-	if err := json.Unmarshal(body, em); err != nil {
+	srt, desc, err := QuerySort(values)
+	if err != nil {
 		res.Error(err, http.StatusBadRequest)
 		return res
 	}
-	db, err := webhandler.DBFromContext(r.Context())
+	fil, err := QueryFilter(values)
 	if err != nil {
-		res.Error(err, http.StatusInternalServerError)
+		res.Error(err, http.StatusBadRequest)
+		return res
+	}
+	asOf, err := QueryTime(values, "as_of")
+	if err != nil {
+		res.Error(err, http.StatusBadRequest)
 		return res
 	}
-	tx, err := db.Begin()
+	db, err := webhandler.ReaderFromContext(r.Context())
 	if err != nil {
 		res.Error(err, http.StatusInternalServerError)
 		return res
 	}
-	if err = em.Insert(tx); err != nil {
+	cursor := dbaccess.Cursor{
+		Fields:     flds,
+		Filters:    fil,
+		OrderBy:    srt,
+		Descending: desc,
+		AsOf:       asOf,
+		Search:     QuerySearch(values),
+	}
+	//the pages stop when the client disconnect.
+	rows, err := dbaccess.FetchPages(r.Context(), db, &models.Employed{}, cursor)
+	if err != nil {
 		res.Error(err, http.StatusOK)
-		tx.Rollback()
 		return res
 	}
-	if err = tx.Commit(); err != nil {
+	res.Data = rows
+	res.Gzip = true
+	return res
+}
+
+//handlePATCHEmployed apply the JSON Merge Patch or the JSON Patch on the employed
+//record, the record is read and updated in one transaction.
+func (wh eHandler) handlePATCHEmployed(w http.ResponseWriter, r *http.Request) webhandler.Response {
+	res := webhandler.Response{}
+	paths := UrlPath(r.URL, wh.pattern)
+	if len(paths) != 2 || paths[1] == "" {
+		res.Error(errors.New("specify id to patch"), http.StatusBadRequest)
+		return res
+	}
+	em := &models.Employed{}
+	if err := KeyFromPath(em, paths[1]); err != nil {
+		res.Error(err, http.StatusBadRequest)
+		return res
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		res.Error(err, http.StatusBadRequest)
+		return res
+	}
+	db, err := webhandler.DBFromContext(r.Context())
+	if err != nil {
 		res.Error(err, http.StatusInternalServerError)
 		return res
 	}
+	err = withTx(r.Context(), db, func(tx dbaccess.DBExecer) error {
+		if err := em.Get(tx); err != nil {
+			return err
+		}
+		change, err := patchTable(em, r.Header.Get("Content-Type"), body)
+		if err != nil || len(change) == 0 {
+			return err
+		}
+		//the removed field must not make the record invalid.
+		if err := em.Validate(); err != nil {
+			return patchError{code: http.StatusUnprocessableEntity, err: err}
+		}
+		_, err = em.Update(tx, change)
+		return err
+	})
+	var pe patchError
+	if errors.As(err, &pe) {
+		res.Error(pe.err, pe.code)
+		return res
+	}
+	if err != nil {
+		res.Error(err, http.StatusOK)
+		return res
+	}
 	res.Data = em
 	return res
 }
-func (wh eHandler) handleDELETEEmployed(w http.ResponseWriter, r *http.Request) webhandler.Response {
+
+//handleDiffEmployed compare the employed record on from and to query, from is
+//required and to is now if it is empty.
+func (wh eHandler) handleDiffEmployed(w http.ResponseWriter, r *http.Request) webhandler.Response {
 	res := webhandler.Response{}
 	paths := UrlPath(r.URL, wh.pattern)
-	// fmt.Printf("len path:%d last:%s\n", len(paths), paths[len(paths)-1])
-	last, err := url.PathUnescape(paths[1])
-	// fmt.Printf("id : %s", id)
+	em := &models.Employed{}
+	if err := KeyFromPath(em, paths[1]); err != nil {
+		res.Error(err, http.StatusBadRequest)
+		return res
+	}
+	values := r.URL.Query()
+	from, err := QueryTime(values, "from")
+	if err != nil {
+		res.Error(err, http.StatusBadRequest)
+		return res
+	}
+	if from.IsZero() {
+		res.Error(errors.New("from is required"), http.StatusBadRequest)
+		return res
+	}
+	to, err := QueryTime(values, "to")
+	if err != nil {
+		res.Error(err, http.StatusBadRequest)
+		return res
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	db, err := webhandler.ReaderFromContext(r.Context())
 	if err != nil {
 		res.Error(err, http.StatusInternalServerError)
 		return res
 	}
-	if last == "" || last == "employed" {
-		res.Error(errors.New("specify id to delete"), http.StatusOK)
+	changes, err := dbaccess.Diff(db, em, from, to)
+	if err != nil {
+		res.Error(err, http.StatusOK)
+		return res
+	}
+	res.Data = changes
+	return res
+}
+
+//handleAggregateEmployed group the employed records and compute the aggregations.
+func (wh eHandler) handleAggregateEmployed(w http.ResponseWriter, r *http.Request) webhandler.Response {
+	res := webhandler.Response{}
+	q, err := QueryAggregate(r.URL.Query())
+	if err != nil {
+		res.Error(err, http.StatusBadRequest)
+		return res
+	}
+	db, err := webhandler.ReaderFromContext(r.Context())
+	if err != nil {
+		res.Error(err, http.StatusInternalServerError)
+		return res
+	}
+	rows, err := dbaccess.Aggregate(db, &models.Employed{}, q)
+	if err != nil {
+		res.Error(err, http.StatusOK)
+		return res
+	}
+	res.Data = rows
+	return res
+}
+
+func (wh eHandler) handlePOSTEmployed(w http.ResponseWriter, r *http.Request) webhandler.Response {
+	res := webhandler.Response{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		res.Error(err, http.StatusBadRequest)
+		return res
+	}
+	em := &models.Employed{}
+	if err := json.Unmarshal(body, em); err != nil {
+		res.Error(err, http.StatusBadRequest)
 		return res
 	}
 	db, err := webhandler.DBFromContext(r.Context())
@@ -142,23 +315,78 @@ func (wh eHandler) handleDELETEEmployed(w http.ResponseWriter, r *http.Request)
 		res.Error(err, http.StatusInternalServerError)
 		return res
 	}
-	tx, err := db.Begin()
+	err = withTx(r.Context(), db, func(tx dbaccess.DBExecer) error {
+		return em.Insert(tx)
+	})
+	if err != nil {
+		res.Error(err, http.StatusOK)
+		return res
+	}
+	res.Data = em
+	return res
+}
+//handleImportEmployed import the employed records from the CSV or NDJSON body and
+//report the result of every row.
+func (wh eHandler) handleImportEmployed(w http.ResponseWriter, r *http.Request) webhandler.Response {
+	res := webhandler.Response{}
+	opt, err := QueryImport(r.URL.Query())
+	if err != nil {
+		res.Error(err, http.StatusBadRequest)
+		return res
+	}
+	if err = checkMapping(&models.Employed{}, opt.Mapping); err != nil {
+		res.Error(err, http.StatusBadRequest)
+		return res
+	}
+	rows, err := newRowReader(r.Header.Get("Content-Type"), r.Body)
+	if err == errUnsupportedSource {
+		res.Error(err, http.StatusUnsupportedMediaType)
+		return res
+	}
+	if err != nil {
+		res.Error(err, http.StatusBadRequest)
+		return res
+	}
+	db, err := webhandler.DBFromContext(r.Context())
 	if err != nil {
 		res.Error(err, http.StatusInternalServerError)
 		return res
 	}
-	em := models.Employed{Id: last}
-	if err = em.Delete(tx); err != nil {
+	report, err := importTable(r.Context(), db, &models.Employed{}, rows, opt)
+	res.Data = report
+	if err != nil {
 		res.Error(err, http.StatusOK)
-		tx.Rollback()
+	}
+	return res
+}
+func (wh eHandler) handleDELETEEmployed(w http.ResponseWriter, r *http.Request) webhandler.Response {
+	res := webhandler.Response{}
+	paths := UrlPath(r.URL, wh.pattern)
+	if len(paths) < 2 || paths[1] == "" {
+		res.Error(errors.New("specify id to delete"), http.StatusOK)
 		return res
 	}
-	if err = tx.Commit(); err != nil {
+	em := &models.Employed{}
+	if err := KeyFromPath(em, paths[1]); err != nil {
+		res.Error(err, http.StatusBadRequest)
+		return res
+	}
+	db, err := webhandler.DBFromContext(r.Context())
+	if err != nil {
 		res.Error(err, http.StatusInternalServerError)
 		return res
 	}
+	err = withTx(r.Context(), db, func(tx dbaccess.DBExecer) error {
+		return em.Delete(tx)
+	})
+	if err != nil {
+		res.Error(err, http.StatusOK)
+		return res
+	}
 	return res
 }
+//handlePUTEmployed replace the employed record, the field that is not on the body is
+//set to its zero value.
 func (wh eHandler) handlePUTEmployed(w http.ResponseWriter, r *http.Request) webhandler.Response {
 	var res webhandler.Response
 	body, err := ioutil.ReadAll(r.Body)
@@ -172,32 +400,53 @@ func (wh eHandler) handlePUTEmployed(w http.ResponseWriter, r *http.Request) web
 		res.Error(err, http.StatusBadRequest)
 		return res
 	}
-	id, ok := data["id"]
-	if !ok {
-		res.Error(errors.New("id must be set"), http.StatusOK)
+	em := &models.Employed{}
+	if paths := UrlPath(r.URL, wh.pattern); len(paths) == 2 && paths[1] != "" {
+		err = KeyFromPath(em, paths[1])
+		if id, ok := data["id"]; ok && err == nil && id != em.Id {
+			err = fmt.Errorf("id:%v does not match the path", id)
+		}
+	} else {
+		err = KeyFromData(em, data)
+	}
+	if err != nil {
+		res.Error(err, http.StatusOK)
 		return res
 	}
-	db, err := webhandler.DBFromContext(r.Context())
+	replace, change, err := replaceChange(em, data)
 	if err != nil {
-		res.Error(err, http.StatusInternalServerError)
+		res.Error(err, http.StatusBadRequest)
 		return res
 	}
-	tx, err := db.Begin()
+	db, err := webhandler.DBFromContext(r.Context())
 	if err != nil {
 		res.Error(err, http.StatusInternalServerError)
 		return res
 	}
-	em := &models.Employed{Id: fmt.Sprintf("%s", id)}
-	data, err = em.Update(tx, data)
-	if err != nil {
-		tx.Rollback()
-		res.Error(err, http.StatusOK)
+	err = withTx(r.Context(), db, func(tx dbaccess.DBExecer) error {
+		//the record must exist, PUT does not create it.
+		if err := (&models.Employed{Id: em.Id}).Get(tx); err != nil {
+			return err
+		}
+		//the field that is not on the data is cleared, so the replace is validated.
+		if err := replace.(*models.Employed).Validate(); err != nil {
+			return patchError{code: http.StatusUnprocessableEntity, err: err}
+		}
+		if _, err := em.Update(tx, change); err != nil {
+			return err
+		}
+		//the response is the stored record.
+		return em.Get(tx)
+	})
+	var pe patchError
+	if errors.As(err, &pe) {
+		res.Error(pe.err, pe.code)
 		return res
 	}
-	if err = tx.Commit(); err != nil {
-		res.Error(err, http.StatusInternalServerError)
+	if err != nil {
+		res.Error(err, http.StatusOK)
 		return res
 	}
-	res.Data = data
+	res.Data = em
 	return res
 }
@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"bytes"
+	"compress/gzip"
 	"database/sql"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -10,28 +12,34 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/riyan/apiatex/controllers/dbaccess"
 	"github.com/riyan/apiatex/controllers/webserver/webhandler"
 	"github.com/riyan/apiatex/models"
 )
 
-// dbh provide db to handler.
+// dbh provide db and tenant to handler.
 type dbh struct {
-	db *sql.DB
+	db     *sql.DB
+	tenant string
 }
 
 func (d dbh) Handle(w http.ResponseWriter, r *http.Request) webhandler.Response {
 	res := webhandler.Response{}
 	res.Ctx = webhandler.NewContextWithDB(r.Context(), d.db)
+	if d.tenant != "" {
+		res.Ctx = webhandler.NewContextWithTenant(res.Ctx, d.tenant)
+	}
 	return res
 }
 
 var em = []*models.Employed{
 	&models.Employed{
 		Id: "11111111", NameEmployed: "Tony Agus", Email: "Tony@gmail.com",
-		Phone: "0812312312", Address: "Kp. Cilengsiii",
+		Phone: "0812312312", Address: "Kp. Cilengsiii", DepartmentId: "D01",
 	},
 	&models.Employed{
 		Id: "22222222", NameEmployed: "Agus Tony", Email: "Agus@gmail.com",
@@ -54,9 +62,11 @@ var em = []*models.Employed{
 func TestEmployedHandler(t *testing.T) {
 	dbt := PrepareTest()
 	defer dbt.Close()
-	handle := webhandler.New(dbh{db: dbt}, eHandler{pattern: "/api/v1/e/"})
+	tdb := dbaccess.WithTenant(dbt, testTenant)
+	handle := webhandler.New(dbh{db: dbt, tenant: testTenant}, eHandler{pattern: "/api/v1/e/", maxPageSize: DefaultMaxPageSize})
 	ts := httptest.NewServer(handle)
 	defer ts.Close()
+	beforeUpdate := time.Now().UTC()
 
 	t.Run("Get Request ALL Employed", func(t *testing.T) {
 		urls := ts.URL + "/api/v1/e/employed/"
@@ -92,6 +102,86 @@ func TestEmployedHandler(t *testing.T) {
 		}
 		//fmt.Printf("result :%v\n", asset)
 	})
+	t.Run("Get Employed CSV", func(t *testing.T) {
+		urls := ts.URL + "/api/v1/e/employed/?fields=name_employed,id&limit=2&format=csv"
+		res, err := http.Get(urls)
+		if err != nil {
+			t.Fatalf("get err:%v", err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Got status :%v Want:StatusOK body:%s", res.StatusCode, data)
+		}
+		rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			t.Fatalf("err:%v data:%s", err, data)
+		}
+		if len(rows) != 3 || strings.Join(rows[0], ",") != "name_employed,id" {
+			t.Fatalf("got csv:%v want header name_employed,id and 2 rows", rows)
+		}
+		cursor, err := dbaccess.Decode(res.Header.Get(webhandler.NextCursorHeader))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cursor.LastArgs) != 1 || cursor.LastArgs[0] != rows[2][1] {
+			t.Errorf("got next cursor:%+v want last id:%s", cursor, rows[2][1])
+		}
+		if !strings.Contains(res.Header.Get("Link"), `rel="next"`) {
+			t.Errorf("got link:%q", res.Header.Get("Link"))
+		}
+	})
+	t.Run("Export Employed", func(t *testing.T) {
+		req, err := http.NewRequest("GET", ts.URL+"/api/v1/e/employed/_export?fields=id,name_employed&sort=id", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "application/x-ndjson")
+		req.Header.Set("Accept-Encoding", "gzip")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Encoding") != "gzip" {
+			t.Fatalf("Got status :%v header:%v Want:StatusOK gzip", res.StatusCode, res.Header)
+		}
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		dec := json.NewDecoder(zr)
+		for dec.More() {
+			var got map[string]interface{}
+			if err := dec.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2 || got["name_employed"] == nil {
+				t.Errorf("got record:%v want id and name_employed", got)
+			}
+			ids = append(ids, fmt.Sprint(got["id"]))
+		}
+		if len(ids) != len(em) || !sort.StringsAreSorted(ids) {
+			t.Errorf("got ids:%v want %d sorted ids", ids, len(em))
+		}
+
+		res, err = http.Get(ts.URL + "/api/v1/e/employed/_export?format=csv&fields=name_employed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(res.Body).ReadAll()
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != len(em)+1 || rows[0][0] != "name_employed" || len(rows[0]) != 1 {
+			t.Errorf("got csv:%v", rows)
+		}
+	})
 	t.Run("GET 1 Employed", func(t *testing.T) {
 		getone := em[1]
 		urls := ts.URL + "/api/v1/e/employed/" + getone.Id
@@ -129,6 +219,38 @@ func TestEmployedHandler(t *testing.T) {
 		}
 
 	})
+	t.Run("Include Department", func(t *testing.T) {
+		urls := ts.URL + "/api/v1/e/employed/?include=department&filters=" + url.QueryEscape("id,=,"+em[0].Id)
+		res, err := http.Get(urls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Got status :%v Want:StatusOK", res.StatusCode)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type trd struct {
+			Err  string             `json:"err"`
+			Data []*models.Employed `json:"data"`
+		}
+		var rd trd
+		if err := json.Unmarshal(body, &rd); err != nil {
+			t.Fatalf("err : %v data: %s", err, body)
+		}
+		if rd.Err != "" {
+			t.Fatal(rd.Err)
+		}
+		if len(rd.Data) != 1 {
+			t.Fatalf("got data:%d want:1", len(rd.Data))
+		}
+		if d := rd.Data[0].Department; d == nil || d.Id != em[0].DepartmentId {
+			t.Errorf("got department:%v want:%s", d, em[0].DepartmentId)
+		}
+	})
 	t.Run("Filter Employed", func(t *testing.T) {
 		urls := ts.URL + "/api/v1/e/employed/"
 		var testemplyed = []struct {
@@ -186,6 +308,201 @@ func TestEmployedHandler(t *testing.T) {
 			}
 		}
 	})
+	t.Run("Search Employed", func(t *testing.T) {
+		if err := dbaccess.CreateSearchIndex(dbt, &models.Employed{}); err != nil {
+			t.Skipf("full-text index is not supported: %v", err)
+		}
+		res, err := http.Get(ts.URL + "/api/v1/e/employed/?q=" + url.QueryEscape("cocoloking"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type trd struct {
+			Err  string             `json:"err"`
+			Data []*models.Employed `json:"data"`
+		}
+		var rd trd
+		if err := json.Unmarshal(body, &rd); err != nil {
+			t.Fatalf("err : %v data: %s", err, body)
+		}
+		if rd.Err != "" {
+			t.Fatal(rd.Err)
+		}
+		if len(rd.Data) != 1 {
+			t.Fatalf("got data:%d want:1", len(rd.Data))
+		}
+		compareEmployed(t, rd.Data[0], em[1])
+	})
+	t.Run("Tenant Employed", func(t *testing.T) {
+		th := webhandler.New(dbh{db: dbt}, webhandler.TenantResolver{Header: "X-Tenant-ID"},
+			eHandler{pattern: "/api/v1/e/"})
+		tts := httptest.NewServer(th)
+		defer tts.Close()
+		type trd struct {
+			Err  string             `json:"err"`
+			Data []*models.Employed `json:"data"`
+		}
+		do := func(method, path, tenant, body string) (int, []byte) {
+			req, err := http.NewRequest(method, tts.URL+"/api/v1/e/employed/"+path, strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tenant != "" {
+				req.Header.Set("X-Tenant-ID", tenant)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			b, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return res.StatusCode, b
+		}
+		errOf := func(body []byte) string {
+			var rd struct {
+				Err string `json:"err"`
+			}
+			if err := json.Unmarshal(body, &rd); err != nil {
+				t.Fatalf("err : %v data: %s", err, body)
+			}
+			return rd.Err
+		}
+		if code, _ := do("GET", "", "", ""); code != http.StatusUnauthorized {
+			t.Errorf("no tenant got status:%d want:%d", code, http.StatusUnauthorized)
+		}
+		code, body := do("GET", "", "T02", "")
+		var rd trd
+		if err := json.Unmarshal(body, &rd); err != nil {
+			t.Fatalf("err : %v data: %s", err, body)
+		}
+		if code != http.StatusOK || rd.Err != "" || len(rd.Data) != 0 {
+			t.Errorf("T02 list got status:%d err:%s data:%d want empty", code, rd.Err, len(rd.Data))
+		}
+		//the same id on the other tenant.
+		post := `{"id":"` + em[0].Id + `","name_employed":"T02","email":"t02@gmail.com","phone":"0812","address":"T02","tenant_id":"` + testTenant + `"}`
+		if code, body = do("POST", "", "T02", post); code != http.StatusOK || errOf(body) != "" {
+			t.Fatalf("T02 insert got status:%d body:%s", code, body)
+		}
+		if _, body = do("PUT", em[0].Id, "T02", `{"name_employed":"T02","email":"t02@gmail.com","tenant_id":"`+testTenant+`"}`); !strings.Contains(errOf(body), "can not be updated") {
+			t.Errorf("T02 update tenant got body:%s", body)
+		}
+		if _, body = do("DELETE", em[0].Id, "T02", ""); errOf(body) != "" {
+			t.Errorf("T02 delete got body:%s", body)
+		}
+		got := &models.Employed{Id: em[0].Id}
+		if err := dbaccess.Get(tdb, got); err != nil {
+			t.Fatal(err)
+		}
+		compareEmployed(t, got, em[0])
+		if n, err := dbaccess.Count(dbaccess.WithTenant(dbt, "T02"), &models.Employed{}, dbaccess.Cursor{}); err != nil || n != 0 {
+			t.Errorf("T02 count got:%d err:%v want:0", n, err)
+		}
+	})
+	t.Run("Page Employed", func(t *testing.T) {
+		pts := httptest.NewServer(webhandler.New(dbh{db: dbt, tenant: testTenant},
+			eHandler{pattern: "/api/v1/e/", maxPageSize: 3}))
+		defer pts.Close()
+		testCase := []struct {
+			query string
+			want  []*models.Employed
+			page  *dbaccess.Page
+			last  bool
+		}{
+			{query: "sort=id&page=2&per_page=2", want: em[2:4], page: &dbaccess.Page{Number: 2, PerPage: 2, Total: len(em), TotalPages: 3}},
+			{query: "sort=id&page=1&per_page=10", want: em[:3], page: &dbaccess.Page{Number: 1, PerPage: 3, Total: len(em), TotalPages: 2}},
+			{query: "sort=id&page=3&per_page=2", want: em[4:], page: &dbaccess.Page{Number: 3, PerPage: 2, Total: len(em), TotalPages: 3},
+				last: len(em[4:]) < 2},
+			{query: "sort=id&page=4&per_page=2", page: &dbaccess.Page{Number: 4, PerPage: 2, Total: len(em), TotalPages: 3}, last: true},
+			{query: "sort=id&limit=0", want: em[:3]},
+		}
+		for i, tc := range testCase {
+			res, err := http.Get(pts.URL + "/api/v1/e/employed/?" + tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			type trd struct {
+				Err  string             `json:"err"`
+				Data []*models.Employed `json:"data"`
+				Page *dbaccess.Page     `json:"page"`
+			}
+			var rd trd
+			if err := json.Unmarshal(body, &rd); err != nil {
+				t.Fatalf("err : %v data: %s", err, body)
+			}
+			if rd.Err != "" {
+				t.Fatal(rd.Err)
+			}
+			if len(rd.Data) != len(tc.want) {
+				t.Fatalf("tc:%d got data:%d want:%d", i, len(rd.Data), len(tc.want))
+			}
+			for j, v := range tc.want {
+				compareEmployed(t, rd.Data[j], v)
+			}
+			if (rd.Page == nil) != (tc.page == nil) || (rd.Page != nil && *rd.Page != *tc.page) {
+				t.Errorf("tc:%d got page:%+v want:%+v", i, rd.Page, tc.page)
+			}
+			//the last page does not have the next cursor.
+			if tc.last == strings.Contains(string(body), `"cursor"`) {
+				t.Errorf("tc:%d last:%v got body:%s", i, tc.last, body)
+			}
+		}
+		for _, query := range []string{"page=1&per_page=0", "page=1&per_page=-1", "page=0"} {
+			res, err := http.Get(pts.URL + "/api/v1/e/employed/?" + query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusBadRequest {
+				t.Errorf("query:%s got status:%d want:400", query, res.StatusCode)
+			}
+		}
+	})
+	t.Run("Aggregate Employed", func(t *testing.T) {
+		urls := ts.URL + "/api/v1/e/employed/_aggregate?group=domain(email)&count=*&max=id&sort=" +
+			url.QueryEscape("count DESC")
+		res, err := http.Get(urls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Got status :%v Want:StatusOK", res.StatusCode)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type trd struct {
+			Err  string                   `json:"err"`
+			Data []map[string]interface{} `json:"data"`
+		}
+		var rd trd
+		if err := json.Unmarshal(body, &rd); err != nil {
+			t.Fatalf("err : %v data: %s", err, body)
+		}
+		if rd.Err != "" {
+			t.Fatal(rd.Err)
+		}
+		if len(rd.Data) != 1 {
+			t.Fatalf("got groups:%v want 1 group", rd.Data)
+		}
+		got := rd.Data[0]
+		if got["domain_email"] != "gmail.com" || got["count"] != float64(len(em)) || got["max_id"] != em[len(em)-1].Id {
+			t.Errorf("got group:%v", got)
+		}
+	})
 	t.Run("Insert Employed", func(t *testing.T) {
 		dat := models.Employed{
 			Id: "66666666", NameEmployed: "Lorem Ipsum", Email: "Sit Amet@gmail.com",
@@ -223,7 +540,7 @@ func TestEmployedHandler(t *testing.T) {
 			t.Errorf("get data:%v want:%v", gotrd, dat)
 		}
 		got := &models.Employed{Id: dat.Id}
-		err = dbaccess.Get(dbt, got)
+		err = dbaccess.Get(tdb, got)
 		if err != nil {
 			t.Fatalf("get data:%v err:%v", dat, err)
 		}
@@ -233,8 +550,10 @@ func TestEmployedHandler(t *testing.T) {
 	})
 	t.Run("Update Employed", func(t *testing.T) {
 		datper := em[0]
+		//PUT replace the record, so every field is sent.
 		dataa := map[string]interface{}{
-			"id": datper.Id, "name_employed": "Superman",
+			"id": datper.Id, "name_employed": "Superman", "email": datper.Email,
+			"phone": datper.Phone, "address": datper.Address, "department_id": datper.DepartmentId,
 		}
 		b, err := json.MarshalIndent(dataa, "", " ")
 		if err != nil {
@@ -270,16 +589,340 @@ func TestEmployedHandler(t *testing.T) {
 
 		}
 		gotrd := rd.Data
-		if gotrd.Id != dataa["id"] {
-			t.Errorf("get data:%v want:%v", gotrd, dataa)
+		if gotrd == nil || gotrd.Id != dataa["id"] || gotrd.NameEmployed != "Superman" || gotrd.Email != datper.Email {
+			t.Fatalf("get data:%v want:%v", gotrd, dataa)
 		}
 		got := &models.Employed{Id: datper.Id}
-		err = dbaccess.Get(dbt, got)
+		err = dbaccess.Get(tdb, got)
 		if err != nil {
 			t.Fatalf("get data:%v err:%v", datper, err)
 		}
 
 	})
+	t.Run("Diff Employed", func(t *testing.T) {
+		datper := em[0]
+		urls := fmt.Sprintf("%s/api/v1/e/employed/%s/_diff?from=%s", ts.URL, datper.Id,
+			url.QueryEscape(beforeUpdate.Format(time.RFC3339Nano)))
+		res, err := http.Get(urls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Got status :%v Want:StatusOK", res.StatusCode)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		type trd struct {
+			Err  string            `json:"err"`
+			Data []dbaccess.Change `json:"data"`
+		}
+		var rd trd
+		if err := json.Unmarshal(body, &rd); err != nil {
+			t.Fatalf("err : %v data: %s", err, body)
+		}
+		if rd.Err != "" {
+			t.Fatal(rd.Err)
+		}
+		if len(rd.Data) != 1 {
+			t.Fatalf("got changes:%v want 1 change", rd.Data)
+		}
+		if c := rd.Data[0]; c.Field != "name_employed" || c.From != datper.NameEmployed || c.To != "Superman" {
+			t.Errorf("got change:%+v", c)
+		}
+
+		//from is required.
+		res, err = http.Get(fmt.Sprintf("%s/api/v1/e/employed/%s/_diff", ts.URL, datper.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("Got status :%v Want:StatusBadRequest", res.StatusCode)
+		}
+	})
+	t.Run("Patch Employed", func(t *testing.T) {
+		id := em[3].Id
+		do := func(method, contentType, body string) (int, string) {
+			t.Helper()
+			req, err := http.NewRequest(method, ts.URL+"/api/v1/e/employed/"+id, strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", contentType)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			b, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return res.StatusCode, string(b)
+		}
+		get := func() *models.Employed {
+			got := &models.Employed{Id: id}
+			if err := dbaccess.Get(tdb, got); err != nil {
+				t.Fatal(err)
+			}
+			return got
+		}
+		code, body := do("PATCH", MergePatchType, `{"phone":"0899","address":null}`)
+		if code != http.StatusOK || !strings.Contains(body, `"phone":"0899"`) {
+			t.Errorf("merge patch got status:%d body:%s", code, body)
+		}
+		if got := get(); got.Phone != "0899" || got.Address != "" || got.NameEmployed != em[3].NameEmployed {
+			t.Errorf("merge patch got:%+v", got)
+		}
+		code, body = do("PATCH", JSONPatchType, `[{"op":"test","path":"/phone","value":"0899"},
+			{"op":"replace","path":"/name_employed","value":"Jane Doe"},{"op":"remove","path":"/phone"}]`)
+		if code != http.StatusOK {
+			t.Errorf("json patch got status:%d body:%s", code, body)
+		}
+		if got := get(); got.NameEmployed != "Jane Doe" || got.Phone != "" {
+			t.Errorf("json patch got:%+v", got)
+		}
+		testCase := []struct {
+			contentType string
+			body        string
+			code        int
+		}{
+			{JSONPatchType, `[{"op":"replace","path":"/name_employed","value":"X"},{"op":"test","path":"/phone","value":"0899"}]`, http.StatusConflict},
+			{JSONPatchType, `[{"op":"replace","path":"/id","value":"99"}]`, http.StatusUnprocessableEntity},
+			{JSONPatchType, `[{"op":"add","path":"/phone","value":"1"}]`, http.StatusUnprocessableEntity},
+			{JSONPatchType, `[{"op":"replace","path":"/unknown","value":"1"}]`, http.StatusUnprocessableEntity},
+			{MergePatchType, `{"tenant_id":"T02"}`, http.StatusUnprocessableEntity},
+			{MergePatchType, `{"phone":{"a":1}}`, http.StatusUnprocessableEntity},
+			{MergePatchType, `{"phone":`, http.StatusBadRequest},
+			{JSONPatchType, `[{"op":"remove","path":"/email"}]`, http.StatusUnprocessableEntity},
+			{MergePatchType, `{"name_employed":null}`, http.StatusUnprocessableEntity},
+			{"application/json", `{"phone":"1"}`, http.StatusUnsupportedMediaType},
+		}
+		for i, tc := range testCase {
+			if code, body = do("PATCH", tc.contentType, tc.body); code != tc.code {
+				t.Errorf("tc:%d got status:%d body:%s want:%d", i, code, body, tc.code)
+			}
+		}
+		if got := get(); got.NameEmployed != "Jane Doe" {
+			t.Errorf("failed patch must not update got:%+v", got)
+		}
+
+		//PUT replace every field.
+		code, body = do("PUT", "application/json", `{"name_employed":"Replaced","email":"r@example.com"}`)
+		if code != http.StatusOK || !strings.Contains(body, `"err":""`) {
+			t.Errorf("put got status:%d body:%s", code, body)
+		}
+		if got := get(); got.NameEmployed != "Replaced" || got.Email != "r@example.com" || got.DepartmentId != "" {
+			t.Errorf("put got:%+v", got)
+		}
+		if _, body = do("PUT", "application/json", `{"id":"other","name_employed":"X"}`); !strings.Contains(body, "does not match") {
+			t.Errorf("put other id got body:%s", body)
+		}
+		//the replace that clear the required field is rejected.
+		for _, b := range []string{`{}`, `{"name_employed":"X"}`, `{"name_employed":"X","email":"bad"}`} {
+			if code, body = do("PUT", "application/json", b); code != http.StatusUnprocessableEntity {
+				t.Errorf("put:%s got status:%d body:%s want:422", b, code, body)
+			}
+		}
+		if got := get(); got.NameEmployed != "Replaced" || got.Email != "r@example.com" {
+			t.Errorf("invalid put must not update got:%+v", got)
+		}
+	})
+	t.Run("Import Employed", func(t *testing.T) {
+		post := func(query, contentType, body string) ImportReport {
+			t.Helper()
+			res, err := http.Post(ts.URL+"/api/v1/e/employed/_import?"+query, contentType, strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			data, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("Got status :%v Want:StatusOK body:%s", res.StatusCode, data)
+			}
+			var rd struct {
+				Err  string       `json:"err"`
+				Data ImportReport `json:"data"`
+			}
+			if err := json.Unmarshal(data, &rd); err != nil {
+				t.Fatalf("err : %v data: %s", err, data)
+			}
+			if rd.Err != "" {
+				t.Fatal(rd.Err)
+			}
+			return rd.Data
+		}
+		exists := func(id string) bool {
+			err := dbaccess.Get(tdb, &models.Employed{Id: id})
+			if err != nil && err != sql.ErrNoRows {
+				t.Fatal(err)
+			}
+			return err == nil
+		}
+		statuses := func(report ImportReport) string {
+			s := make([]string, len(report.Rows))
+			for i, r := range report.Rows {
+				s[i] = r.Status
+			}
+			return strings.Join(s, ",")
+		}
+		csvBody := "NIK,Nama,email,phone,Catatan\n" +
+			"IMP1,Imported One,imp1@example.com,0811,baru\n" +
+			"IMP2,Imported Two,not an email,0812,\n"
+		mapping := "map=NIK:id,Nama:name_employed"
+
+		report := post(mapping+"&dry_run=true", "text/csv", csvBody)
+		if !report.DryRun || report.Created != 1 || report.Failed != 1 || statuses(report) != "created,failed" {
+			t.Errorf("got dry run report:%+v", report)
+		}
+		if r := report.Rows[1]; r.Row != 2 || r.Key != "IMP2" || !strings.Contains(r.Reason, "email") {
+			t.Errorf("got failed row:%+v", r)
+		}
+		if len(report.Ignored) != 1 || report.Ignored[0] != "Catatan" {
+			t.Errorf("got ignored:%v want:[Catatan]", report.Ignored)
+		}
+		if exists("IMP1") {
+			t.Error("dry run must not import the row")
+		}
+
+		//every chunk is its own transaction, so the valid row is imported.
+		report = post(mapping+"&chunk_size=1", "text/csv", csvBody)
+		if report.Created != 1 || report.Failed != 1 || !exists("IMP1") || exists("IMP2") {
+			t.Errorf("got chunk report:%+v", report)
+		}
+
+		//the failed row rollback the whole import.
+		update := em[2].Id
+		ndjson := `{"id":"` + update + `","name_employed":"Stephen Hawking"}` + "\n" +
+			`{"id":"IMP1","name_employed":"Imported One"}` + "\n\n" +
+			`{"id":"` + update + `","phone":"0899"}` + "\n" +
+			`{"id":` + "\n"
+		report = post("", "application/x-ndjson", ndjson)
+		if statuses(report) != "skipped,skipped,failed,failed" || report.Failed != 2 || report.Skipped != 2 {
+			t.Errorf("got ndjson report:%+v", report)
+		}
+		if r := report.Rows[1]; r.Reason != "unchanged" {
+			t.Errorf("got unchanged row:%+v", r)
+		}
+		if r := report.Rows[2]; r.Reason != "duplicate of row 1" {
+			t.Errorf("got duplicate row:%+v", r)
+		}
+		got := &models.Employed{Id: update}
+		if err := dbaccess.Get(tdb, got); err != nil || got.NameEmployed != em[2].NameEmployed {
+			t.Errorf("got employed:%+v err:%v want not updated", got, err)
+		}
+
+		report = post("on_conflict=update", "application/x-ndjson", `{"id":"`+update+`","phone":"0899"}`)
+		if report.Updated != 1 {
+			t.Errorf("got report:%+v want 1 updated", report)
+		}
+		if err := dbaccess.Get(tdb, got); err != nil || got.Phone != "0899" || got.NameEmployed != em[2].NameEmployed {
+			t.Errorf("got employed:%+v err:%v", got, err)
+		}
+		report = post("on_conflict=skip", "application/x-ndjson", `{"id":"`+update+`","phone":"0800"}`)
+		if report.Skipped != 1 || report.Rows[0].Reason != "already exists" {
+			t.Errorf("got report:%+v want 1 skipped", report)
+		}
+
+		res, err := http.Post(ts.URL+"/api/v1/e/employed/_import", "text/plain", strings.NewReader(csvBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("got status:%d want:%d", res.StatusCode, http.StatusUnsupportedMediaType)
+		}
+	})
+	t.Run("Batch Employed", func(t *testing.T) {
+		batch := func(ops string) (int, BatchReport, string) {
+			t.Helper()
+			res, err := http.Post(ts.URL+"/api/v1/e/_batch", "application/json", strings.NewReader(ops))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			data, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rd struct {
+				Err  string      `json:"err"`
+				Data BatchReport `json:"data"`
+			}
+			if res.StatusCode == http.StatusOK {
+				if err := json.Unmarshal(data, &rd); err != nil {
+					t.Fatalf("err : %v data: %s", err, data)
+				}
+			}
+			return res.StatusCode, rd.Data, rd.Err
+		}
+		get := func(id string) (*models.Employed, error) {
+			got := &models.Employed{Id: id}
+			return got, dbaccess.Get(tdb, got)
+		}
+		code, report, errm := batch(`[
+			{"method":"POST","path":"employed","body":{"id":"BAT1","name_employed":"Batch One","email":"b1@example.com"}},
+			{"method":"POST","path":"/api/v1/e/employed","body":{"id":"BAT2","name_employed":"Batch Two","email":"b2@example.com"}},
+			{"method":"PATCH","path":"employed/BAT1","content_type":"application/merge-patch+json","body":{"phone":"0877"}},
+			{"method":"DELETE","path":"employed/BAT2"},
+			{"method":"GET","path":"employed/BAT1"}]`)
+		if code != http.StatusOK || errm != "" || !report.Committed || len(report.Results) != 5 {
+			t.Fatalf("got status:%d err:%s report:%+v", code, errm, report)
+		}
+		for i, r := range report.Results {
+			if r.Status != http.StatusOK || r.Err != "" {
+				t.Errorf("operation %d got result:%+v", i, r)
+			}
+		}
+		if b, _ := json.Marshal(report.Results[4].Data); !strings.Contains(string(b), `"phone":"0877"`) {
+			t.Errorf("got get result:%s want the patched record", b)
+		}
+		if got, err := get("BAT1"); err != nil || got.Phone != "0877" {
+			t.Errorf("got employed:%+v err:%v", got, err)
+		}
+		if _, err := get("BAT2"); err != sql.ErrNoRows {
+			t.Errorf("got err:%v want BAT2 deleted", err)
+		}
+
+		//the failed operation rollback the batch.
+		code, report, errm = batch(`[
+			{"method":"PUT","path":"employed/BAT1","body":{"name_employed":"Changed","email":"c@example.com"}},
+			{"method":"POST","path":"employed","body":{"id":"BAT3","name_employed":"Batch Three","email":"b3@example.com"}},
+			{"method":"POST","path":"employed","body":{"id":"BAT1","name_employed":"Duplicate","email":"d@example.com"}},
+			{"method":"DELETE","path":"employed/BAT1"}]`)
+		if code != http.StatusOK || errm == "" || report.Committed || len(report.Results) != 4 {
+			t.Fatalf("got status:%d err:%s report:%+v", code, errm, report)
+		}
+		if r := report.Results; r[0].Status != http.StatusOK || r[2].Err == "" || r[3].Status != 0 || r[3].Err == "" {
+			t.Errorf("got results:%+v", r)
+		}
+		if got, err := get("BAT1"); err != nil || got.NameEmployed != "Batch One" {
+			t.Errorf("got employed:%+v err:%v want not changed", got, err)
+		}
+		if _, err := get("BAT3"); err != sql.ErrNoRows {
+			t.Errorf("got err:%v want BAT3 rolled back", err)
+		}
+
+		for i, ops := range []string{
+			`[]`,
+			`[{"method":"POST","path":"employed/_import"}]`,
+			`[{"method":"POST","path":"_batch"}]`,
+			`[{"method":"POST","path":"/api/v1/e/%5Fbatch"}]`,
+			`[{"method":"POST","path":"employed/%5Fimport"}]`,
+			`[{"method":"HEAD","path":"employed"}]`,
+			`{"method":"GET"}`,
+		} {
+			if code, _, _ = batch(ops); code != http.StatusBadRequest {
+				t.Errorf("tc:%d got status:%d want:%d", i, code, http.StatusBadRequest)
+			}
+		}
+	})
 	t.Run("Delete Asset", func(t *testing.T) {
 
 		employed := models.Employed{
@@ -303,7 +946,7 @@ func TestEmployedHandler(t *testing.T) {
 		defer res.Body.Close()
 
 		got := &models.Employed{Id: employed.Id}
-		err = dbaccess.Get(dbt, got)
+		err = dbaccess.Get(tdb, got)
 		if err != nil {
 
 		} else {
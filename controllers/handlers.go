@@ -1,25 +1,36 @@
 package controllers
 
 import (
-	"database/sql"
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/riyan/apiatex/controllers/dbaccess"
 	"github.com/riyan/apiatex/controllers/webserver/webhandler"
 )
 
+//DefaultMaxPageSize is the default maximum records returned by the list endpoint.
+const DefaultMaxPageSize = 100
+
 type eHandler struct {
 	pattern string
+	//maxPageSize is the maximum records returned by the list endpoint for both cursor
+	//and page pagination, zero mean unlimited.
+	maxPageSize int
 }
 
-func WebHandler(pattern string) http.Handler {
-	wh := webhandler.New(eHandler{pattern: pattern})
-	return wh
+//WebHandlers return the handlers of the pattern to be registered with App.Handle, the
+//tenant of the request is resolved before the request is handled. The limit zero or
+//greater than maxPageSize is set to maxPageSize, zero maxPageSize mean unlimited.
+//The middlewares is called after the tenant is resolved, e.g. webhandler.Idempotency.
+func WebHandlers(pattern string, tenant webhandler.TenantResolver, maxPageSize int, middlewares ...webhandler.WebHandler) []webhandler.WebHandler {
+	handlers := append([]webhandler.WebHandler{tenant}, middlewares...)
+	return append(handlers, eHandler{pattern: pattern, maxPageSize: maxPageSize})
 }
 func (wh eHandler) Handle(w http.ResponseWriter, r *http.Request) webhandler.Response {
 	var res webhandler.Response
@@ -27,14 +38,38 @@ func (wh eHandler) Handle(w http.ResponseWriter, r *http.Request) webhandler.Res
 	paths := strings.Split(path, "/")
 	urls := paths[0]
 	switch urls {
+	case "_batch":
+		if r.Method != "POST" {
+			res.Error(errors.New("Method not supported"), http.StatusBadRequest)
+			break
+		}
+		res = wh.handleBatch(w, r)
 	case "employed":
 		switch r.Method {
 		case "GET":
+			if len(paths) == 2 && paths[1] == "_aggregate" {
+				res = wh.handleAggregateEmployed(w, r)
+				break
+			}
+			if len(paths) == 2 && paths[1] == "_export" {
+				res = wh.handleExportEmployed(w, r)
+				break
+			}
+			if len(paths) == 3 && paths[2] == "_diff" {
+				res = wh.handleDiffEmployed(w, r)
+				break
+			}
 			res = wh.handleGETEmployed(w, r)
 		case "POST":
+			if len(paths) == 2 && paths[1] == "_import" {
+				res = wh.handleImportEmployed(w, r)
+				break
+			}
 			res = wh.handlePOSTEmployed(w, r)
 		case "PUT":
 			res = wh.handlePUTEmployed(w, r)
+		case "PATCH":
+			res = wh.handlePATCHEmployed(w, r)
 		case "DELETE":
 			res = wh.handleDELETEEmployed(w, r)
 		default:
@@ -45,6 +80,14 @@ func (wh eHandler) Handle(w http.ResponseWriter, r *http.Request) webhandler.Res
 	}
 	return res
 }
+//withTx run fn in the transaction injected to ctx (e.g. by the batch) or in a new
+//transaction of db, the injected transaction is committed or rolled back by its owner.
+func withTx(ctx context.Context, db dbaccess.DBExecer, fn func(tx dbaccess.DBExecer) error) error {
+	if _, ok := webhandler.TxFromContext(ctx); ok {
+		return fn(db)
+	}
+	return dbaccess.WithTx(ctx, db, nil, fn)
+}
 func UrlPath(u *url.URL, pattern string) []string {
 
 	urlpath := u.RawPath
@@ -56,6 +99,30 @@ func UrlPath(u *url.URL, pattern string) []string {
 	return path
 }
 
+//KeyFromPath set the PrimaryKey of t from the url path segment, the values of the
+//composite PrimaryKey is separated by comma: /employed/k1,k2
+func KeyFromPath(t dbaccess.Table, segment string) error {
+	values, err := dbaccess.ParseKey(segment)
+	if err != nil {
+		return err
+	}
+	return dbaccess.SetKey(t, values...)
+}
+
+//KeyFromData set the PrimaryKey of t from the PrimaryKey fields on the data.
+func KeyFromData(t dbaccess.Table, data map[string]interface{}) error {
+	fields, _ := t.PrimaryKey()
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		v, ok := data[field]
+		if !ok {
+			return fmt.Errorf("%s must be set", field)
+		}
+		values[i] = v
+	}
+	return dbaccess.SetKey(t, values...)
+}
+
 //QueryFields
 func QueryFields(query url.Values) ([]string, error) {
 	qry := query.Get("fields")
@@ -67,6 +134,20 @@ func QueryFields(query url.Values) ([]string, error) {
 	return flds, nil
 }
 
+//QueryInclude return the relations to load.
+func QueryInclude(query url.Values) []string {
+	qry := query.Get("include")
+	if qry == "" {
+		return nil
+	}
+	return strings.Split(qry, ",")
+}
+
+//QuerySearch return the full-text search text of the q query.
+func QuerySearch(query url.Values) string {
+	return strings.TrimSpace(query.Get("q"))
+}
+
 //QueryCursor
 func QueryCursor(query url.Values) (set bool, cursor dbaccess.Cursor, err error) {
 	qry := query.Get("cursor")
@@ -91,6 +172,35 @@ func QueryLimit(query url.Values) (int, error) {
 	return lmt, nil
 }
 
+//QueryPage parse the page and per_page query, page is zero if not set.
+func QueryPage(query url.Values) (page, perPage int, err error) {
+	if qry := query.Get("page"); qry != "" {
+		if page, err = strconv.Atoi(qry); err != nil {
+			return 0, 0, err
+		}
+		if page < 1 {
+			return 0, 0, fmt.Errorf("page must be greater than 0")
+		}
+	}
+	if qry := query.Get("per_page"); qry != "" {
+		if perPage, err = strconv.Atoi(qry); err != nil {
+			return 0, 0, err
+		}
+		if perPage < 1 {
+			return 0, 0, fmt.Errorf("per_page must be greater than 0")
+		}
+	}
+	return page, perPage, nil
+}
+
+//pageSize return the limit enforced by the maxPageSize.
+func (wh eHandler) pageSize(limit int) int {
+	if wh.maxPageSize > 0 && (limit <= 0 || limit > wh.maxPageSize) {
+		return wh.maxPageSize
+	}
+	return limit
+}
+
 //QuerySort
 func QuerySort(query url.Values) ([]string, bool, error) {
 	qry := query.Get("sort")
@@ -102,7 +212,7 @@ func QuerySort(query url.Values) ([]string, bool, error) {
 		if len(srt) < 1 {
 			return nil, false, fmt.Errorf("tidak memenuhi persyaratan")
 		}
-		if fieldsrt[1] != "DESC" {
+		if len(fieldsrt) < 2 || fieldsrt[1] != "DESC" {
 			descending = false
 		}
 	}
@@ -111,8 +221,11 @@ func QuerySort(query url.Values) ([]string, bool, error) {
 
 //QueryFilter
 func QueryFilter(query url.Values) ([]dbaccess.Filter, error) {
-	qry := query.Get("filters")
+	return parseFilters(query.Get("filters"))
+}
 
+//parseFilters parse filters with format field,op,value;field,op,value
+func parseFilters(qry string) ([]dbaccess.Filter, error) {
 	var fil []dbaccess.Filter
 	if qry != "" {
 
@@ -124,7 +237,6 @@ func QueryFilter(query url.Values) ([]dbaccess.Filter, error) {
 				return nil, fmt.Errorf("tidak memenuhi persyaratan")
 			}
 			valueparameter, err := url.PathUnescape(parameter[2])
-			// fmt.Printf("id : %s", id)
 			if err != nil {
 				return nil, fmt.Errorf("tidak memenuhi persyaratan")
 			}
@@ -138,10 +250,50 @@ func QueryFilter(query url.Values) ([]dbaccess.Filter, error) {
 	}
 	return fil, nil
 }
-func newTx(db *sql.DB) *sql.Tx {
-	tx, err := db.Begin()
-	if err != nil {
-		return nil
+//aggregateFuncs is the query name for each aggregate function.
+var aggregateFuncs = []string{"count", "min", "max", "sum", "avg"}
+
+//QueryAggregate parse the aggregate query:
+//	group=field,domain(email)&count=*&sum=field&having=count,>,1&sort=count DESC&limit=10
+func QueryAggregate(query url.Values) (dbaccess.AggregateQuery, error) {
+	var q dbaccess.AggregateQuery
+	if qry := query.Get("group"); qry != "" {
+		q.GroupBy = strings.Split(qry, ",")
+	}
+	for _, fn := range aggregateFuncs {
+		qry := query.Get(fn)
+		if qry == "" {
+			continue
+		}
+		for _, field := range strings.Split(qry, ",") {
+			q.Aggregations = append(q.Aggregations, dbaccess.Aggregation{Func: fn, Field: field})
+		}
+	}
+	var err error
+	if q.Filters, err = QueryFilter(query); err != nil {
+		return q, err
+	}
+	if q.Having, err = parseFilters(query.Get("having")); err != nil {
+		return q, err
+	}
+	if q.OrderBy, q.Descending, err = QuerySort(query); err != nil {
+		return q, err
+	}
+	q.Limit, err = QueryLimit(query)
+	return q, err
+}
+
+//QueryTime parse the time on the query name, the value is a date (2006-01-02)
+//or a RFC3339 time. Zero time returned if the query is not set.
+func QueryTime(query url.Values, name string) (time.Time, error) {
+	qry := query.Get(name)
+	var tm time.Time
+	if qry == "" {
+		return tm, nil
+	}
+	tm, err := time.Parse("2006-01-02", qry)
+	if err == nil {
+		return tm, nil
 	}
-	return tx
+	return time.Parse(time.RFC3339, qry)
 }
@@ -0,0 +1,460 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/riyan/apiatex/controllers/dbaccess"
+)
+
+//the status of the imported row.
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportSkipped = "skipped"
+	ImportFailed  = "failed"
+)
+
+//the OnConflict of ImportOptions.
+const (
+	ConflictUpdate = "update"
+	ConflictSkip   = "skip"
+	ConflictFail   = "fail"
+)
+
+//errUnsupportedSource is returned by newRowReader when the content type can not be
+//imported.
+var errUnsupportedSource = errors.New("import source must be text/csv or application/x-ndjson")
+
+//errRollback rollback the transaction of the chunk that has a failed row or the
+//dry run.
+var errRollback = errors.New("import rollback")
+
+//Validator is the table that check its values before it is imported.
+type Validator interface {
+	Validate() error
+}
+
+//ImportOptions is the option of the import.
+type ImportOptions struct {
+	//Mapping map the column of the source to the field of the table, the column that
+	//is not mapped is the field of the same name.
+	Mapping map[string]string
+	//ChunkSize is the rows imported in one transaction, zero import every row in one
+	//transaction. The chunk that has a failed row is rolled back.
+	ChunkSize int
+	//DryRun rollback every transaction, the report is what would be imported.
+	DryRun bool
+	//OnConflict is what to do with the row of an existing record: ConflictUpdate
+	//(default), ConflictSkip or ConflictFail.
+	OnConflict string
+}
+
+//ImportRow is the result of a row of the source, Row start from 1 after the header.
+type ImportRow struct {
+	Row    int    `json:"row"`
+	Key    string `json:"key,omitempty"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+//ImportReport is the result of the import.
+type ImportReport struct {
+	DryRun  bool `json:"dry_run"`
+	Created int  `json:"created"`
+	Updated int  `json:"updated"`
+	Skipped int  `json:"skipped"`
+	Failed  int  `json:"failed"`
+	//Ignored is the columns of the source that is not a field of the table.
+	Ignored []string    `json:"ignored,omitempty"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+//QueryImport parse the import query:
+//	map=column:field,column:field&chunk_size=100&dry_run=true&on_conflict=skip
+func QueryImport(query url.Values) (ImportOptions, error) {
+	opt := ImportOptions{OnConflict: ConflictUpdate}
+	if qry := query.Get("map"); qry != "" {
+		opt.Mapping = make(map[string]string)
+		for _, m := range strings.Split(qry, ",") {
+			col, field, ok := strings.Cut(m, ":")
+			if !ok || col == "" || field == "" {
+				return opt, fmt.Errorf("map %q must be column:field", m)
+			}
+			opt.Mapping[col] = field
+		}
+	}
+	var err error
+	if qry := query.Get("chunk_size"); qry != "" {
+		if opt.ChunkSize, err = strconv.Atoi(qry); err != nil {
+			return opt, err
+		}
+		if opt.ChunkSize < 0 {
+			return opt, fmt.Errorf("chunk_size must not be negative")
+		}
+	}
+	if qry := query.Get("dry_run"); qry != "" {
+		if opt.DryRun, err = strconv.ParseBool(qry); err != nil {
+			return opt, err
+		}
+	}
+	switch qry := query.Get("on_conflict"); qry {
+	case "":
+	case ConflictUpdate, ConflictSkip, ConflictFail:
+		opt.OnConflict = qry
+	default:
+		return opt, fmt.Errorf("on_conflict must be update, skip or fail")
+	}
+	return opt, nil
+}
+
+//rowReader read the rows of the import source, Read return io.EOF after the last row
+//and rowError if only the row can not be read.
+type rowReader interface {
+	Read() (map[string]interface{}, error)
+}
+
+//rowError is the error of one row of the source, the next row can still be read.
+type rowError struct {
+	err error
+}
+
+func (e rowError) Error() string { return e.err.Error() }
+
+//newRowReader return the rowReader of the content type.
+func newRowReader(contentType string, r io.Reader) (rowReader, error) {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch mt {
+	case "text/csv":
+		return newCSVRows(r)
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 64*1024), 1024*1024)
+		return &ndjsonRows{s: s}, nil
+	}
+	return nil, errUnsupportedSource
+}
+
+//csvRows read the CSV with a header row, the empty value is nil.
+type csvRows struct {
+	r      *csv.Reader
+	header []string
+}
+
+func newCSVRows(r io.Reader) (*csvRows, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("csv does not have a header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	//the CSV saved by the spreadsheet may start with the byte order mark.
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	return &csvRows{r: cr, header: header}, nil
+}
+
+func (c *csvRows) Read() (map[string]interface{}, error) {
+	rec, err := c.r.Read()
+	if err != nil {
+		var pe *csv.ParseError
+		if errors.As(err, &pe) && pe.Err == csv.ErrFieldCount {
+			return nil, rowError{err: fmt.Errorf("row has %d columns want %d", len(rec), len(c.header))}
+		}
+		return nil, err
+	}
+	values := make(map[string]interface{}, len(rec))
+	for i, col := range c.header {
+		if rec[i] == "" {
+			values[col] = nil
+			continue
+		}
+		values[col] = rec[i]
+	}
+	return values, nil
+}
+
+//ndjsonRows read a JSON object on every line, the blank line is skipped.
+type ndjsonRows struct {
+	s *bufio.Scanner
+}
+
+func (n *ndjsonRows) Read() (map[string]interface{}, error) {
+	for n.s.Scan() {
+		line := bytes.TrimSpace(n.s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		var values map[string]interface{}
+		if err := dec.Decode(&values); err != nil {
+			return nil, rowError{err: err}
+		}
+		if values == nil {
+			return nil, rowError{err: errors.New("row must be a JSON object")}
+		}
+		return values, nil
+	}
+	if err := n.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+//importFields return the fields of the table that can be imported, the tenant field
+//is set from the tenant of the request.
+func importFields(t dbaccess.Table) map[string]bool {
+	fields, _ := t.Fields()
+	tenant := tenantField(t)
+	m := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		if f != tenant {
+			m[f] = true
+		}
+	}
+	return m
+}
+
+//checkMapping return error if the field of the mapping can not be imported.
+func checkMapping(t dbaccess.Table, mapping map[string]string) error {
+	fields := importFields(t)
+	for col, f := range mapping {
+		if !fields[f] {
+			return fmt.Errorf("column:%s is mapped to unknown field:%s", col, f)
+		}
+	}
+	return nil
+}
+
+//importRow is the row of the source to import.
+type importRow struct {
+	ImportRow
+	values map[string]interface{}
+	key    []interface{}
+	err    error
+}
+
+//importTable import the rows to the table, the rows is read and imported ChunkSize
+//rows at a time. The error is returned if the source can not be read or the
+//transaction fail, the failed row is only reported.
+func importTable(ctx context.Context, db dbaccess.DBExecer, t dbaccess.Table, rows rowReader, opt ImportOptions) (report ImportReport, err error) {
+	report = ImportReport{DryRun: opt.DryRun, Rows: []ImportRow{}}
+	fields := importFields(t)
+	keyFields, _ := t.PrimaryKey()
+	ignored := make(map[string]bool)
+	seen := make(map[string]int)
+	defer func() {
+		for col := range ignored {
+			report.Ignored = append(report.Ignored, col)
+		}
+		sort.Strings(report.Ignored)
+	}()
+	for n, eof := 1, false; !eof; {
+		var chunk []importRow
+		for opt.ChunkSize <= 0 || len(chunk) < opt.ChunkSize {
+			values, err := rows.Read()
+			if err == io.EOF {
+				eof = true
+				break
+			}
+			row := importRow{ImportRow: ImportRow{Row: n}}
+			n++
+			var re rowError
+			if errors.As(err, &re) {
+				row.err = re.err
+			} else if err != nil {
+				return report, err
+			} else {
+				row.values = mapValues(values, opt.Mapping, fields, ignored)
+				row.key, row.Key, row.err = rowKey(t, keyFields, row.values)
+				if prev, ok := seen[row.Key]; ok && row.err == nil {
+					row.err = fmt.Errorf("duplicate of row %d", prev)
+				} else if row.err == nil {
+					seen[row.Key] = row.Row
+				}
+			}
+			chunk = append(chunk, row)
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		results, err := importChunk(ctx, db, t, chunk, opt)
+		if err != nil {
+			return report, err
+		}
+		for _, r := range results {
+			switch r.Status {
+			case ImportCreated:
+				report.Created++
+			case ImportUpdated:
+				report.Updated++
+			case ImportSkipped:
+				report.Skipped++
+			case ImportFailed:
+				report.Failed++
+			}
+			report.Rows = append(report.Rows, r)
+		}
+	}
+	return report, nil
+}
+
+//mapValues return the values of the fields, the column that is not a field is ignored.
+func mapValues(values map[string]interface{}, mapping map[string]string, fields, ignored map[string]bool) map[string]interface{} {
+	mapped := make(map[string]interface{}, len(values))
+	for col, v := range values {
+		f := col
+		if m, ok := mapping[col]; ok {
+			f = m
+		}
+		if !fields[f] {
+			ignored[col] = true
+			continue
+		}
+		mapped[f] = v
+	}
+	return mapped
+}
+
+//rowKey return the PrimaryKey values of the row and the key formatted by FormatKey.
+func rowKey(t dbaccess.Table, keyFields []string, values map[string]interface{}) ([]interface{}, string, error) {
+	key := make(map[string]interface{}, len(keyFields))
+	for _, f := range keyFields {
+		v, ok := values[f]
+		if !ok || v == nil {
+			return nil, "", fmt.Errorf("%s must be set", f)
+		}
+		key[f] = v
+	}
+	rec := t.New()
+	if err := dbaccess.SetFields(rec, key); err != nil {
+		return nil, "", err
+	}
+	return dbaccess.KeyValues(rec), dbaccess.FormatKey(rec), nil
+}
+
+//importChunk import the rows in one transaction, the transaction is rolled back if a
+//row failed or it is a dry run.
+func importChunk(ctx context.Context, db dbaccess.DBExecer, t dbaccess.Table, chunk []importRow, opt ImportOptions) ([]ImportRow, error) {
+	results := make([]ImportRow, len(chunk))
+	err := dbaccess.WithTx(ctx, db, nil, func(tx dbaccess.DBExecer) error {
+		rollback := opt.DryRun
+		for i, row := range chunk {
+			results[i] = importOne(tx, t, row, opt)
+			if results[i].Status == ImportFailed {
+				rollback = true
+			}
+		}
+		if rollback {
+			return errRollback
+		}
+		return nil
+	})
+	if err != nil && err != errRollback {
+		return nil, err
+	}
+	if err == errRollback && !opt.DryRun {
+		for i, r := range results {
+			if r.Status == ImportCreated || r.Status == ImportUpdated {
+				results[i].Status, results[i].Reason = ImportSkipped, "rolled back, another row of the chunk failed"
+			}
+		}
+	}
+	return results, nil
+}
+
+//importOne insert the row or update the existing record.
+func importOne(tx dbaccess.DBExecer, t dbaccess.Table, row importRow, opt ImportOptions) ImportRow {
+	res := row.ImportRow
+	fail := func(err error) ImportRow {
+		res.Status, res.Reason = ImportFailed, err.Error()
+		return res
+	}
+	if row.err != nil {
+		return fail(row.err)
+	}
+	rec := t.New()
+	if err := dbaccess.SetKey(rec, row.key...); err != nil {
+		return fail(err)
+	}
+	err := dbaccess.Get(tx, rec)
+	if err == sql.ErrNoRows {
+		rec = t.New()
+		if err = dbaccess.SetFields(rec, row.values); err != nil {
+			return fail(err)
+		}
+		if err = validate(rec); err != nil {
+			return fail(err)
+		}
+		if err = dbaccess.Insert(tx, rec); err != nil {
+			return fail(err)
+		}
+		res.Status = ImportCreated
+		return res
+	}
+	if err != nil {
+		return fail(err)
+	}
+	switch opt.OnConflict {
+	case ConflictSkip:
+		res.Status, res.Reason = ImportSkipped, "already exists"
+		return res
+	case ConflictFail:
+		return fail(errors.New("already exists"))
+	}
+	before := fieldValues(rec)
+	if err = dbaccess.SetFields(rec, row.values); err != nil {
+		return fail(err)
+	}
+	after := fieldValues(rec)
+	change := make(map[string]interface{})
+	for f := range row.values {
+		if !reflect.DeepEqual(before[f], after[f]) {
+			change[f] = after[f]
+		}
+	}
+	if len(change) == 0 {
+		res.Status, res.Reason = ImportSkipped, "unchanged"
+		return res
+	}
+	if err = validate(rec); err != nil {
+		return fail(err)
+	}
+	if err = dbaccess.Update(tx, rec, change); err != nil {
+		return fail(err)
+	}
+	res.Status = ImportUpdated
+	return res
+}
+
+func validate(t dbaccess.Table) error {
+	if v, ok := t.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+//fieldValues return the values of the fields of the table.
+func fieldValues(t dbaccess.Table) map[string]interface{} {
+	fields, dst := t.Fields()
+	values := make(map[string]interface{}, len(fields))
+	for i, f := range fields {
+		values[f] = reflect.Indirect(reflect.ValueOf(dst[i])).Interface()
+	}
+	return values
+}
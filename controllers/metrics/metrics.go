@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/riyan/apiatex/controllers/dbaccess"
+	"github.com/riyan/apiatex/controllers/webserver/webhandler"
+)
+
+// Metrics is the metrics of the requests, the dbaccess queries and the DB pools. It
+// is the webhandler.RequestObserver, the dbaccess.Observer and the WebHandler of the
+// metrics endpoint.
+type Metrics struct {
+	*Registry
+	requests        *Counter
+	requestDuration *Histogram
+	responseSize    *Histogram
+	queryDuration   *Histogram
+	queryErrors     *Counter
+
+	mu     sync.Mutex
+	dbs    map[string]*sql.DB
+	caches map[string]*dbaccess.StmtCache
+}
+
+// New return the Metrics with an empty Registry.
+func New() *Metrics {
+	r := NewRegistry()
+	m := &Metrics{
+		Registry: r,
+		requests: r.NewCounter("http_requests_total",
+			"Total number of HTTP requests.", "route", "method", "status"),
+		requestDuration: r.NewHistogram("http_request_duration_seconds",
+			"Duration of the HTTP requests in seconds.", nil, "route", "method", "status"),
+		responseSize: r.NewHistogram("http_response_size_bytes",
+			"Size of the HTTP response bodies in bytes.",
+			[]float64{100, 1000, 10000, 100000, 1000000, 10000000}, "route", "method", "status"),
+		queryDuration: r.NewHistogram("dbaccess_query_duration_seconds",
+			"Duration of the dbaccess statements in seconds.", nil, "table", "op"),
+		queryErrors: r.NewCounter("dbaccess_query_errors_total",
+			"Total number of the dbaccess statements that failed.", "table", "op"),
+		dbs:    map[string]*sql.DB{},
+		caches: map[string]*dbaccess.StmtCache{},
+	}
+	m.registerPool()
+	m.registerStmtCache()
+	return m
+}
+
+// RegisterDB add the pool stats of the db with the name as the db label.
+func (m *Metrics) RegisterDB(name string, db *sql.DB) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dbs[name] = db
+}
+
+// RegisterStmtCache add the stats of the statement cache with the name as the db label.
+func (m *Metrics) RegisterStmtCache(name string, c *dbaccess.StmtCache) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.caches[name] = c
+}
+
+// ObserveRequest implement webhandler.RequestObserver.
+func (m *Metrics) ObserveRequest(r *http.Request, info webhandler.RequestInfo) {
+	route := info.Route
+	if route == "" {
+		route = "unknown"
+	}
+	status := strconv.Itoa(info.Status)
+	m.requests.Inc(route, info.Method, status)
+	m.requestDuration.Observe((info.HandleDur + info.ResponseDur).Seconds(), route, info.Method, status)
+	m.responseSize.Observe(float64(info.Bytes), route, info.Method, status)
+}
+
+// ObserveQuery implement dbaccess.Observer.
+func (m *Metrics) ObserveQuery(e dbaccess.QueryEvent) {
+	op, table := e.Op, e.Table
+	if op == "" {
+		op = "raw"
+	}
+	m.queryDuration.Observe(e.Duration.Seconds(), table, op)
+	if e.Err != nil {
+		m.queryErrors.Inc(table, op)
+	}
+}
+
+// Handle implement webhandler.WebHandler, it respond with the metrics in the
+// Prometheus text format.
+func (m *Metrics) Handle(w http.ResponseWriter, r *http.Request) webhandler.Response {
+	var res webhandler.Response
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		res.Error(nil, http.StatusMethodNotAllowed)
+		return res
+	}
+	m.ServeHTTP(w, r)
+	res.Handled = true
+	return res
+}
+
+// registerPool register the gauges of the sql.DBStats of the registered dbs.
+func (m *Metrics) registerPool() {
+	pool := func(name, help, kind string, value func(s sql.DBStats) float64) {
+		m.NewGaugeFunc(name, help, kind, []string{"db"}, func() []Sample {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			samples := make([]Sample, 0, len(m.dbs))
+			for _, db := range sortedKeys(m.dbs) {
+				samples = append(samples, Sample{Values: []string{db}, Value: value(m.dbs[db].Stats())})
+			}
+			return samples
+		})
+	}
+	pool("dbaccess_pool_max_open_connections", "Maximum number of open connections to the database.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	pool("dbaccess_pool_open_connections", "Number of established connections.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	pool("dbaccess_pool_in_use_connections", "Number of connections currently in use.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	pool("dbaccess_pool_idle_connections", "Number of idle connections.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	pool("dbaccess_pool_wait_count_total", "Total number of connections waited for.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	pool("dbaccess_pool_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", "counter",
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	pool("dbaccess_pool_max_idle_closed_total", "Total number of connections closed due to SetMaxIdleConns.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	pool("dbaccess_pool_max_idle_time_closed_total", "Total number of connections closed due to SetConnMaxIdleTime.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) })
+	pool("dbaccess_pool_max_lifetime_closed_total", "Total number of connections closed due to SetConnMaxLifetime.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+}
+
+func (m *Metrics) registerStmtCache() {
+	cache := func(name, help, kind string, value func(s dbaccess.StmtStats) float64) {
+		m.NewGaugeFunc(name, help, kind, []string{"db"}, func() []Sample {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			samples := make([]Sample, 0, len(m.caches))
+			for _, db := range sortedKeys(m.caches) {
+				samples = append(samples, Sample{Values: []string{db}, Value: value(m.caches[db].Stats())})
+			}
+			return samples
+		})
+	}
+	cache("dbaccess_stmt_cache_hits_total", "Total number of queries that reuse a cached statement.", "counter",
+		func(s dbaccess.StmtStats) float64 { return float64(s.Hits) })
+	cache("dbaccess_stmt_cache_tx_hits_total", "Total number of queries inside a transaction that reuse a cached statement.", "counter",
+		func(s dbaccess.StmtStats) float64 { return float64(s.TxHits) })
+	cache("dbaccess_stmt_cache_misses_total", "Total number of queries that prepare a new statement.", "counter",
+		func(s dbaccess.StmtStats) float64 { return float64(s.Misses) })
+	cache("dbaccess_stmt_cache_evictions_total", "Total number of cached statements closed because the cache is full.", "counter",
+		func(s dbaccess.StmtStats) float64 { return float64(s.Evictions) })
+	cache("dbaccess_stmt_cache_size", "Number of cached statements.", "gauge",
+		func(s dbaccess.StmtStats) float64 { return float64(s.Size) })
+}
+
+// compile time check.
+var (
+	_ webhandler.RequestObserver = (*Metrics)(nil)
+	_ dbaccess.Observer          = (*Metrics)(nil)
+	_ webhandler.WebHandler      = (*Metrics)(nil)
+)
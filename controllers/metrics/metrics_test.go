@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/riyan/apiatex/controllers/dbaccess"
+	"github.com/riyan/apiatex/controllers/webserver/webhandler"
+)
+
+// scrape return the samples of the metrics endpoint by the name with the labels.
+func scrape(t *testing.T, h http.Handler) map[string]string {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status code:%d body:%s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("got content type:%q", ct)
+	}
+	samples := map[string]string{}
+	s := bufio.NewScanner(w.Body)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			t.Fatalf("invalid line:%q", line)
+		}
+		samples[line[:i]] = line[i+1:]
+	}
+	return samples
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test counter.", "name")
+	c.Inc(`a"b`)
+	c.Add(2, "c\nd")
+	h := r.NewHistogram("test_seconds", "Test histogram.", []float64{1, 0.5})
+	h.Observe(0.2)
+	h.Observe(0.7)
+	h.Observe(3)
+	r.NewGaugeFunc("test_gauge", "Test gauge.", "gauge", nil, func() []Sample {
+		return []Sample{{Value: 42}}
+	})
+	want := map[string]string{
+		`test_total{name="a\"b"}`:        "1",
+		`test_total{name="c\nd"}`:        "2",
+		`test_seconds_bucket{le="0.5"}`:  "1",
+		`test_seconds_bucket{le="1"}`:    "2",
+		`test_seconds_bucket{le="+Inf"}`: "3",
+		`test_seconds_sum`:               "3.9",
+		`test_seconds_count`:             "3",
+		`test_gauge`:                     "42",
+	}
+	got := scrape(t, r)
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("got %s:%q want:%q", k, got[k], v)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got samples:%v want:%v", got, want)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	db, err := sql.Open("mysql", "root@/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(3)
+	m.RegisterDB("primary", db)
+	cdb, err := sql.Open("mysql", "root@/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cdb.Close()
+	cache := dbaccess.NewStmtCache(cdb, 10)
+	defer cache.Close()
+	var one int
+	cache.QueryRow("SELECT 1").Scan(&one)
+	m.RegisterStmtCache("primary", cache)
+	m.ObserveRequest(httptest.NewRequest("GET", "/api", nil), webhandler.RequestInfo{
+		Route: "/api/", Method: "GET", Status: 200, Bytes: 10, HandleDur: 20 * time.Millisecond,
+	})
+	m.ObserveRequest(httptest.NewRequest("GET", "/api", nil), webhandler.RequestInfo{
+		Route: "/api/", Method: "GET", Status: 200, Bytes: 10, HandleDur: 2 * time.Second,
+	})
+	m.ObserveQuery(dbaccess.QueryEvent{Op: "fetch", Table: "employed", Duration: time.Millisecond})
+	m.ObserveQuery(dbaccess.QueryEvent{Op: "insert", Table: "employed", Err: errors.New("duplicate")})
+	got := scrape(t, webhandler.New(m))
+	want := map[string]string{
+		`http_requests_total{route="/api/",method="GET",status="200"}`:                             "2",
+		`http_request_duration_seconds_bucket{route="/api/",method="GET",status="200",le="0.025"}`: "1",
+		`http_request_duration_seconds_count{route="/api/",method="GET",status="200"}`:             "2",
+		`dbaccess_query_duration_seconds_count{table="employed",op="fetch"}`:                       "1",
+		`dbaccess_query_errors_total{table="employed",op="insert"}`:                                "1",
+		`dbaccess_pool_max_open_connections{db="primary"}`:                                         "3",
+		`dbaccess_pool_open_connections{db="primary"}`:                                             "0",
+		`dbaccess_stmt_cache_misses_total{db="primary"}`:                                           "1",
+		`dbaccess_stmt_cache_size{db="primary"}`:                                                   "1",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("got %s:%q want:%q", k, got[k], v)
+		}
+	}
+	w := httptest.NewRecorder()
+	webhandler.New(m).ServeHTTP(w, httptest.NewRequest("POST", "/metrics", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status code:%d want:%d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
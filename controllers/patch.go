@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/riyan/apiatex/controllers/dbaccess"
+)
+
+//the content type of the PATCH body.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+//patchError is the error of the patch document, code is the status code of the
+//response.
+type patchError struct {
+	code int
+	err  error
+}
+
+func (e patchError) Error() string { return e.err.Error() }
+
+func patchErrorf(code int, format string, args ...interface{}) error {
+	return patchError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+//patchOp is an operation of the JSON Patch (RFC 6902).
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+//patchTable apply the patch on the record read by the caller and return the change to
+//update. The document of the patch is the fields of the table without the tenant
+//field, the PrimaryKey can not be changed.
+func patchTable(t dbaccess.Table, contentType string, body []byte) (map[string]interface{}, error) {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	before := fieldValues(t)
+	doc := make(map[string]interface{}, len(before))
+	for f := range importFields(t) {
+		doc[f] = before[f]
+	}
+	var err error
+	switch mt {
+	case MergePatchType:
+		var patch interface{}
+		if err = decodeJSON(body, &patch); err != nil {
+			return nil, patchError{code: http.StatusBadRequest, err: err}
+		}
+		if _, ok := patch.(map[string]interface{}); !ok {
+			return nil, patchErrorf(http.StatusUnprocessableEntity, "merge patch must be an object")
+		}
+		doc = mergePatch(doc, patch).(map[string]interface{})
+	case JSONPatchType:
+		var ops []patchOp
+		if err = json.Unmarshal(body, &ops); err != nil {
+			return nil, patchError{code: http.StatusBadRequest, err: err}
+		}
+		if err = jsonPatch(doc, ops); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, patchErrorf(http.StatusUnsupportedMediaType, "patch must be %s or %s", MergePatchType, JSONPatchType)
+	}
+	changed := make(map[string]interface{})
+	for f, v := range doc {
+		if _, ok := before[f]; !ok || f == tenantField(t) {
+			return nil, patchErrorf(http.StatusUnprocessableEntity, "table:%s does not have field:%s", t.Name(), f)
+		}
+		if !reflect.DeepEqual(v, before[f]) {
+			changed[f] = v
+		}
+	}
+	//the removed field is set to its zero value.
+	for f := range importFields(t) {
+		if _, ok := doc[f]; !ok {
+			changed[f] = nil
+		}
+	}
+	keyFields, _ := t.PrimaryKey()
+	for _, f := range keyFields {
+		if _, ok := changed[f]; ok {
+			return nil, patchErrorf(http.StatusUnprocessableEntity, "%s can not be changed", f)
+		}
+	}
+	if err = dbaccess.SetFields(t, changed); err != nil {
+		return nil, patchError{code: http.StatusUnprocessableEntity, err: err}
+	}
+	after := fieldValues(t)
+	change := make(map[string]interface{}, len(changed))
+	for f := range changed {
+		if !reflect.DeepEqual(after[f], before[f]) {
+			change[f] = after[f]
+		}
+	}
+	return change, nil
+}
+
+//replaceChange return the record that replace the record with the data and its
+//change, the field that is not on the data is set to its zero value. The replace
+//record has the key of the record. The other key of the data is kept on the change so
+//Update reject it.
+func replaceChange(t dbaccess.Table, data map[string]interface{}) (dbaccess.Table, map[string]interface{}, error) {
+	fields := importFields(t)
+	values := make(map[string]interface{}, len(data))
+	for f, v := range data {
+		if fields[f] {
+			values[f] = v
+		}
+	}
+	keyFields, _ := t.PrimaryKey()
+	current := fieldValues(t)
+	for _, f := range keyFields {
+		values[f] = current[f]
+	}
+	replace := t.New()
+	if err := dbaccess.SetFields(replace, values); err != nil {
+		return nil, nil, err
+	}
+	change := fieldValues(replace)
+	delete(change, tenantField(t))
+	keys := make(map[string]bool, len(keyFields))
+	for _, f := range keyFields {
+		delete(change, f)
+		keys[f] = true
+	}
+	for f, v := range data {
+		if !keys[f] && !fields[f] {
+			change[f] = v
+		}
+	}
+	return replace, change, nil
+}
+
+//tenantField return the tenant field of the table, empty if it is not TenantScoped.
+func tenantField(t dbaccess.Table) string {
+	if ts, ok := t.(dbaccess.TenantScoped); ok {
+		return ts.TenantField()
+	}
+	return ""
+}
+
+//decodeJSON decode the JSON with the number as json.Number.
+func decodeJSON(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+//mergePatch apply the JSON Merge Patch (RFC 7396) on the target.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+//jsonPatch apply the test, replace and remove operations of the JSON Patch
+//(RFC 6902) on the fields of the doc. The failed test is a conflict.
+func jsonPatch(doc map[string]interface{}, ops []patchOp) error {
+	for i, op := range ops {
+		field, err := pointerField(op.Path)
+		if err != nil {
+			return patchErrorf(http.StatusUnprocessableEntity, "operation %d: %v", i, err)
+		}
+		current, exist := doc[field]
+		if !exist {
+			return patchErrorf(http.StatusUnprocessableEntity, "operation %d: path %s does not exist", i, op.Path)
+		}
+		switch op.Op {
+		case "test":
+			want, err := json.Marshal(current)
+			if err != nil {
+				return err
+			}
+			if !jsonEqual(want, op.Value) {
+				return patchErrorf(http.StatusConflict, "operation %d: test %s failed", i, op.Path)
+			}
+		case "replace":
+			if len(op.Value) == 0 {
+				return patchErrorf(http.StatusUnprocessableEntity, "operation %d: replace need a value", i)
+			}
+			var v interface{}
+			if err = decodeJSON(op.Value, &v); err != nil {
+				return patchError{code: http.StatusBadRequest, err: err}
+			}
+			doc[field] = v
+		case "remove":
+			delete(doc, field)
+		default:
+			return patchErrorf(http.StatusUnprocessableEntity, "operation %d: op %q is not supported", i, op.Op)
+		}
+	}
+	return nil
+}
+
+//pointerField return the field of the JSON Pointer /field.
+func pointerField(path string) (string, error) {
+	if !strings.HasPrefix(path, "/") || strings.Contains(path[1:], "/") {
+		return "", fmt.Errorf("path %q must be /field", path)
+	}
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(path[1:]), nil
+}
+
+//jsonEqual report whether the JSON a and b is the same value.
+func jsonEqual(a, b []byte) bool {
+	var va, vb interface{}
+	if decodeJSON(a, &va) != nil || decodeJSON(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(normalizeJSON(va), normalizeJSON(vb))
+}
+
+//normalizeJSON replace the json.Number with its float64 value, so 1 and 1.0 is equal.
+func normalizeJSON(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if f, err := x.Float64(); err == nil {
+			return f
+		}
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = normalizeJSON(e)
+		}
+	case []interface{}:
+		for i, e := range x {
+			x[i] = normalizeJSON(e)
+		}
+	}
+	return v
+}
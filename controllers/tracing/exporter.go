@@ -0,0 +1,305 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// InMemoryExporter keep the spans in memory, it is used by the tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// ExportSpans implement Exporter.
+func (e *InMemoryExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// Shutdown implement Exporter.
+func (e *InMemoryExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// Spans return the exported spans in the order they end.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset remove the exported spans.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// Batcher is the Exporter that queue the spans and send them in batch to the
+// exporter on the background, the span is dropped when the queue is full.
+type Batcher struct {
+	exporter Exporter
+	size     int
+	queue    chan SpanData
+	flush    chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+	mu       sync.Mutex
+	err      error
+}
+
+// NewBatcher return the Batcher that send at most size spans at once, the spans is
+// sent when the batch is full or every interval.
+func NewBatcher(exporter Exporter, size int, interval time.Duration) *Batcher {
+	if size <= 0 {
+		size = 512
+	}
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	b := &Batcher{
+		exporter: exporter,
+		size:     size,
+		queue:    make(chan SpanData, size*4),
+		flush:    make(chan struct{}),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go b.run(interval)
+	return b
+}
+
+// ExportSpans implement Exporter, the spans is queued. It return the error of the last
+// failed batch.
+func (b *Batcher) ExportSpans(ctx context.Context, spans []SpanData) error {
+	select {
+	case <-b.done:
+		return errors.New("tracing: batcher is shut down")
+	default:
+	}
+	for _, s := range spans {
+		select {
+		case b.queue <- s:
+		default:
+			return errors.New("tracing: span queue is full")
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.err
+	b.err = nil
+	return err
+}
+
+// Shutdown send the queued spans and shutdown the exporter.
+func (b *Batcher) Shutdown(ctx context.Context) error {
+	b.once.Do(func() { close(b.flush) })
+	select {
+	case <-b.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return b.exporter.Shutdown(ctx)
+}
+
+func (b *Batcher) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, b.size)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := b.exporter.ExportSpans(context.Background(), batch); err != nil {
+			b.mu.Lock()
+			b.err = err
+			b.mu.Unlock()
+		}
+		batch = make([]SpanData, 0, b.size)
+	}
+	for {
+		select {
+		case s := <-b.queue:
+			if batch = append(batch, s); len(batch) >= b.size {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case <-b.flush:
+			close(b.done)
+			for len(b.queue) > 0 {
+				if batch = append(batch, <-b.queue); len(batch) >= b.size {
+					send()
+				}
+			}
+			send()
+			close(b.stopped)
+			return
+		}
+	}
+}
+
+// OTLPExporter send the spans to the OTLP/HTTP endpoint of the collector with the
+// JSON encoding, e.g. http://localhost:4318/v1/traces.
+type OTLPExporter struct {
+	// Endpoint is the URL of the traces endpoint.
+	Endpoint string
+	// ServiceName is the service.name of the resource.
+	ServiceName string
+	// Headers is added to the export request, e.g. the authorization of the collector.
+	Headers map[string]string
+	// Client is the http.Client of the export request, http.DefaultClient if it is nil.
+	Client *http.Client
+}
+
+// ExportSpans implement Exporter.
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("tracing: export status %s: %s", res.Status, msg)
+	}
+	return nil
+}
+
+// Shutdown implement Exporter.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// otlpValue is the AnyValue of OTLP.
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpStatus is the status of the span, the code is 0 (unset) or 2 (error).
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func (e *OTLPExporter) request(spans []SpanData) otlpRequest {
+	var rs otlpResourceSpans
+	rs.Resource.Attributes = otlpAttributes([]Attribute{String("service.name", e.ServiceName)})
+	var ss otlpScopeSpans
+	ss.Scope.Name = "github.com/riyan/apiatex/controllers/tracing"
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           s.SpanContext.TraceID.String(),
+			SpanID:            s.SpanContext.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+		}
+		if s.Parent.IsValid() {
+			o.ParentSpanID = s.Parent.SpanID.String()
+		}
+		if s.Err != "" {
+			o.Status = otlpStatus{Code: 2, Message: s.Err}
+		}
+		ss.Spans = append(ss.Spans, o)
+	}
+	rs.ScopeSpans = []otlpScopeSpans{ss}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{rs}}
+}
+
+func otlpAttributes(attrs []Attribute) []otlpAttribute {
+	res := make([]otlpAttribute, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpValue
+		switch x := a.Value.(type) {
+		case string:
+			v.StringValue = &x
+		case bool:
+			v.BoolValue = &x
+		case int64:
+			s := strconv.FormatInt(x, 10)
+			v.IntValue = &s
+		case int:
+			s := strconv.Itoa(x)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &x
+		default:
+			s := fmt.Sprint(x)
+			v.StringValue = &s
+		}
+		res = append(res, otlpAttribute{Key: a.Key, Value: v})
+	}
+	return res
+}
@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatcher(t *testing.T) {
+	exp := &InMemoryExporter{}
+	b := NewBatcher(exp, 2, time.Hour)
+	tracer := NewTracer(b)
+	for i := 0; i < 3; i++ {
+		_, s := tracer.Start(context.Background(), "span")
+		s.End()
+	}
+	//the full batch is sent without waiting the interval.
+	deadline := time.Now().Add(time.Second)
+	for len(exp.Spans()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := len(exp.Spans()); got != 2 {
+		t.Errorf("got %d spans before shutdown want:2", got)
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(exp.Spans()); got != 3 {
+		t.Errorf("got %d spans after shutdown want:3", got)
+	}
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Errorf("second shutdown got err:%v", err)
+	}
+	if err := b.ExportSpans(context.Background(), []SpanData{{}}); err == nil {
+		t.Error("export after shutdown must fail")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var mu sync.Mutex
+	var body []byte
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+		if r.URL.Path != "/v1/traces" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	exp := &OTLPExporter{Endpoint: srv.URL + "/v1/traces", ServiceName: "apiatex",
+		Headers: map[string]string{"Authorization": "Bearer token"}}
+	tracer := NewTracer(exp)
+	tracer.OnError = func(err error) { t.Error(err) }
+	ctx, root := tracer.Start(context.Background(), "GET /employed", WithKind(SpanKindServer))
+	_, child := tracer.Start(ctx, "dbaccess.fetch", WithAttributes(String("db.sql.table", "employed"),
+		Int("db.rows_affected", 2), Bool("ok", true)))
+	child.SetError(errors.New("failed"))
+	child.End()
+	mu.Lock()
+	got, gotHeader := body, header
+	mu.Unlock()
+	if gotHeader.Get("Content-Type") != "application/json" || gotHeader.Get("Authorization") != "Bearer token" {
+		t.Errorf("got header:%v", gotHeader)
+	}
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string
+					Value map[string]interface{}
+				}
+			}
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string
+					SpanID       string
+					ParentSpanID string
+					Name         string
+					Kind         int
+					Attributes   []struct {
+						Key   string
+						Value map[string]interface{}
+					}
+					Status struct {
+						Code    int
+						Message string
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(got, &req); err != nil {
+		t.Fatalf("%v body:%s", err, got)
+	}
+	rs := req.ResourceSpans[0]
+	if a := rs.Resource.Attributes[0]; a.Key != "service.name" || a.Value["stringValue"] != "apiatex" {
+		t.Errorf("got resource:%+v", rs.Resource)
+	}
+	s := rs.ScopeSpans[0].Spans[0]
+	if s.TraceID != root.SpanContext().TraceID.String() || s.ParentSpanID != root.SpanContext().SpanID.String() ||
+		s.SpanID != child.SpanContext().SpanID.String() || s.Name != "dbaccess.fetch" || s.Kind != 1 {
+		t.Errorf("got span:%+v", s)
+	}
+	if s.Status.Code != 2 || s.Status.Message != "failed" {
+		t.Errorf("got status:%+v", s.Status)
+	}
+	if len(s.Attributes) != 3 || s.Attributes[1].Value["intValue"] != "2" || s.Attributes[2].Value["boolValue"] != true {
+		t.Errorf("got attributes:%+v", s.Attributes)
+	}
+
+	exp.Endpoint = srv.URL + "/not-found"
+	if err := exp.ExportSpans(context.Background(), []SpanData{{Name: "span"}}); err == nil {
+		t.Error("export to the wrong endpoint must fail")
+	}
+}
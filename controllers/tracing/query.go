@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/riyan/apiatex/controllers/dbaccess"
+)
+
+// QueryObserver return the dbaccess.Observer that record every statement as the child
+// span of the span of ctx. The span has the statement with its placeholders, the
+// arguments is not recorded.
+func QueryObserver(ctx context.Context) dbaccess.Observer {
+	return dbaccess.ObserverFunc(func(e dbaccess.QueryEvent) {
+		parent := SpanFromContext(ctx)
+		if parent == nil {
+			return
+		}
+		name := "dbaccess." + e.Op
+		if e.Op == "" {
+			name = "dbaccess.query"
+		}
+		end := time.Now()
+		attrs := []Attribute{
+			String("db.system", "mysql"),
+			String("db.statement", e.Query),
+		}
+		if e.Op != "" {
+			attrs = append(attrs, String("db.operation", e.Op))
+		}
+		if e.Table != "" {
+			attrs = append(attrs, String("db.sql.table", e.Table))
+		}
+		if e.RowsAffected >= 0 {
+			attrs = append(attrs, Int("db.rows_affected", e.RowsAffected))
+		}
+		_, s := parent.tracer.Start(ctx, name, WithKind(SpanKindClient), WithStart(end.Add(-e.Duration)),
+			WithAttributes(attrs...))
+		s.SetError(e.Err)
+		s.EndAt(end)
+	})
+}
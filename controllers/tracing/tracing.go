@@ -0,0 +1,344 @@
+// Package tracing record the spans of the requests and the queries and propagate the
+// trace with the W3C traceparent header. The spans is sent to an Exporter, e.g. the
+// OTLPExporter or the InMemoryExporter of the tests.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader is the W3C trace context header.
+const TraceparentHeader = "Traceparent"
+
+// ErrInvalidTraceparent is returned by ParseTraceparent when the header is not valid.
+var ErrInvalidTraceparent = errors.New("tracing: invalid traceparent")
+
+// TraceID is the ID of a trace.
+type TraceID [16]byte
+
+// IsValid report whether the ID is not all zero.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID is the ID of a span.
+type SpanID [8]byte
+
+// IsValid report whether the ID is not all zero.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is the part of a span that is propagated to the children and the
+// remote services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled report whether the trace is recorded.
+	Sampled bool
+	// Remote report whether the SpanContext is extracted from a request.
+	Remote bool
+}
+
+// IsValid report whether the trace and span ID is valid.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent return the traceparent header value of the SpanContext.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parse the traceparent header value, the SpanContext is Remote.
+func ParseTraceparent(v string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(make([]byte, 1), []byte(parts[0])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, ErrInvalidTraceparent
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if !sc.IsValid() || strings.ToLower(v) != v {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	sc.Sampled = flags[0]&1 == 1
+	sc.Remote = true
+	return sc, nil
+}
+
+// Extract return the ctx with the remote SpanContext of the traceparent header, ctx
+// is returned as is if the header is missing or invalid.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, err := ParseTraceparent(h.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteContextKey, sc)
+}
+
+// Inject set the traceparent header of the span of ctx.
+func Inject(ctx context.Context, h http.Header) {
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		h.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+type contextKey int
+
+const (
+	spanContextKey contextKey = iota
+	remoteContextKey
+)
+
+// ContextWithSpan return the ctx with the span, the span is the parent of the span
+// started with ctx.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey, s)
+}
+
+// SpanFromContext return the span of ctx, nil if ctx does not have a span.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanContextKey).(*Span)
+	return s
+}
+
+// SpanContextFromContext return the SpanContext of the span of ctx or the remote
+// SpanContext of Extract.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.sc
+	}
+	sc, _ := ctx.Value(remoteContextKey).(SpanContext)
+	return sc
+}
+
+// SpanKind is the role of the span on the trace, the value is the OTLP span kind.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// Attribute is a key value of the span, the value is a string, bool, int64 or float64.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String return the string Attribute.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int return the int64 Attribute.
+func Int(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Bool return the bool Attribute.
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData is the recorded span sent to the Exporter.
+type SpanData struct {
+	Name        string
+	Kind        SpanKind
+	SpanContext SpanContext
+	// Parent is the SpanContext of the parent span, invalid for the root span.
+	Parent     SpanContext
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	// Err is the error message of the failed span.
+	Err string
+}
+
+// Span is an operation of the trace. The methods of the nil Span does nothing.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// SpanContext return the SpanContext of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttributes add the attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil || !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// SetError mark the span as failed with the error, nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil || !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Err = err.Error()
+}
+
+// End record the end of the span and send it to the Exporter, End after the first
+// one does nothing.
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt is like End with the end time.
+func (s *Span) EndAt(t time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended || !s.sc.Sampled {
+		s.ended = true
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = t
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.export(data)
+}
+
+// Exporter send the ended spans to the tracing backend.
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	// Shutdown flush the spans and release the resources of the Exporter.
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer start the spans and send them to the Exporter when they end.
+type Tracer struct {
+	exporter Exporter
+	// OnError is called when the Exporter failed, the error is ignored if it is nil.
+	OnError func(err error)
+}
+
+// NewTracer return the Tracer that send the spans to the exporter. The exporter is
+// called on every Span.End, use a Batcher for the exporter that send the spans over
+// the network.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// StartOption set the option of Start.
+type StartOption func(s *SpanData)
+
+// WithKind set the SpanKind of the span, default SpanKindInternal.
+func WithKind(kind SpanKind) StartOption {
+	return func(s *SpanData) { s.Kind = kind }
+}
+
+// WithStart set the start time of the span, default now.
+func WithStart(t time.Time) StartOption {
+	return func(s *SpanData) { s.Start = t }
+}
+
+// WithAttributes set the attributes of the span.
+func WithAttributes(attrs ...Attribute) StartOption {
+	return func(s *SpanData) { s.Attributes = append(s.Attributes, attrs...) }
+}
+
+// Start start the span that is the child of the span of ctx (or the remote span of
+// Extract) and return the ctx with the span. The span of the new trace is sampled,
+// the child follow the parent. The nil Tracer return ctx and the nil Span.
+func (t *Tracer) Start(ctx context.Context, name string, opts ...StartOption) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled}
+	if !parent.IsValid() {
+		parent = SpanContext{}
+		sc.TraceID = newTraceID()
+		sc.Sampled = true
+	}
+	s := &Span{tracer: t, sc: sc}
+	s.data = SpanData{Name: name, Kind: SpanKindInternal, SpanContext: sc, Parent: parent, Start: time.Now()}
+	for _, opt := range opts {
+		opt(&s.data)
+	}
+	return ContextWithSpan(ctx, s), s
+}
+
+// Shutdown shutdown the Exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	return t.exporter.Shutdown(ctx)
+}
+
+func (t *Tracer) export(s SpanData) {
+	if err := t.exporter.ExportSpans(context.Background(), []SpanData{s}); err != nil && t.OnError != nil {
+		t.OnError(err)
+	}
+}
+
+func newTraceID() (id TraceID) {
+	for !id.IsValid() {
+		if _, err := rand.Read(id[:]); err != nil {
+			panic(fmt.Sprintf("tracing: %v", err))
+		}
+	}
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	for !id.IsValid() {
+		if _, err := rand.Read(id[:]); err != nil {
+			panic(fmt.Sprintf("tracing: %v", err))
+		}
+	}
+	return id
+}
@@ -0,0 +1,135 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/riyan/apiatex/controllers/dbaccess"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Sampled || !sc.Remote || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("got span context:%+v", sc)
+	}
+	if got := sc.Traceparent(); got != tp {
+		t.Errorf("got traceparent:%q want:%q", got, tp)
+	}
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	}
+	for _, v := range invalid {
+		if _, err := ParseTraceparent(v); err != ErrInvalidTraceparent {
+			t.Errorf("traceparent:%q got err:%v want:%v", v, err, ErrInvalidTraceparent)
+		}
+	}
+	if _, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Errorf("future version got err:%v", err)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	exp := &InMemoryExporter{}
+	tracer := NewTracer(exp)
+	h := http.Header{}
+	h.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := tracer.Start(Extract(context.Background(), h), "root", WithKind(SpanKindServer))
+	_, child := tracer.Start(ctx, "child", WithAttributes(String("k", "v")))
+	child.SetError(errors.New("failed"))
+	child.End()
+	child.End()
+	root.End()
+	spans := exp.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans want:2", len(spans))
+	}
+	c, r := spans[0], spans[1]
+	if r.Name != "root" || r.Kind != SpanKindServer || r.Parent.SpanID.String() != "00f067aa0ba902b7" ||
+		r.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("got root:%+v", r)
+	}
+	if c.Parent.SpanID != r.SpanContext.SpanID || c.SpanContext.TraceID != r.SpanContext.TraceID ||
+		c.Err != "failed" || len(c.Attributes) != 1 || c.End.Before(c.Start) {
+		t.Errorf("got child:%+v", c)
+	}
+	out := http.Header{}
+	Inject(ctx, out)
+	if out.Get(TraceparentHeader) != root.SpanContext().Traceparent() {
+		t.Errorf("got injected traceparent:%q", out.Get(TraceparentHeader))
+	}
+
+	//the trace that is not sampled by the caller is not recorded.
+	exp.Reset()
+	h.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx, root = tracer.Start(Extract(context.Background(), h), "root")
+	_, child = tracer.Start(ctx, "child")
+	child.End()
+	root.End()
+	if len(exp.Spans()) != 0 {
+		t.Errorf("got spans:%+v of the trace that is not sampled", exp.Spans())
+	}
+
+	//the nil tracer does nothing.
+	var nilTracer *Tracer
+	ctx, s := nilTracer.Start(context.Background(), "nil")
+	s.SetAttributes(String("k", "v"))
+	s.End()
+	if SpanFromContext(ctx) != nil {
+		t.Error("nil tracer add a span to the context")
+	}
+}
+
+func TestQueryObserver(t *testing.T) {
+	exp := &InMemoryExporter{}
+	ctx, root := NewTracer(exp).Start(context.Background(), "root")
+	o := QueryObserver(ctx)
+	o.ObserveQuery(dbaccess.QueryEvent{Op: "fetch", Table: "employed", Query: "SELECT * FROM employed WHERE id = ?",
+		Args: []interface{}{"secret"}, Duration: 10 * time.Millisecond, RowsAffected: -1})
+	o.ObserveQuery(dbaccess.QueryEvent{Query: "DELETE FROM employed", RowsAffected: 2, Err: errors.New("failed")})
+	root.End()
+	spans := exp.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans want:3", len(spans))
+	}
+	fetch := spans[0]
+	want := map[string]interface{}{
+		"db.system":    "mysql",
+		"db.statement": "SELECT * FROM employed WHERE id = ?",
+		"db.operation": "fetch",
+		"db.sql.table": "employed",
+	}
+	got := map[string]interface{}{}
+	for _, a := range fetch.Attributes {
+		got[a.Key] = a.Value
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("got attribute %s:%v want:%v", k, got[k], v)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got attributes:%v want:%v", got, want)
+	}
+	if fetch.Name != "dbaccess.fetch" || fetch.Kind != SpanKindClient ||
+		fetch.Parent.SpanID != root.SpanContext().SpanID || fetch.End.Sub(fetch.Start) != 10*time.Millisecond {
+		t.Errorf("got span:%+v", fetch)
+	}
+	if raw := spans[1]; raw.Name != "dbaccess.query" || raw.Err != "failed" {
+		t.Errorf("got span:%+v", raw)
+	}
+}
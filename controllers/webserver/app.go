@@ -0,0 +1,148 @@
+package webserver
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/riyan/apiatex/controllers/dbaccess"
+	"github.com/riyan/apiatex/controllers/tracing"
+	"github.com/riyan/apiatex/controllers/webserver/webhandler"
+)
+
+//DefaultShutdownTimeout is the time Shutdown wait the in-flight requests when the
+//ShutdownTimeout of the Config is zero.
+const DefaultShutdownTimeout = 30 * time.Second
+
+//Config is the configuration of the App.
+type Config struct {
+	//Addr is the address the server listen on, e.g. :8082.
+	Addr string
+	//TLSCert and TLSKey is the TLS certificate and key files, the App serve https if
+	//they are set.
+	TLSCert string
+	TLSKey  string
+	//Debug log the error of the requests with more detailed information.
+	Debug bool
+	//ReadTimeout and WriteTimeout is the timeout of the http.Server, zero mean no timeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	//ShutdownTimeout is the maximum time Shutdown wait the in-flight requests.
+	ShutdownTimeout time.Duration
+	//LogSample and RedactParams is the sampling and the redacted query parameters of
+	//the access log, see webhandler.Env.
+	LogSample    int
+	RedactParams []string
+	//StmtCacheSize is the prepared statements cached for the primary database, zero
+	//disable the cache.
+	StmtCacheSize int
+}
+
+//App is an API with its own database, logger, config and routes. Several App
+//can run on one process with different databases.
+type App struct {
+	config Config
+	env    webhandler.Env
+	mux    *mux
+	server *Server
+}
+
+//NewApp return the App of the db, the logger is slog.Default.
+func NewApp(config Config, db *sql.DB) *App {
+	return newApp(config, webhandler.Env{DB: db}, db)
+}
+
+//NewAppWithRouter return the App that send the queries of every request to a
+//session of the router.
+func NewAppWithRouter(config Config, router *dbaccess.Router) *App {
+	return newApp(config, webhandler.Env{Router: router}, router.Primary())
+}
+
+func newApp(config Config, env webhandler.Env, primary *sql.DB) *App {
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	env.Debug = config.Debug
+	env.LogSample = config.LogSample
+	env.RedactParams = config.RedactParams
+	if config.StmtCacheSize > 0 {
+		env.StmtCache = dbaccess.NewStmtCache(primary, config.StmtCacheSize)
+	}
+	s := NewServer()
+	s.Addr = config.Addr
+	s.CertFile = config.TLSCert
+	s.KeyFile = config.TLSKey
+	s.ReadTimeout = config.ReadTimeout
+	s.WriteTimeout = config.WriteTimeout
+	return &App{config: config, env: env, mux: s.Handler.(*mux), server: s}
+}
+
+//SetLogger replace the logger of the requests, call it before Handle.
+func (a *App) SetLogger(l webhandler.Logger) {
+	a.env.Logger = l
+}
+
+//SetObserver set the observer of the requests, call it before Handle.
+func (a *App) SetObserver(o webhandler.RequestObserver) {
+	a.env.Observer = o
+}
+
+//SetQueryObserver set the observer of the dbaccess queries of the requests, call it
+//before Handle.
+func (a *App) SetQueryObserver(o dbaccess.Observer) {
+	a.env.QueryObserver = o
+}
+
+//SetTracer set the tracer of the requests, call it before Handle.
+func (a *App) SetTracer(t *tracing.Tracer) {
+	a.env.Tracer = t
+}
+
+//StmtCache return the prepared statement cache of the primary database, nil if the
+//StmtCacheSize of the Config is zero.
+func (a *App) StmtCache() *dbaccess.StmtCache {
+	return a.env.StmtCache
+}
+
+//Config return the config of the App.
+func (a *App) Config() Config {
+	return a.config
+}
+
+//Handle register the handlers for the pattern, the handlers is chained with
+//webhandler.New and bound to the database, logger and debug of the App.
+func (a *App) Handle(pattern string, handlers ...webhandler.WebHandler) {
+	env := a.env
+	env.Route = pattern
+	a.mux.Handle(pattern, env.New(handlers...))
+}
+
+//ServeHTTP serve the request with the routes of the App.
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+//Server return the server of the App.
+func (a *App) Server() *Server {
+	return a.server
+}
+
+//Start serve the App on the Addr until Shutdown.
+func (a *App) Start() error {
+	return a.server.Start()
+}
+
+//Shutdown stop the App gracefully, the in-flight requests is drained for maximum
+//the ShutdownTimeout.
+func (a *App) Shutdown(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, a.config.ShutdownTimeout)
+	defer cancel()
+	err := a.server.Shutdown(ctx)
+	if a.env.StmtCache != nil {
+		if cerr := a.env.StmtCache.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
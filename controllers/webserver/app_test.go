@@ -0,0 +1,105 @@
+package webserver
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/riyan/apiatex/controllers/webserver/webhandler"
+)
+
+//dbWH respond with the data source of the db of the request.
+type dbWH struct {
+	names map[*sql.DB]string
+}
+
+func (wh dbWH) Handle(w http.ResponseWriter, r *http.Request) webhandler.Response {
+	var res webhandler.Response
+	db, err := webhandler.DBFromContext(r.Context())
+	if err != nil {
+		res.Error(err, http.StatusInternalServerError)
+		return res
+	}
+	sdb, ok := db.(*sql.DB)
+	if !ok {
+		res.Error(errors.New("db is not *sql.DB"), http.StatusInternalServerError)
+		return res
+	}
+	res.Handled = true
+	w.Write([]byte(wh.names[sdb]))
+	return res
+}
+
+func TestApp(t *testing.T) {
+	names := map[*sql.DB]string{}
+	var apps []*App
+	for _, name := range []string{"db1", "db2"} {
+		db, err := sql.Open("mysql", "root@/"+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		names[db] = name
+		app := NewApp(Config{}, db)
+		app.Handle("/db", dbWH{names: names})
+		apps = append(apps, app)
+	}
+	for i, want := range []string{"db1", "db2"} {
+		w := httptest.NewRecorder()
+		apps[i].ServeHTTP(w, httptest.NewRequest("GET", "/db", nil))
+		if got := w.Body.String(); got != want {
+			t.Errorf("app:%d got db:%q want:%q", i, got, want)
+		}
+	}
+	w := httptest.NewRecorder()
+	apps[0].ServeHTTP(w, httptest.NewRequest("GET", "/other", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status code:%d want:%d", w.Code, http.StatusNotFound)
+	}
+	if got := apps[0].Config().ShutdownTimeout; got != DefaultShutdownTimeout {
+		t.Errorf("got shutdown timeout:%s want:%s", got, DefaultShutdownTimeout)
+	}
+	if apps[0].StmtCache() != nil {
+		t.Error("got statement cache want nil when the size is zero")
+	}
+}
+
+//queryWH run the query with the db of the request.
+type queryWH struct{}
+
+func (queryWH) Handle(w http.ResponseWriter, r *http.Request) webhandler.Response {
+	var res webhandler.Response
+	db, err := webhandler.DBFromContext(r.Context())
+	if err != nil {
+		res.Error(err, http.StatusInternalServerError)
+		return res
+	}
+	var one int
+	if err = db.QueryRow("SELECT 1").Scan(&one); err != nil {
+		res.Error(err, http.StatusInternalServerError)
+	}
+	return res
+}
+
+func TestAppStmtCache(t *testing.T) {
+	db, err := sql.Open("mysql", "root@/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	app := NewApp(Config{StmtCacheSize: 10}, db)
+	app.Handle("/one", queryWH{})
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest("GET", "/one", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status code:%d body:%s", w.Code, w.Body)
+		}
+	}
+	if got := app.StmtCache().Stats(); got.Hits != 1 || got.Misses != 1 || got.Size != 1 {
+		t.Errorf("got stats:%+v want 1 hit and 1 miss", got)
+	}
+}
@@ -0,0 +1,54 @@
+package webhandler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS is a WebHandler that allow the cross-origin requests from the AllowedOrigins,
+// the preflight request is handled by CORS and not sent to the next handler.
+type CORS struct {
+	// AllowedOrigins is the origins allowed to call the API, * allow any origin.
+	AllowedOrigins []string
+	// AllowedMethods and AllowedHeaders is returned on the preflight response.
+	AllowedMethods []string
+	AllowedHeaders []string
+	// MaxAge is the duration the preflight response can be cached.
+	MaxAge time.Duration
+}
+
+// Handle implement WebHandler.
+func (c CORS) Handle(w http.ResponseWriter, r *http.Request) Response {
+	var res Response
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	w.Header().Add("Vary", "Origin")
+	if origin != "" && c.allowed(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if preflight {
+			h := w.Header()
+			h.Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+			h.Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+			if c.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+			}
+		}
+	}
+	if preflight {
+		w.WriteHeader(http.StatusNoContent)
+		res.Handled = true
+	}
+	return res
+}
+
+// allowed return true if the origin is on the AllowedOrigins.
+func (c CORS) allowed(origin string) bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package webhandler
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+)
+
+// PoolStats is the sql.DBStats of a database on the DBStats response.
+type PoolStats struct {
+	Name               string `json:"name"`
+	MaxOpenConnections int    `json:"max_open_connections"`
+	OpenConnections    int    `json:"open_connections"`
+	InUse              int    `json:"in_use"`
+	Idle               int    `json:"idle"`
+	WaitCount          int64  `json:"wait_count"`
+	WaitDuration       string `json:"wait_duration"`
+	MaxIdleClosed      int64  `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64  `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64  `json:"max_lifetime_closed"`
+}
+
+// DBStats is a WebHandler that respond with the connection pool stats of the
+// databases, the key of the map is the name of the database on the response.
+type DBStats map[string]*sql.DB
+
+// Handle implement WebHandler.
+func (ds DBStats) Handle(w http.ResponseWriter, r *http.Request) Response {
+	var res Response
+	if r.Method != http.MethodGet {
+		res.Error(nil, http.StatusMethodNotAllowed)
+		return res
+	}
+	names := make([]string, 0, len(ds))
+	for name := range ds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stats := make([]PoolStats, len(names))
+	for i, name := range names {
+		s := ds[name].Stats()
+		stats[i] = PoolStats{
+			Name:               name,
+			MaxOpenConnections: s.MaxOpenConnections,
+			OpenConnections:    s.OpenConnections,
+			InUse:              s.InUse,
+			Idle:               s.Idle,
+			WaitCount:          s.WaitCount,
+			WaitDuration:       s.WaitDuration.String(),
+			MaxIdleClosed:      s.MaxIdleClosed,
+			MaxIdleTimeClosed:  s.MaxIdleTimeClosed,
+			MaxLifetimeClosed:  s.MaxLifetimeClosed,
+		}
+	}
+	res.Data = stats
+	return res
+}
@@ -0,0 +1,506 @@
+package webhandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/riyan/apiatex/controllers/dbaccess"
+)
+
+const (
+	// FormatParam is the query parameter that select the format of the response, it
+	// replace the Accept header.
+	FormatParam = "format"
+	// FieldsParam is the query parameter of the fields of the records, separated by
+	// comma. The records is written with the fields in order.
+	FieldsParam = "fields"
+	// NextCursorHeader is the header of the next cursor of the response that is not
+	// JSON, the JSON response has the cursor on the body.
+	NextCursorHeader = "X-Next-Cursor"
+	// ErrorTrailer is the trailer of the error happen while the records is written.
+	ErrorTrailer = "X-Error"
+)
+
+// Record is a record of Response.Data for the Encoder, the values is the JSON
+// encoding of the fields.
+type Record struct {
+	// Fields is the fields of the record in order.
+	Fields []string
+	Values map[string]json.RawMessage
+}
+
+// Text return the text of the field, the JSON string is unquoted, null is empty and
+// the other value is its JSON encoding.
+func (rec Record) Text(field string) string {
+	v := rec.Values[field]
+	if len(v) == 0 || string(v) == "null" {
+		return ""
+	}
+	if v[0] == '"' {
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			return s
+		}
+	}
+	return string(v)
+}
+
+// RecordWriter write the records of the response.
+type RecordWriter interface {
+	WriteRecord(rec Record) error
+	// Close finish the response, errMessage is the error of the response, e.g. the
+	// error happen while the records is read.
+	Close(errMessage string) error
+}
+
+// Encoder is a format of the Response.Data other than the JSON of the Response.
+type Encoder interface {
+	// Format is the name of the format on the format query parameter, e.g. csv.
+	Format() string
+	// MediaTypes is the media types of the format on the Accept header, the first
+	// one is the Content-Type of the response.
+	MediaTypes() []string
+	// NewWriter return the RecordWriter of the response, fields is the fields
+	// selected by the request in order, nil is every field of the records.
+	NewWriter(w io.Writer, fields []string) RecordWriter
+}
+
+// DefaultEncoders is the encoders used when the Encoders of Env is nil.
+var DefaultEncoders = []Encoder{CSVEncoder{}, XMLEncoder{}, NDJSONEncoder{}}
+
+// negotiate return the Encoder of the format query or the Accept header of the
+// request, nil Encoder is the JSON. ok is false if the format is not supported.
+func negotiate(r *http.Request, encoders []Encoder) (e Encoder, ok bool) {
+	if f := r.URL.Query().Get(FormatParam); f != "" {
+		if strings.EqualFold(f, "json") {
+			return nil, true
+		}
+		for _, e := range encoders {
+			if strings.EqualFold(f, e.Format()) {
+				return e, true
+			}
+		}
+		return nil, false
+	}
+	for _, mt := range acceptMediaTypes(r.Header.Get("Accept")) {
+		if mt == "*/*" || mt == "application/*" || mt == "application/json" {
+			return nil, true
+		}
+		for _, e := range encoders {
+			for _, emt := range e.MediaTypes() {
+				if mt == emt {
+					return e, true
+				}
+			}
+		}
+	}
+	//the client that does not accept JSON still get JSON, it is the format before
+	//the encoders is added.
+	return nil, true
+}
+
+// acceptMediaTypes return the media types of the Accept header ordered by their
+// quality, the media type with zero quality is removed.
+func acceptMediaTypes(accept string) []string {
+	type mediaRange struct {
+		mt string
+		q  float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{mt: mt, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	mts := make([]string, len(ranges))
+	for i, r := range ranges {
+		mts[i] = r.mt
+	}
+	return mts
+}
+
+// acceptGzip report whether the Accept-Encoding header of the request accept gzip.
+func acceptGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.TrimSpace(coding)
+		if !strings.EqualFold(coding, "gzip") && coding != "*" {
+			continue
+		}
+		if k, v, ok := strings.Cut(params, "="); ok && strings.TrimSpace(k) == "q" {
+			q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
+
+// gzipWriter compress the body of the response.
+type gzipWriter struct {
+	http.ResponseWriter
+	zw *gzip.Writer
+}
+
+func (g gzipWriter) Write(b []byte) (int, error) {
+	return g.zw.Write(b)
+}
+
+// encode write the Response.Data with the Encoder, the cursor is on the
+// NextCursorHeader and the Link header. The iterator with a limit is read before the
+// response is written so the cursor is known, the iterator without a limit does not
+// have a next cursor.
+func (res Response) encode(w http.ResponseWriter, r *http.Request, e Encoder) error {
+	cursor := res.Cursor
+	data := res.Data
+	errMessage := res.ErrMessage
+	if it, ok := data.(TableIterator); ok {
+		cursor = it.Cursor()
+		if cursor.Limit > 0 {
+			var tables []dbaccess.Table
+			for it.Next() {
+				tables = append(tables, it.Table())
+			}
+			if err := it.Err(); err != nil && errMessage == "" {
+				errMessage = err.Error()
+			}
+			it.Close()
+			data, cursor = tables, it.Cursor()
+		}
+	}
+	h := w.Header()
+	if cursor.Limit > 0 || cursor.Page > 0 {
+		next := cursor.String()
+		h.Set(NextCursorHeader, next)
+		q := url.Values{"cursor": {next}}
+		for _, p := range []string{FormatParam, FieldsParam} {
+			if v := r.URL.Query().Get(p); v != "" {
+				q.Set(p, v)
+			}
+		}
+		h.Set("Link", "<"+r.URL.Path+"?"+q.Encode()+`>; rel="next"`)
+	}
+	h.Set("Content-Type", e.MediaTypes()[0]+"; charset=utf-8")
+	h.Set("Trailer", ErrorTrailer)
+	rw := e.NewWriter(w, selectedFields(r))
+	err := eachRecord(data, rw.WriteRecord)
+	if err != nil && errMessage == "" {
+		errMessage = err.Error()
+	}
+	if cerr := rw.Close(errMessage); err == nil {
+		err = cerr
+	}
+	if errMessage != "" {
+		h.Set(ErrorTrailer, errMessage)
+	}
+	return err
+}
+
+// selectedFields return the fields of the FieldsParam, nil if the request does not
+// select the fields. The Fields of the cursor is not used, it has every field of the
+// table when the request does not select them.
+func selectedFields(r *http.Request) []string {
+	q := strings.TrimSpace(r.URL.Query().Get(FieldsParam))
+	if q == "" {
+		return nil
+	}
+	fields := strings.Split(q, ",")
+	for i, f := range fields {
+		fields[i] = strings.TrimSpace(f)
+	}
+	return fields
+}
+
+// eachRecord call fn with every record of the data. The TableIterator and the slice
+// is a list of records, nil is no record and the other value is one record.
+func eachRecord(data interface{}, fn func(rec Record) error) error {
+	if data == nil {
+		return nil
+	}
+	if it, ok := data.(TableIterator); ok {
+		defer it.Close()
+		for it.Next() {
+			if err := writeRecord(it.Table(), fn); err != nil {
+				return err
+			}
+		}
+		return it.Err()
+	}
+	v := reflect.ValueOf(data)
+	if (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < v.Len(); i++ {
+			if err := writeRecord(v.Index(i).Interface(), fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return writeRecord(data, fn)
+}
+
+func writeRecord(v interface{}, fn func(rec Record) error) error {
+	rec, err := newRecord(v)
+	if err != nil {
+		return err
+	}
+	return fn(rec)
+}
+
+// newRecord return the Record of the JSON encoding of v, the fields is in the order
+// of the JSON object. The value that is not an object is the value field.
+func newRecord(v interface{}) (Record, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return Record{}, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	tok, err := dec.Token()
+	if err != nil {
+		return Record{}, err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return Record{Fields: []string{"value"}, Values: map[string]json.RawMessage{"value": b}}, nil
+	}
+	rec := Record{Values: map[string]json.RawMessage{}}
+	for dec.More() {
+		tok, err = dec.Token()
+		if err != nil {
+			return rec, err
+		}
+		key, _ := tok.(string)
+		var raw json.RawMessage
+		if err = dec.Decode(&raw); err != nil {
+			return rec, err
+		}
+		if _, ok := rec.Values[key]; !ok {
+			rec.Fields = append(rec.Fields, key)
+		}
+		rec.Values[key] = raw
+	}
+	return rec, nil
+}
+
+// project return the fields of the record that is written, the selected fields or
+// every field of the record.
+func project(selected []string, rec Record) []string {
+	if len(selected) > 0 {
+		return selected
+	}
+	return rec.Fields
+}
+
+// CSVEncoder write the records as CSV with a header row, the columns is the selected
+// fields in order or the fields of the first record. The nested value is its JSON.
+type CSVEncoder struct{}
+
+// Format implement Encoder.
+func (CSVEncoder) Format() string { return "csv" }
+
+// MediaTypes implement Encoder.
+func (CSVEncoder) MediaTypes() []string { return []string{"text/csv"} }
+
+// NewWriter implement Encoder.
+func (CSVEncoder) NewWriter(w io.Writer, fields []string) RecordWriter {
+	return &csvWriter{w: csv.NewWriter(w), fields: fields}
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	fields []string
+	header bool
+}
+
+func (cw *csvWriter) WriteRecord(rec Record) error {
+	if !cw.header {
+		cw.fields = project(cw.fields, rec)
+		cw.header = true
+		if err := cw.w.Write(cw.fields); err != nil {
+			return err
+		}
+	}
+	row := make([]string, len(cw.fields))
+	for i, f := range cw.fields {
+		row[i] = rec.Text(f)
+	}
+	return cw.w.Write(row)
+}
+
+func (cw *csvWriter) Close(errMessage string) error {
+	if !cw.header && len(cw.fields) > 0 {
+		cw.w.Write(cw.fields)
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// NDJSONEncoder write every record as a JSON object on its own line, the error is
+// written as the last line {"err": ...}.
+type NDJSONEncoder struct{}
+
+// Format implement Encoder.
+func (NDJSONEncoder) Format() string { return "ndjson" }
+
+// MediaTypes implement Encoder.
+func (NDJSONEncoder) MediaTypes() []string {
+	return []string{"application/x-ndjson", "application/ndjson", "application/jsonl"}
+}
+
+// NewWriter implement Encoder.
+func (NDJSONEncoder) NewWriter(w io.Writer, fields []string) RecordWriter {
+	return &ndjsonWriter{w: w, fields: fields}
+}
+
+type ndjsonWriter struct {
+	w      io.Writer
+	fields []string
+	buf    bytes.Buffer
+}
+
+func (nw *ndjsonWriter) WriteRecord(rec Record) error {
+	nw.buf.Reset()
+	nw.buf.WriteByte('{')
+	for i, f := range project(nw.fields, rec) {
+		if i > 0 {
+			nw.buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(f)
+		nw.buf.Write(k)
+		nw.buf.WriteByte(':')
+		if v, ok := rec.Values[f]; ok {
+			nw.buf.Write(v)
+		} else {
+			nw.buf.WriteString("null")
+		}
+	}
+	nw.buf.WriteString("}\n")
+	_, err := nw.w.Write(nw.buf.Bytes())
+	return err
+}
+
+func (nw *ndjsonWriter) Close(errMessage string) error {
+	if errMessage == "" {
+		return nil
+	}
+	b, _ := json.Marshal(map[string]string{"err": errMessage})
+	_, err := nw.w.Write(append(b, '\n'))
+	return err
+}
+
+// XMLEncoder write the records as <response><data><record>...</record></data>
+// <err>...</err></response>, every field is an element, the nested object is nested
+// elements and the array is the repeated item element.
+type XMLEncoder struct{}
+
+// Format implement Encoder.
+func (XMLEncoder) Format() string { return "xml" }
+
+// MediaTypes implement Encoder.
+func (XMLEncoder) MediaTypes() []string { return []string{"application/xml", "text/xml"} }
+
+// NewWriter implement Encoder.
+func (XMLEncoder) NewWriter(w io.Writer, fields []string) RecordWriter {
+	return &xmlWriter{w: w, fields: fields}
+}
+
+type xmlWriter struct {
+	w       io.Writer
+	fields  []string
+	started bool
+	buf     bytes.Buffer
+}
+
+func (xw *xmlWriter) start() {
+	if !xw.started {
+		xw.started = true
+		xw.buf.WriteString(xml.Header + "<response><data>")
+	}
+}
+
+func (xw *xmlWriter) WriteRecord(rec Record) error {
+	xw.buf.Reset()
+	xw.start()
+	xw.buf.WriteString("<record>")
+	for _, f := range project(xw.fields, rec) {
+		writeXMLValue(&xw.buf, f, rec.Values[f])
+	}
+	xw.buf.WriteString("</record>")
+	_, err := xw.w.Write(xw.buf.Bytes())
+	return err
+}
+
+func (xw *xmlWriter) Close(errMessage string) error {
+	xw.buf.Reset()
+	xw.start()
+	xw.buf.WriteString("</data><err>")
+	xml.EscapeText(&xw.buf, []byte(errMessage))
+	xw.buf.WriteString("</err></response>\n")
+	_, err := xw.w.Write(xw.buf.Bytes())
+	return err
+}
+
+// writeXMLValue write the JSON value as the element of the name.
+func writeXMLValue(buf *bytes.Buffer, name string, v json.RawMessage) {
+	name = xmlName(name)
+	if len(v) > 0 && v[0] == '[' {
+		var items []json.RawMessage
+		if json.Unmarshal(v, &items) == nil {
+			buf.WriteString("<" + name + ">")
+			for _, item := range items {
+				writeXMLValue(buf, "item", item)
+			}
+			buf.WriteString("</" + name + ">")
+			return
+		}
+	}
+	if len(v) > 0 && v[0] == '{' {
+		if rec, err := newRecord(v); err == nil {
+			buf.WriteString("<" + name + ">")
+			for _, f := range rec.Fields {
+				writeXMLValue(buf, f, rec.Values[f])
+			}
+			buf.WriteString("</" + name + ">")
+			return
+		}
+	}
+	buf.WriteString("<" + name + ">")
+	xml.EscapeText(buf, []byte(Record{Values: map[string]json.RawMessage{"v": v}}.Text("v")))
+	buf.WriteString("</" + name + ">")
+}
+
+// xmlName replace the characters that is not valid on the XML element name.
+func xmlName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		valid := c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			i > 0 && (c == '-' || c == '.' || c >= '0' && c <= '9')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
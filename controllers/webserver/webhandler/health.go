@@ -0,0 +1,177 @@
+package webhandler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/riyan/apiatex/controllers/dbaccess"
+)
+
+// DefaultCheckTimeout is the timeout of the readiness checks when the Timeout of
+// Readiness is zero.
+const DefaultCheckTimeout = 2 * time.Second
+
+// Checker check a dependency of the service, the ctx has the DB of the request.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc is a function that implement Checker.
+type CheckerFunc func(ctx context.Context) error
+
+// Check implement Checker.
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// PingDB is the Checker that ping the DB of the request.
+var PingDB = CheckerFunc(func(ctx context.Context) error {
+	db, ok := ctx.Value(dbContextKey).(*sql.DB)
+	if !ok {
+		return ErrCtxNoDB
+	}
+	return db.PingContext(ctx)
+})
+
+// SchemaChecker return the Checker that check the schema version of the DB of the
+// request is at least the version, see dbaccess.Migrate.
+func SchemaChecker(version int) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		db, err := DBFromContext(ctx)
+		if err != nil {
+			return err
+		}
+		current, err := dbaccess.SchemaVersion(db)
+		if err != nil {
+			return err
+		}
+		if current < version {
+			return fmt.Errorf("schema version %d is behind %d", current, version)
+		}
+		return nil
+	})
+}
+
+// Liveness is a WebHandler that respond 200 while the process can serve requests.
+type Liveness struct{}
+
+// Handle implement WebHandler.
+func (Liveness) Handle(w http.ResponseWriter, r *http.Request) Response {
+	writeHealth(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+	return Response{Handled: true}
+}
+
+// Readiness is a WebHandler that run the Checkers concurrently and respond 200 if
+// every check succeed or 503 with the error of the failed checks.
+type Readiness struct {
+	// Checkers is the checks by name.
+	Checkers map[string]Checker
+	// Timeout is the timeout of the checks, default DefaultCheckTimeout.
+	Timeout time.Duration
+}
+
+// Handle implement WebHandler.
+func (rd Readiness) Handle(w http.ResponseWriter, r *http.Request) Response {
+	timeout := rd.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	checks := make(map[string]string, len(rd.Checkers))
+	code := http.StatusOK
+	for name, c := range rd.Checkers {
+		wg.Add(1)
+		go func(name string, c Checker) {
+			defer wg.Done()
+			err := check(ctx, c)
+			mu.Lock()
+			defer mu.Unlock()
+			checks[name] = "ok"
+			if err != nil {
+				checks[name] = err.Error()
+				code = http.StatusServiceUnavailable
+			}
+		}(name, c)
+	}
+	wg.Wait()
+	status := "ok"
+	if code != http.StatusOK {
+		status = "unavailable"
+	}
+	writeHealth(w, code, map[string]interface{}{"status": status, "checks": checks})
+	return Response{Handled: true}
+}
+
+// check run the Checker until it return or ctx is done.
+func check(ctx context.Context, c Checker) error {
+	errc := make(chan error, 1)
+	go func() { errc <- c.Check(ctx) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return errors.New("timeout")
+		}
+		return ctx.Err()
+	}
+}
+
+// BuildInfo is the build information of the service, the Version, Commit and Time
+// is usually set with -ldflags -X.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Time      string `json:"time"`
+	GoVersion string `json:"go_version"`
+}
+
+// ReadBuildInfo fill the empty field of the info from the build information of the
+// binary.
+func ReadBuildInfo(info BuildInfo) BuildInfo {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = bi.GoVersion
+	if info.Version == "" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch {
+		case s.Key == "vcs.revision" && info.Commit == "":
+			info.Commit = s.Value
+		case s.Key == "vcs.time" && info.Time == "":
+			info.Time = s.Value
+		}
+	}
+	return info
+}
+
+// Version is a WebHandler that respond with the BuildInfo.
+type Version BuildInfo
+
+// Handle implement WebHandler.
+func (v Version) Handle(w http.ResponseWriter, r *http.Request) Response {
+	writeHealth(w, http.StatusOK, BuildInfo(v))
+	return Response{Handled: true}
+}
+
+// writeHealth write the body as JSON, the response is not cached.
+func writeHealth(w http.ResponseWriter, code int, body interface{}) {
+	b, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(b)
+}
@@ -0,0 +1,314 @@
+package webhandler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/riyan/apiatex/controllers/dbaccess"
+)
+
+// IdempotencyHeader is the request header of the idempotency key.
+const IdempotencyHeader = "Idempotency-Key"
+
+// ReplayedHeader is set to true on the response replayed from an idempotency key.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// IdempotencyTableName is the table of the idempotency keys, the table is created by
+// the IdempotencyKeyTable migration of the models.
+const IdempotencyTableName = "idempotency_key"
+
+// DefaultIdempotencyTTL is how long the response of a key is kept when the TTL of
+// Idempotency is zero.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// DefaultIdempotencyLease is how long the key is in progress when the Lease of
+// Idempotency is zero.
+const DefaultIdempotencyLease = time.Minute
+
+// DefaultIdempotencyMaxBody is the largest response kept when the MaxBody of
+// Idempotency is zero.
+const DefaultIdempotencyMaxBody = 1 << 20
+
+// DefaultIdempotencyMaxRequest is the largest request body of a key when the
+// MaxRequest of Idempotency is zero.
+const DefaultIdempotencyMaxRequest = 10 << 20
+
+// maxIdempotencyKey is the longest key, it is the size of the idem_key column.
+const maxIdempotencyKey = 255
+
+var (
+	// ErrIdempotencyKeyReused is the error of the key that is reused with a different
+	// request.
+	ErrIdempotencyKeyReused = errors.New("idempotency key is reused with a different request")
+	// ErrIdempotencyInProgress is the error of the key that its first request is not
+	// finished yet.
+	ErrIdempotencyInProgress = errors.New("request of the idempotency key is in progress")
+)
+
+// Idempotency is a WebHandler that replay the response of the request that is retried
+// with the same Idempotency-Key header instead of handling it again. The key is claimed
+// with an insert on the IdempotencyTableName table before the next handlers, so only
+// one of the concurrent requests of a key is handled and the others get 409 until it
+// is finished. The key reused with a different method, path, body or principal is
+// rejected with 422. The response with status 500 or more is not kept, so the request
+// can be retried. The key that is in progress longer than the Lease is claimed again,
+// so the key of the process that crashed is not rejected until it is expired.
+// Idempotency must be put after the TenantResolver and the handlers
+// that set the principal, the key is scoped to the tenant.
+type Idempotency struct {
+	// TTL is how long the response is replayed, DefaultIdempotencyTTL if it is zero.
+	TTL time.Duration
+	// Lease is how long the key is in progress before it can be claimed again by the
+	// retry, DefaultIdempotencyLease if it is zero. It must be longer than the handlers.
+	Lease time.Duration
+	// Methods is the methods that use the key, POST, PUT, PATCH and DELETE if it is nil.
+	Methods []string
+	// MaxBody is the largest response body kept, the larger response is not replayed.
+	// It is DefaultIdempotencyMaxBody if it is zero.
+	MaxBody int
+	// MaxRequest is the largest request body of the request with the key, the larger
+	// request is rejected with 413. It is DefaultIdempotencyMaxRequest if it is zero.
+	MaxRequest int64
+}
+
+// Handle implement WebHandler.
+func (idem Idempotency) Handle(w http.ResponseWriter, r *http.Request) Response {
+	var res Response
+	key := r.Header.Get(IdempotencyHeader)
+	sw, ok := w.(*statusWriter)
+	if key == "" || !ok || !idem.method(r.Method) {
+		return res
+	}
+	if len(key) > maxIdempotencyKey {
+		res.Error(fmt.Errorf("%s is longer than %d", IdempotencyHeader, maxIdempotencyKey), http.StatusBadRequest)
+		return res
+	}
+	db, err := DBFromContext(r.Context())
+	if err != nil {
+		res.Error(err, http.StatusInternalServerError)
+		return res
+	}
+	maxRequest := idem.MaxRequest
+	if maxRequest <= 0 {
+		maxRequest = DefaultIdempotencyMaxRequest
+	}
+	//the body is read to the memory to be hashed, so its size is limited.
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequest))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		res.Error(err, http.StatusRequestEntityTooLarge)
+		return res
+	}
+	if err != nil {
+		res.Error(err, http.StatusBadRequest)
+		return res
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	tenant, _ := TenantFromContext(r.Context())
+	rec := &idempotencyRecord{
+		TenantId:    tenant,
+		Key:         key,
+		Method:      r.Method,
+		Path:        r.URL.RequestURI(),
+		Fingerprint: fingerprint(r, body),
+	}
+	stored, err := idem.claim(db, rec)
+	if err != nil {
+		res.Error(err, http.StatusInternalServerError)
+		return res
+	}
+	if stored == nil {
+		idem.capture(db, sw, rec)
+		return res
+	}
+	switch {
+	case stored.Fingerprint != rec.Fingerprint:
+		res.Error(ErrIdempotencyKeyReused, http.StatusUnprocessableEntity)
+	case stored.Status == 0:
+		res.Error(ErrIdempotencyInProgress, http.StatusConflict)
+	default:
+		stored.replay(w)
+		res.Handled = true
+	}
+	return res
+}
+
+// Purge delete the expired keys.
+func (idem Idempotency) Purge(db dbaccess.DBExecer) error {
+	return dbaccess.DeleteAll(db, &idempotencyRecord{},
+		dbaccess.Filter{Field: "expires_at", Op: "<=", Value: time.Now().UTC()})
+}
+
+// claim insert the record of the key, if the key is already claimed it return the
+// stored record. The expired record and the record that is in progress longer than the
+// lease is deleted and claimed again.
+func (idem Idempotency) claim(db dbaccess.DBExecer, rec *idempotencyRecord) (*idempotencyRecord, error) {
+	ttl := idem.TTL
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	lease := idem.Lease
+	if lease <= 0 {
+		lease = DefaultIdempotencyLease
+	}
+	for attempt := 0; ; attempt++ {
+		//the created_at column keep the microseconds, so the record is found by it.
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		rec.CreatedAt, rec.ExpiresAt = now, now.Add(ttl)
+		ierr := dbaccess.Insert(db, rec)
+		if ierr == nil {
+			return nil, nil
+		}
+		stored := &idempotencyRecord{TenantId: rec.TenantId, Key: rec.Key}
+		err := dbaccess.Get(db, stored)
+		if err == sql.ErrNoRows && attempt == 0 {
+			//the record is deleted after the insert failed.
+			continue
+		}
+		if err == sql.ErrNoRows {
+			return nil, ierr
+		}
+		if err != nil {
+			return nil, err
+		}
+		expired := !stored.ExpiresAt.After(now)
+		stale := stored.Status == 0 && !stored.CreatedAt.After(now.Add(-lease))
+		if !(expired || stale) || attempt > 0 {
+			return stored, nil
+		}
+		//only the expired or stale record is deleted, the record claimed again by the
+		//other request is kept.
+		fs := []dbaccess.Filter{
+			{Field: "tenant_id", Op: "=", Value: stored.TenantId},
+			{Field: "idem_key", Op: "=", Value: stored.Key},
+		}
+		if expired {
+			fs = append(fs, dbaccess.Filter{Field: "expires_at", Op: "<=", Value: now})
+		} else {
+			fs = append(fs, dbaccess.Filter{Field: "status", Op: "=", Value: 0},
+				dbaccess.Filter{Field: "created_at", Op: "=", Value: stored.CreatedAt})
+		}
+		if err = dbaccess.DeleteAll(db, stored, fs...); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// capture keep the response of the request on the claimed record after it is written,
+// the record is deleted if the response can not be replayed. The record claimed again
+// after the lease is not changed.
+func (idem Idempotency) capture(db dbaccess.DBExecer, sw *statusWriter, rec *idempotencyRecord) {
+	max := idem.MaxBody
+	if max <= 0 {
+		max = DefaultIdempotencyMaxBody
+	}
+	sw.capture = &bytes.Buffer{}
+	sw.captureMax = max
+	claimed := []dbaccess.Filter{
+		{Field: "tenant_id", Op: "=", Value: rec.TenantId},
+		{Field: "idem_key", Op: "=", Value: rec.Key},
+		{Field: "created_at", Op: "=", Value: rec.CreatedAt},
+	}
+	sw.done = func(err error) {
+		status := sw.status()
+		if err != nil || status >= http.StatusInternalServerError || sw.truncated {
+			dbaccess.DeleteAll(db, rec, claimed...)
+			return
+		}
+		h := sw.Header().Clone()
+		h.Del(RequestIDHeader)
+		header, err := json.Marshal(h)
+		if err != nil {
+			dbaccess.DeleteAll(db, rec, claimed...)
+			return
+		}
+		err = dbaccess.UpdateAll(db, rec, map[string]interface{}{
+			"status": status,
+			"header": string(header),
+			"body":   sw.capture.Bytes(),
+		}, claimed...)
+		if err != nil {
+			dbaccess.DeleteAll(db, rec, claimed...)
+		}
+	}
+}
+
+// method return true if the method use the idempotency key.
+func (idem Idempotency) method(m string) bool {
+	methods := idem.Methods
+	if methods == nil {
+		methods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	for _, v := range methods {
+		if v == m {
+			return true
+		}
+	}
+	return false
+}
+
+// fingerprint return the hash of the method, the path, the principal and the body of
+// the request.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	var principal string
+	if s := stateFromContext(r.Context()); s != nil {
+		principal = s.getPrincipal()
+	}
+	fmt.Fprintf(h, "%s\n%s\n%s\n", r.Method, r.URL.RequestURI(), principal)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyRecord is the record of the key on the IdempotencyTableName table, the
+// Status is zero until the response is written.
+type idempotencyRecord struct {
+	TenantId    string
+	Key         string
+	Method      string
+	Path        string
+	Fingerprint string
+	Status      int
+	Header      string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+func (rec *idempotencyRecord) Name() string { return IdempotencyTableName }
+
+func (rec *idempotencyRecord) PrimaryKey() ([]string, []interface{}) {
+	return []string{"tenant_id", "idem_key"}, []interface{}{&rec.TenantId, &rec.Key}
+}
+
+func (rec *idempotencyRecord) New() dbaccess.Table { return &idempotencyRecord{} }
+
+func (rec *idempotencyRecord) Fields() ([]string, []interface{}) {
+	return []string{"tenant_id", "idem_key", "method", "path", "fingerprint", "status",
+			"header", "body", "created_at", "expires_at"},
+		[]interface{}{&rec.TenantId, &rec.Key, &rec.Method, &rec.Path, &rec.Fingerprint,
+			&rec.Status, &rec.Header, &rec.Body, &rec.CreatedAt, &rec.ExpiresAt}
+}
+
+func (rec *idempotencyRecord) HasAutoIncrementField() bool { return false }
+
+// replay write the stored response.
+func (rec *idempotencyRecord) replay(w http.ResponseWriter) {
+	var h http.Header
+	json.Unmarshal([]byte(rec.Header), &h)
+	for k, v := range h {
+		w.Header()[k] = v
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(rec.Status)
+	w.Write(rec.Body)
+}
@@ -0,0 +1,250 @@
+package webhandler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/riyan/apiatex/controllers/tracing"
+)
+
+// RequestIDHeader is the header of the request ID, the ID of the request is kept if it
+// is valid and it is sent back on the response.
+const RequestIDHeader = "X-Request-ID"
+
+// Redacted replace the value of the redacted query parameters on the log.
+const Redacted = "[REDACTED]"
+
+// DefaultRedactParams is the query parameters redacted on the access log when the
+// RedactParams of Env is nil.
+var DefaultRedactParams = []string{"cursor", "token", "access_token", "api_key", "password", "secret"}
+
+// Logger is the log of the requests, *slog.Logger implement it.
+type Logger interface {
+	LogAttrs(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr)
+}
+
+// requestState is the log state of a request shared by its handlers.
+type requestState struct {
+	id     string
+	logger *slog.Logger
+	mu     sync.Mutex
+	// principal is the authenticated caller of the request.
+	principal string
+}
+
+func newContextWithState(ctx context.Context, s *requestState) context.Context {
+	return context.WithValue(ctx, logContextKey, s)
+}
+
+func stateFromContext(ctx context.Context) *requestState {
+	s, _ := ctx.Value(logContextKey).(*requestState)
+	return s
+}
+
+// RequestIDFromContext return the request ID of the request.
+func RequestIDFromContext(ctx context.Context) string {
+	if s := stateFromContext(ctx); s != nil {
+		return s.id
+	}
+	return ""
+}
+
+// SetPrincipal set the authenticated caller of the request that is logged on the
+// access log, e.g. the subject of the token.
+func SetPrincipal(ctx context.Context, principal string) {
+	if s := stateFromContext(ctx); s != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.principal = principal
+	}
+}
+
+func (s *requestState) getPrincipal() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.principal
+}
+
+// LoggerFromContext return the logger of the request, the record has the request ID.
+// It is slog.Default if ctx is not the context of a request.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if s := stateFromContext(ctx); s != nil {
+		return s.logger
+	}
+	return slog.Default()
+}
+
+// requestLogger return the *slog.Logger of l with the attributes of the request.
+func requestLogger(l Logger, attrs ...slog.Attr) *slog.Logger {
+	args := make([]interface{}, len(attrs))
+	for i, a := range attrs {
+		args[i] = a
+	}
+	if sl, ok := l.(*slog.Logger); ok {
+		return sl.With(args...)
+	}
+	return slog.New(&loggerHandler{l: l}).With(args...)
+}
+
+// loggerHandler is the slog.Handler that write the record to the Logger.
+type loggerHandler struct {
+	l      Logger
+	attrs  []slog.Attr
+	prefix string
+}
+
+func (h *loggerHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if e, ok := h.l.(interface {
+		Enabled(context.Context, slog.Level) bool
+	}); ok {
+		return e.Enabled(ctx, level)
+	}
+	return true
+}
+
+func (h *loggerHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make([]slog.Attr, 0, len(h.attrs)+r.NumAttrs())
+	attrs = append(attrs, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		a.Key = h.prefix + a.Key
+		attrs = append(attrs, a)
+		return true
+	})
+	h.l.LogAttrs(ctx, r.Level, r.Message, attrs...)
+	return nil
+}
+
+func (h *loggerHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	nh := *h
+	nh.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		nh.attrs = append(nh.attrs, a)
+	}
+	return &nh
+}
+
+func (h *loggerHandler) WithGroup(name string) slog.Handler {
+	nh := *h
+	nh.prefix = h.prefix + name + "."
+	return &nh
+}
+
+// requestID return the valid request ID of the header or a new random ID.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); id != "" && len(id) <= 128 {
+		valid := true
+		for _, c := range id {
+			if c < 0x21 || c > 0x7e {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			return id
+		}
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// redactQuery replace the value of the params on the raw query, the order of the
+// query is kept.
+func redactQuery(rawQuery string, params []string) string {
+	if rawQuery == "" || len(params) == 0 {
+		return rawQuery
+	}
+	parts := strings.Split(rawQuery, "&")
+	for i, p := range parts {
+		key, _, _ := strings.Cut(p, "=")
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		for _, rp := range params {
+			if strings.EqualFold(key, rp) {
+				parts[i] = url.QueryEscape(key) + "=" + url.QueryEscape(Redacted)
+				break
+			}
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// sampler log one of every n successful requests.
+type sampler struct {
+	n     uint64
+	count atomic.Uint64
+}
+
+func (s *sampler) sample() bool {
+	if s == nil || s.n < 2 {
+		return true
+	}
+	return (s.count.Add(1)-1)%s.n == 0
+}
+
+// accessLog write the access log of the request, the failed request is always logged
+// and the successful request is sampled.
+func (wh webHandler) accessLog(r *http.Request, state *requestState, sw *statusWriter, res Response, err error) {
+	status := sw.status()
+	failed := err != nil || status >= http.StatusInternalServerError
+	if !failed && !wh.sampler.sample() {
+		return
+	}
+	params := wh.env.RedactParams
+	if params == nil {
+		params = DefaultRedactParams
+	}
+	path := r.URL.Path
+	if q := redactQuery(r.URL.RawQuery, params); q != "" {
+		path += "?" + q
+	}
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("route", wh.env.Route),
+		slog.String("path", path),
+		slog.Int("status", status),
+		slog.Int64("bytes", sw.bytes),
+		slog.Float64("handle_ms", float64(res.handleDur.Microseconds())/1000),
+		slog.Float64("response_ms", float64(res.responseDur.Microseconds())/1000),
+		slog.String("remote_addr", r.RemoteAddr),
+	}
+	if p := state.getPrincipal(); p != "" {
+		attrs = append(attrs, slog.String("principal", p))
+	}
+	if tenant, ok := TenantFromContext(r.Context()); ok {
+		attrs = append(attrs, slog.String("tenant", tenant))
+	}
+	if res.err != nil {
+		attrs = append(attrs, slog.String("error", res.err.Error()))
+		if he, ok := errors.Cause(res.err).(httpError); ok && wh.env.Debug && he.err != nil {
+			attrs = append(attrs, slog.String("error_detail", he.err.Error()))
+		}
+	}
+	level := slog.LevelInfo
+	if failed {
+		level = slog.LevelError
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("write_error", err.Error()))
+	}
+	state.logger.LogAttrs(r.Context(), level, "request", attrs...)
+}
+
+// stateAttrs return the attributes of the logger of the request.
+func stateAttrs(ctx context.Context, id string) []slog.Attr {
+	attrs := []slog.Attr{slog.String("request_id", id)}
+	if sc := tracing.SpanContextFromContext(ctx); sc.IsValid() {
+		attrs = append(attrs, slog.String("trace_id", sc.TraceID.String()))
+	}
+	return attrs
+}
@@ -0,0 +1,112 @@
+package webhandler
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNoTenant is the error of the request that does not have a tenant.
+	ErrNoTenant = errors.New("request does not have a tenant")
+	// ErrTenantMismatch is the error of the request that has different tenant on the
+	// header, subdomain or token claim.
+	ErrTenantMismatch = errors.New("request tenant does not match")
+)
+
+// NewContextWithTenant return a new context with the tenant ID, DBFromContext and
+// ReaderFromContext of the context is scoped to the tenant.
+func NewContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey, tenant)
+}
+
+// TenantFromContext return the tenant ID, false if ctx does not have a tenant.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantContextKey).(string)
+	return tenant, ok && tenant != ""
+}
+
+// TenantResolver is a WebHandler that put the tenant of the request on the context,
+// the request without tenant is rejected with 401 and the request that has different
+// tenant on the sources is rejected with 403.
+type TenantResolver struct {
+	// Header is the request header of the tenant ID, e.g. X-Tenant-ID.
+	Header string
+	// Domain resolve the tenant from the subdomain of the host, the tenant of
+	// acme.example.com is acme for the Domain example.com.
+	Domain string
+	// Claim return the tenant claim of the verified token of the request, it return
+	// an error if the token is not valid.
+	Claim func(r *http.Request) (string, error)
+}
+
+// Handle implement WebHandler.
+func (tr TenantResolver) Handle(w http.ResponseWriter, r *http.Request) Response {
+	var res Response
+	tenant, err := tr.Resolve(r)
+	if err == ErrTenantMismatch {
+		res.Error(err, http.StatusForbidden)
+		return res
+	}
+	if err != nil {
+		res.Error(err, http.StatusUnauthorized)
+		return res
+	}
+	res.Ctx = NewContextWithTenant(r.Context(), tenant)
+	return res
+}
+
+// Resolve return the tenant of the request from the Claim, Header and Domain, every
+// source that is set must have the same tenant. When the Claim is set the tenant is
+// the claim, the token without the claim does not have a tenant and the Header and
+// Domain only must match it.
+func (tr TenantResolver) Resolve(r *http.Request) (string, error) {
+	var tenants []string
+	if tr.Header != "" {
+		tenants = append(tenants, r.Header.Get(tr.Header))
+	}
+	if tr.Domain != "" {
+		tenants = append(tenants, subdomain(r.Host, tr.Domain))
+	}
+	var tenant string
+	if tr.Claim != nil {
+		claim, err := tr.Claim(r)
+		if err != nil {
+			return "", err
+		}
+		if tenant = strings.TrimSpace(claim); tenant == "" {
+			return "", ErrNoTenant
+		}
+	}
+	for _, t := range tenants {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if tenant != "" && t != tenant {
+			return "", ErrTenantMismatch
+		}
+		tenant = t
+	}
+	if tenant == "" {
+		return "", ErrNoTenant
+	}
+	return tenant, nil
+}
+
+// subdomain return the first label of the host under the domain, empty if the host
+// is not under the domain.
+func subdomain(host, domain string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	suffix := "." + strings.ToLower(strings.TrimPrefix(domain, "."))
+	if !strings.HasSuffix(host, suffix) {
+		return ""
+	}
+	labels := strings.Split(strings.TrimSuffix(host, suffix), ".")
+	return labels[len(labels)-1]
+}
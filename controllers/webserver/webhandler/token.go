@@ -0,0 +1,81 @@
+package webhandler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is the error of the bearer token that is missing, malformed,
+// expired or has an invalid signature.
+var ErrInvalidToken = errors.New("invalid bearer token")
+
+// BearerClaim return the TenantResolver Claim that verify the HS256 JWT bearer token
+// of the request with the secret and return the string claim of the token. The sub
+// claim is the principal of the request, see SetPrincipal.
+func BearerClaim(secret []byte, claim string) func(r *http.Request) (string, error) {
+	return func(r *http.Request) (string, error) {
+		auth := r.Header.Get("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			return "", ErrInvalidToken
+		}
+		claims, err := verifyHS256(strings.TrimSpace(auth[7:]), secret, time.Now())
+		if err != nil {
+			return "", err
+		}
+		if sub, ok := claims["sub"].(string); ok {
+			SetPrincipal(r.Context(), sub)
+		}
+		v, ok := claims[claim].(string)
+		if !ok {
+			return "", fmt.Errorf("%w: claim %s is not a string", ErrInvalidToken, claim)
+		}
+		return v, nil
+	}
+}
+
+// verifyHS256 verify the signature and the expiry of the token and return its claims.
+func verifyHS256(token string, secret []byte, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, ErrInvalidToken
+	}
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if exp, ok := claims["exp"].(float64); ok && now.Unix() >= int64(exp) {
+		return nil, fmt.Errorf("%w: token is expired", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// decodeSegment decode the base64url JSON segment of the token.
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
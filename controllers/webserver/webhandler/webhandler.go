@@ -1,48 +1,42 @@
 package webhandler
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"github.com/riyan/apiatex/controllers/dbaccess"
+	"github.com/riyan/apiatex/controllers/tracing"
 )
 
 var (
 	// ErrCtxNoDB is error returned by DBFromContext when ctx does not have a *sql.DB.
 	ErrCtxNoDB = errors.New("ctx does not have a db")
 )
-var (
-	//Debug set to the true to log more detail error message.
-	//Set the debug only one when the server start.
-	debug *bool
-)
-
-// DebugOn  call this will make response to the http client and log record with more
-// detailed information.Call multiple time on this will panic.
-func DebugOn() {
-	if debug != nil {
-		panic("DebugOn called multiple time")
-	}
-	dbg := true
-	debug = &dbg
-}
-
 var httpErrorMessage = map[int]string{
-	http.StatusNoContent:           "204 no content",
-	http.StatusBadRequest:          "400 bad request",
-	http.StatusUnauthorized:        "401 unathorize",
-	http.StatusForbidden:           "403 forbidden",
-	http.StatusNotFound:            "404 page not found",
-	http.StatusMethodNotAllowed:    "405 method not allowed",
-	http.StatusRequestTimeout:      "408 request timeout",
-	http.StatusInternalServerError: "500 internal server error",
-	http.StatusServiceUnavailable:  "503 service unavailable",
+	http.StatusNoContent:            "204 no content",
+	http.StatusBadRequest:           "400 bad request",
+	http.StatusUnauthorized:         "401 unathorize",
+	http.StatusForbidden:            "403 forbidden",
+	http.StatusNotFound:             "404 page not found",
+	http.StatusMethodNotAllowed:     "405 method not allowed",
+	http.StatusNotAcceptable:        "406 not acceptable",
+	http.StatusRequestTimeout:       "408 request timeout",
+	http.StatusConflict:             "409 conflict",
+	http.StatusUnsupportedMediaType: "415 unsupported media type",
+	http.StatusUnprocessableEntity:  "422 unprocessable entity",
+	http.StatusInternalServerError:  "500 internal server error",
+	http.StatusServiceUnavailable:   "503 service unavailable",
 }
 
 func httpErrMessage(code int) string {
@@ -60,12 +54,18 @@ type httpError struct {
 }
 
 func (h httpError) Error() string {
-	if debug != nil && h.err != nil {
-		return h.err.Error()
-	}
 	return h.msg
 }
 
+// Format print the original error with %+v, it is used by the debug log.
+func (h httpError) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') && h.err != nil {
+		fmt.Fprintf(s, "%s: %+v", h.msg, h.err)
+		return
+	}
+	io.WriteString(s, h.msg)
+}
+
 func newHttpError(err error, code int) error {
 	he := httpError{
 		code: code,
@@ -83,6 +83,12 @@ type contextKey int
 
 const (
 	dbContextKey contextKey = iota
+	sessionContextKey
+	tenantContextKey
+	observerContextKey
+	logContextKey
+	txContextKey
+	stmtCacheContextKey
 )
 
 // NewContextWithDB return a new context with the *sql.DB.
@@ -91,13 +97,101 @@ func NewContextWithDB(ctx context.Context, db *sql.DB) context.Context {
 	return ctx
 }
 
+// NewContextWithSession return a new context with the primary *sql.DB of the session
+// and the session for ReaderFromContext.
+func NewContextWithSession(ctx context.Context, s *dbaccess.Session) context.Context {
+	ctx = NewContextWithDB(ctx, s.Primary())
+	return context.WithValue(ctx, sessionContextKey, s)
+}
+
+// NewContextWithStmtCache return a new context with the statement cache of the primary
+// *sql.DB, DBFromContext use the cached statements.
+func NewContextWithStmtCache(ctx context.Context, c *dbaccess.StmtCache) context.Context {
+	return context.WithValue(ctx, stmtCacheContextKey, c)
+}
+
+// NewContextWithTx return a new context with the transaction, DBFromContext and
+// ReaderFromContext of the context return the tx as is, so the handler run inside the
+// transaction of the caller. The tx should be from the DBFromContext of ctx, so it is
+// already observed and scoped to the tenant.
+func NewContextWithTx(ctx context.Context, tx dbaccess.DBExecer) context.Context {
+	return context.WithValue(ctx, txContextKey, tx)
+}
+
+// TxFromContext return the transaction of NewContextWithTx, false if ctx does not have
+// a transaction.
+func TxFromContext(ctx context.Context) (dbaccess.DBExecer, bool) {
+	tx, ok := ctx.Value(txContextKey).(dbaccess.DBExecer)
+	return tx, ok
+}
+
 // DBFromContext return an error ErrCtxNoDB ,if ctx does not have *sql.DB.
-func DBFromContext(ctx context.Context) (*sql.DB, error) {
+// The *sql.DB is the primary database, if ctx has a session the next
+// ReaderFromContext queries is also sent to the primary to read the write.
+// If ctx has a tenant the DBExecer is scoped to the tenant.
+// If ctx has a transaction (NewContextWithTx) it is returned instead.
+func DBFromContext(ctx context.Context) (dbaccess.DBExecer, error) {
+	if tx, ok := TxFromContext(ctx); ok {
+		return tx, nil
+	}
+	db, ok := ctx.Value(dbContextKey).(*sql.DB)
+	if !ok {
+		return nil, ErrCtxNoDB
+	}
+	if s, ok := ctx.Value(sessionContextKey).(*dbaccess.Session); ok {
+		s.Stick()
+	}
+	if c, ok := ctx.Value(stmtCacheContextKey).(*dbaccess.StmtCache); ok {
+		return scope(ctx, c), nil
+	}
+	return scope(ctx, db), nil
+}
+
+// ReaderFromContext return the DBExecer for read only query, it is the session if
+// ctx has one or the *sql.DB. If ctx has a tenant the DBExecer is scoped to the tenant.
+// If ctx has a transaction it is returned, so the query read the write of the transaction.
+func ReaderFromContext(ctx context.Context) (dbaccess.DBExecer, error) {
+	if tx, ok := TxFromContext(ctx); ok {
+		return tx, nil
+	}
+	if s, ok := ctx.Value(sessionContextKey).(*dbaccess.Session); ok {
+		return scope(ctx, s), nil
+	}
 	db, ok := ctx.Value(dbContextKey).(*sql.DB)
 	if !ok {
 		return nil, ErrCtxNoDB
 	}
-	return db, nil
+	if c, ok := ctx.Value(stmtCacheContextKey).(*dbaccess.StmtCache); ok {
+		return scope(ctx, c), nil
+	}
+	return scope(ctx, db), nil
+}
+
+// NewContextWithObserver return a new context with the observer of the queries of
+// the DBFromContext and ReaderFromContext.
+func NewContextWithObserver(ctx context.Context, o dbaccess.Observer) context.Context {
+	return context.WithValue(ctx, observerContextKey, o)
+}
+
+// scope return the db observed by the observer of ctx and scoped to the tenant of ctx,
+// the queries is traced as the child of the span of ctx. The QueryEvent has ctx, so the
+// query is logged with the request ID.
+func scope(ctx context.Context, db dbaccess.DBExecer) dbaccess.DBExecer {
+	if o, ok := ctx.Value(observerContextKey).(dbaccess.Observer); ok {
+		db = dbaccess.WithObserver(db, dbaccess.ObserverFunc(func(e dbaccess.QueryEvent) {
+			if e.Ctx == nil {
+				e.Ctx = ctx
+			}
+			o.ObserveQuery(e)
+		}))
+	}
+	if tracing.SpanFromContext(ctx) != nil {
+		db = dbaccess.WithObserver(db, tracing.QueryObserver(ctx))
+	}
+	if tenant, ok := TenantFromContext(ctx); ok {
+		return dbaccess.WithTenant(db, tenant)
+	}
+	return db
 }
 
 // Response is response from handler sent to the client.
@@ -110,8 +204,13 @@ type Response struct {
 	Ctx context.Context `json:"-"`
 	// ErrMessage will be set when Error method is called, do not set it directly.
 	ErrMessage string `json:"err"`
-	// Cursor is query state to get the bext data from the database.
+	// Cursor is query state to get the bext data from the database, it is left out of
+	// the JSON when it is zero.
 	Cursor dbaccess.Cursor `json:"cursor"`
+	// Page is set when the data fetched using page pagination.
+	Page *dbaccess.Page `json:"page,omitempty"`
+	// Gzip compress the response when the request accept the gzip encoding.
+	Gzip bool `json:"-"`
 	//Err is wrapped error.
 	err           error
 	Data          interface{} `json:"data"`
@@ -121,6 +220,20 @@ type Response struct {
 	responseDur   time.Duration
 }
 
+// MarshalJSON implement json.Marshaler, the zero Cursor is left out so the client
+// does not fetch again when there is no next records set.
+func (res Response) MarshalJSON() ([]byte, error) {
+	type response Response
+	v := struct {
+		response
+		Cursor *dbaccess.Cursor `json:"cursor,omitempty"`
+	}{response: response(res)}
+	if !res.Cursor.IsZero() {
+		v.Cursor = &res.Cursor
+	}
+	return json.Marshal(v)
+}
+
 // Error wrap the err, so it can print stack trace when debug is on.
 // IF status code is http.StatusOK (200)
 func (res *Response) Error(err error, code int) {
@@ -135,17 +248,71 @@ func (res *Response) Error(err error, code int) {
 	res.err = errors.Wrap(err, "http error")
 }
 
-func (res Response) write(w http.ResponseWriter, r *http.Request) error {
+// Status return the status code of the error of the response, http.StatusOK if the
+// response does not have an error or the error is an app error.
+func (res Response) Status() int {
+	if he, ok := errors.Cause(res.err).(httpError); ok {
+		return he.code
+	}
+	return http.StatusOK
+}
+
+// write write the response with the Encoder of the request, the response is JSON
+// if the request does not select an Encoder.
+func (res Response) write(w http.ResponseWriter, r *http.Request, encoders []Encoder) (err error) {
 	if res.err != nil {
 		if he, ok := errors.Cause(res.err).(httpError); ok {
+			res.closeData()
 			http.Error(w, he.msg, he.code)
 			return nil
 		}
 	}
+	e, ok := negotiate(r, encoders)
+	if !ok {
+		res.closeData()
+		http.Error(w, httpErrMessage(http.StatusNotAcceptable), http.StatusNotAcceptable)
+		return nil
+	}
+	if res.Gzip && acceptGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Add("Vary", "Accept-Encoding")
+		zw := gzip.NewWriter(w)
+		defer func() {
+			if cerr := zw.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = gzipWriter{ResponseWriter: w, zw: zw}
+	}
+	if e != nil {
+		return res.encode(w, r, e)
+	}
 	return res.json(w)
 }
 
+// closeData close the TableIterator of the Data that is not written.
+func (res Response) closeData() {
+	if it, ok := res.Data.(TableIterator); ok {
+		it.Close()
+	}
+}
+
+// TableIterator is Response.Data that is written to the client one record at a time
+// instead of marshaling all the records at once, *dbaccess.Rows implement TableIterator.
+// The iterator is closed after written, and the cursor on the response is the Cursor
+// of the iterator.
+type TableIterator interface {
+	Next() bool
+	Table() dbaccess.Table
+	Err() error
+	Close() error
+	Cursor() dbaccess.Cursor
+}
+
 func (res Response) json(w http.ResponseWriter) error {
+	if it, ok := res.Data.(TableIterator); ok {
+		return res.streamJSON(w, it)
+	}
 	b, err := json.Marshal(res)
 	if err != nil {
 		http.Error(w, "could not marshal response", http.StatusInternalServerError)
@@ -156,35 +323,173 @@ func (res Response) json(w http.ResponseWriter) error {
 	return err
 }
 
+// streamJSON write the records from the iterator as the data array, the err is written
+// after the data so the error happen in the middle of the iteration can be reported.
+func (res Response) streamJSON(w http.ResponseWriter, it TableIterator) error {
+	defer it.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`{"data":[`)
+	var err error
+	for n := 0; it.Next(); n++ {
+		var b []byte
+		if b, err = json.Marshal(it.Table()); err != nil {
+			break
+		}
+		if n > 0 {
+			bw.WriteByte(',')
+		}
+		bw.Write(b)
+	}
+	if err == nil {
+		err = it.Err()
+	}
+	errMessage := res.ErrMessage
+	if err != nil && errMessage == "" {
+		errMessage = err.Error()
+	}
+	bw.WriteString(`],"err":`)
+	b, _ := json.Marshal(errMessage)
+	bw.Write(b)
+	if c := it.Cursor(); !c.IsZero() {
+		bw.WriteString(`,"cursor":`)
+		if b, cerr := json.Marshal(c); cerr == nil {
+			bw.Write(b)
+		} else {
+			bw.WriteString(`""`)
+		}
+	}
+	if res.Page != nil {
+		bw.WriteString(`,"page":`)
+		b, _ = json.Marshal(res.Page)
+		bw.Write(b)
+	}
+	bw.WriteString("}")
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// RequestInfo is the request observed by the RequestObserver.
+type RequestInfo struct {
+	// Route is the pattern the handlers is registered on.
+	Route  string
+	Method string
+	// Status is the status code and Bytes is the size of the response body.
+	Status int
+	Bytes  int64
+	// HandleDur is the duration of the handlers and ResponseDur is the duration to
+	// write the response.
+	HandleDur   time.Duration
+	ResponseDur time.Duration
+	// Err is the error of the handlers.
+	Err error
+}
+
+// RequestObserver observe the request after the response is written.
+type RequestObserver interface {
+	ObserveRequest(r *http.Request, info RequestInfo)
+}
+
+// Env is the resources shared by the handlers, the handlers of Env.New get the DB
+// (or the Router session) from the request context.
+type Env struct {
+	// DB is the database of the handlers.
+	DB *sql.DB
+	// Router replace the DB, every request has its own session of the router.
+	Router *dbaccess.Router
+	// StmtCache is the prepared statements of the DB or the primary of the Router, the
+	// queries of DBFromContext (and ReaderFromContext without Router) use it.
+	StmtCache *dbaccess.StmtCache
+	// Debug log the error with more detailed information.
+	Debug bool
+	// Logger is where the request is logged, slog.Default if it is nil.
+	Logger Logger
+	// LogSample log one of every LogSample successful requests, every request is
+	// logged if it is less than 2. The failed request is always logged.
+	LogSample int
+	// RedactParams is the query parameters redacted on the access log,
+	// DefaultRedactParams if it is nil.
+	RedactParams []string
+	// Route is the pattern the handlers is registered on.
+	Route string
+	// Observer observe every request.
+	Observer RequestObserver
+	// QueryObserver observe the queries of the DBFromContext and ReaderFromContext.
+	QueryObserver dbaccess.Observer
+	// Tracer trace the request with a span for every handler and every query, the
+	// trace continue the traceparent of the request.
+	Tracer *tracing.Tracer
+	// Encoders is the formats of the response other than JSON selected by the format
+	// query parameter or the Accept header, DefaultEncoders if it is nil.
+	Encoders []Encoder
+}
+
 // New allocate a new http.Handler, if the handlers is more the one new will chain the
 // handles as one http.Handler, it call sequentially from the beginning (index 0) to the
 // end, when handler set Handled or call Error method on Reponse it will break the sequence
 // (will not call the next handler).IF there is no handlers new will panic.
+// The handlers of New does not have resources, see Env.New.
 func New(handlers ...WebHandler) http.Handler {
+	return Env{}.New(handlers...)
+}
+
+// New is like New with the resources of env bound to the handlers.
+func (env Env) New(handlers ...WebHandler) http.Handler {
 	if len(handlers) == 0 {
 		panic("no webhandler provided")
 	}
-	wh := webHandler{handlers: handlers}
+	if env.Logger == nil {
+		env.Logger = slog.Default()
+	}
+	if env.Encoders == nil {
+		env.Encoders = DefaultEncoders
+	}
+	wh := webHandler{handlers: handlers, env: env}
+	if env.LogSample > 1 {
+		wh.sampler = &sampler{n: uint64(env.LogSample)}
+	}
 	return wh
 }
 
 type webHandler struct {
 	handlers []WebHandler
-}
-
-var handlerDB *sql.DB
-
-//RegisterDB register the dabatase use as resource for the handler.
-func RegisterDB(db *sql.DB) {
-	if handlerDB != nil {
-		panic("db already regitered on webhandler")
-	}
-	handlerDB = db
+	env      Env
+	sampler  *sampler
 }
 
 func (wh webHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var res Response
-	res.Ctx = NewContextWithDB(r.Context(), handlerDB)
+	ctx := r.Context()
+	switch {
+	case wh.env.Router != nil:
+		ctx = NewContextWithSession(ctx, wh.env.Router.Session())
+	case wh.env.DB != nil:
+		ctx = NewContextWithDB(ctx, wh.env.DB)
+	}
+	if wh.env.StmtCache != nil {
+		ctx = NewContextWithStmtCache(ctx, wh.env.StmtCache)
+	}
+	if wh.env.QueryObserver != nil {
+		ctx = NewContextWithObserver(ctx, wh.env.QueryObserver)
+	}
+	var span *tracing.Span
+	if wh.env.Tracer != nil {
+		ctx, span = wh.env.Tracer.Start(tracing.Extract(ctx, r.Header), r.Method+" "+wh.env.Route,
+			tracing.WithKind(tracing.SpanKindServer), tracing.WithAttributes(
+				tracing.String("http.method", r.Method),
+				tracing.String("http.route", wh.env.Route),
+				tracing.String("http.target", r.URL.Path),
+			))
+	}
+	state := &requestState{id: requestID(r)}
+	state.logger = requestLogger(wh.env.Logger, stateAttrs(ctx, state.id)...)
+	ctx = newContextWithState(ctx, state)
+	w.Header().Set(RequestIDHeader, state.id)
+	res.Ctx = ctx
+	sw := &statusWriter{ResponseWriter: w}
+	w = sw
 	start := time.Now()
 	for _, handler := range wh.handlers {
 		if res.Handled || res.err != nil {
@@ -193,39 +498,112 @@ func (wh webHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if res.Ctx != nil {
 			r = r.WithContext(res.Ctx)
 		}
-		res = handler.Handle(w, r)
+		res = wh.handle(handler, span, w, r)
 	}
 	res.handleStart = start
 	res.responseStart = time.Now()
 	res.handleDur = res.responseStart.Sub(res.handleStart)
 	var err error
-	if !res.Handled {
-		err = res.write(w, r)
+	if res.Handled {
+		res.closeData()
+	} else {
+		_, ws := wh.env.Tracer.Start(tracing.ContextWithSpan(r.Context(), span), "webhandler.write")
+		err = res.write(w, r, wh.env.Encoders)
+		ws.SetError(err)
+		ws.End()
 	}
 	res.responseDur = time.Since(res.responseStart)
-	wh.log(r, res, err)
+	if sw.done != nil {
+		sw.done(err)
+	}
+	if span != nil {
+		span.SetAttributes(tracing.Int("http.status_code", int64(sw.status())))
+		if sw.status() >= http.StatusInternalServerError {
+			span.SetError(res.err)
+		}
+		span.End()
+	}
+	wh.accessLog(r, state, sw, res, err)
+	if wh.env.Observer != nil {
+		wh.env.Observer.ObserveRequest(r, RequestInfo{
+			Route:       wh.env.Route,
+			Method:      r.Method,
+			Status:      sw.status(),
+			Bytes:       sw.bytes,
+			HandleDur:   res.handleDur,
+			ResponseDur: res.responseDur,
+			Err:         res.err,
+		})
+	}
 }
 
-func (wh webHandler) log(r *http.Request, res Response, err error) {
-	if debug != nil {
-		wh.logDebug(r, res, err)
-		return
+// handle call the handler with its own span, the span is the child of the request
+// span and not of the span of the previous handler.
+func (wh webHandler) handle(handler WebHandler, span *tracing.Span, w http.ResponseWriter, r *http.Request) Response {
+	if span == nil {
+		return handler.Handle(w, r)
 	}
-	if err != nil {
-		glog.Errorf("writeErr:%s method:%s url:%s hs:%s hd:%s rs:%s rd:%s rerr:%v", err.Error(), r.Method, r.URL,
-			res.handleStart, res.handleDur, res.responseStart, res.responseDur, res.err)
-	} else {
-		glog.Infof("method:%s url:%s hs:%s hd:%s rs:%s rd:%s rerr:%v", r.Method, r.URL,
-			res.handleStart, res.handleDur, res.responseStart, res.responseDur, res.err)
+	name := strings.TrimPrefix(fmt.Sprintf("%T", handler), "*")
+	ctx, hs := wh.env.Tracer.Start(tracing.ContextWithSpan(r.Context(), span), name)
+	res := handler.Handle(w, r.WithContext(ctx))
+	hs.SetError(res.err)
+	hs.End()
+	return res
+}
+
+// statusWriter record the status code and the size of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+	// capture copy the body up to captureMax bytes, truncated is set if the body is
+	// larger. It is set by Idempotency.
+	capture    *bytes.Buffer
+	captureMax int
+	truncated  bool
+	// done is called with the write error after the response is written.
+	done func(err error)
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.code == 0 {
+		sw.code = code
 	}
+	sw.ResponseWriter.WriteHeader(code)
 }
 
-func (wh webHandler) logDebug(r *http.Request, res Response, err error) {
-	if err != nil {
-		glog.Errorf("writeErr:%s method:%s url:%s hs:%s hd:%s rs:%s rd:%s rerr:%+v", err.Error(), r.Method, r.URL,
-			res.handleStart, res.handleDur, res.responseStart, res.responseDur, res.err)
-	} else {
-		glog.Infof("method:%s url:%s hs:%s hd:%s rs:%s rd:%s rerr:%+v", r.Method, r.URL,
-			res.handleStart, res.handleDur, res.responseStart, res.responseDur, res.err)
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.code == 0 {
+		sw.code = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += int64(n)
+	if sw.capture != nil && !sw.truncated {
+		if sw.capture.Len()+n > sw.captureMax {
+			sw.truncated = true
+		} else {
+			sw.capture.Write(b[:n])
+		}
+	}
+	return n, err
+}
+
+// Flush implement http.Flusher if the ResponseWriter implement it.
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap return the ResponseWriter for http.ResponseController.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// status return the status code written, 200 if nothing is written.
+func (sw *statusWriter) status() int {
+	if sw.code == 0 {
+		return http.StatusOK
 	}
+	return sw.code
 }
@@ -1,15 +1,31 @@
 package webhandler
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
-	"flag"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/riyan/apiatex/controllers/dbaccess"
+	"github.com/riyan/apiatex/controllers/tracing"
+	"github.com/riyan/apiatex/models"
 )
 
 type mockWH struct {
@@ -45,7 +61,6 @@ func (wh mockWH) Handle(w http.ResponseWriter, r *http.Request) Response {
 }
 
 func TestHandler(t *testing.T) {
-	setGlogFlag()
 	t.Run("only first called", func(t *testing.T) {
 		hOne := mockWH{
 			msg:     "Handler one handled the request",
@@ -188,10 +203,925 @@ func TestReponseError(t *testing.T) {
 	})
 }
 
-func setGlogFlag() {
-	if testing.Verbose() {
-		dg := true
-		debug = &dg
-		flag.Set("alsologtostderr", "true")
+type mockTable struct {
+	Code string `json:"code"`
+}
+
+func (t *mockTable) Name() string { return "mock" }
+
+func (t *mockTable) PrimaryKey() ([]string, []interface{}) {
+	return []string{"code"}, []interface{}{&t.Code}
+}
+
+func (t *mockTable) New() dbaccess.Table { return &mockTable{} }
+
+func (t *mockTable) Fields() ([]string, []interface{}) { return t.PrimaryKey() }
+
+func (t *mockTable) HasAutoIncrementField() bool { return false }
+
+type mockIterator struct {
+	data   []string
+	err    error
+	i      int
+	closed bool
+}
+
+func (it *mockIterator) Next() bool {
+	if it.i >= len(it.data) {
+		return false
+	}
+	it.i++
+	return true
+}
+
+func (it *mockIterator) Table() dbaccess.Table { return &mockTable{Code: it.data[it.i-1]} }
+
+func (it *mockIterator) Err() error { return it.err }
+
+func (it *mockIterator) Close() error {
+	it.closed = true
+	return nil
+}
+
+func (it *mockIterator) Cursor() dbaccess.Cursor {
+	if it.i == 0 {
+		return dbaccess.Cursor{OrderBy: []string{"code"}}
+	}
+	return dbaccess.Cursor{OrderBy: []string{"code"}, LastArgs: []interface{}{it.data[it.i-1]}}
+}
+
+type iterWH struct {
+	it *mockIterator
+}
+
+func (wh iterWH) Handle(w http.ResponseWriter, r *http.Request) Response {
+	res := Response{Data: wh.it}
+	switch r.URL.Query().Get("fail") {
+	case "error":
+		res.Error(errors.New("bad cursor"), http.StatusBadRequest)
+	case "handled":
+		res.Handled = true
+	}
+	return res
+}
+
+func TestResponseTableIterator(t *testing.T) {
+	testCase := []*mockIterator{
+		{data: []string{"A", "B", "C"}},
+		{data: []string{"A"}, err: errors.New("iteration error")},
+	}
+	for i, it := range testCase {
+		ts := httptest.NewServer(New(iterWH{it: it}))
+		res, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			Err    string          `json:"err"`
+			Data   []mockTable     `json:"data"`
+			Cursor dbaccess.Cursor `json:"cursor"`
+		}
+		if err = json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("tc:%d err:%v data:%s", i, err, data)
+		}
+		if len(got.Data) != len(it.data) {
+			t.Fatalf("tc:%d got data:%d want:%d", i, len(got.Data), len(it.data))
+		}
+		for j, v := range got.Data {
+			if v.Code != it.data[j] {
+				t.Errorf("tc:%d got code:%s want:%s", i, v.Code, it.data[j])
+			}
+		}
+		if it.err != nil && got.Err != it.err.Error() {
+			t.Errorf("tc:%d got err:%q want:%q", i, got.Err, it.err)
+		}
+		last := it.data[len(it.data)-1]
+		if len(got.Cursor.LastArgs) != 1 || got.Cursor.LastArgs[0] != last {
+			t.Errorf("tc:%d got cursor last args:%v want:[%s]", i, got.Cursor.LastArgs, last)
+		}
+		if !it.closed {
+			t.Errorf("tc:%d iterator is not closed", i)
+		}
+	}
+	//the iterator that is not written is closed.
+	for _, fail := range []string{"error", "handled"} {
+		it := &mockIterator{data: []string{"A"}}
+		New(iterWH{it: it}).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?fail="+fail, nil))
+		if !it.closed {
+			t.Errorf("fail:%s iterator is not closed", fail)
+		}
+	}
+}
+
+func TestContextSession(t *testing.T) {
+	primary, err := sql.Open("mysql", "root@/primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	replica, err := sql.Open("mysql", "root@/replica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	s := dbaccess.NewRouter(primary, replica).Session()
+	ctx := NewContextWithSession(context.Background(), s)
+	reader, err := ReaderFromContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader != s {
+		t.Errorf("got reader:%v want the session", reader)
+	}
+	if s.Sticky() {
+		t.Fatal("session is sticky before DBFromContext")
+	}
+	db, err := DBFromContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db != primary {
+		t.Error("DBFromContext is not the primary")
+	}
+	if !s.Sticky() {
+		t.Error("session is not sticky after DBFromContext")
+	}
+
+	ctx = NewContextWithDB(context.Background(), primary)
+	if reader, err = ReaderFromContext(ctx); err != nil || reader != primary {
+		t.Errorf("got reader:%v err:%v want the db", reader, err)
+	}
+	if _, err = ReaderFromContext(context.Background()); err != ErrCtxNoDB {
+		t.Errorf("got err:%v want:%v", err, ErrCtxNoDB)
+	}
+}
+
+type recordLogger struct {
+	lines []string
+}
+
+func (l *recordLogger) LogAttrs(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	line := level.String() + " " + msg
+	for _, a := range attrs {
+		line += " " + a.String()
+	}
+	l.lines = append(l.lines, line)
+}
+
+func TestEnv(t *testing.T) {
+	db1, err := sql.Open("mysql", "root@/db1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db1.Close()
+	db2, err := sql.Open("mysql", "root@/db2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	for _, db := range []*sql.DB{db1, db2} {
+		log := &recordLogger{}
+		h := Env{DB: db, Logger: log, Debug: true}.New(dbWH{want: db},
+			errWH{code: http.StatusBadRequest, err: errors.New("detail of the error")})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("got status code:%d want:%d body:%s", w.Code, http.StatusBadRequest, w.Body)
+		}
+		if strings.Contains(w.Body.String(), "detail") {
+			t.Errorf("got body:%q the detail is sent to the client", w.Body)
+		}
+		if len(log.lines) != 1 || !strings.Contains(log.lines[0], "detail of the error") {
+			t.Errorf("got debug log:%q want the detail of the error", log.lines)
+		}
+	}
+	log := &recordLogger{}
+	h := Env{Logger: log}.New(errWH{code: http.StatusBadRequest, err: errors.New("detail of the error")})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if len(log.lines) != 1 || strings.Contains(log.lines[0], "detail") {
+		t.Errorf("got log:%q want without the detail", log.lines)
+	}
+}
+
+//dbWH check the db of the request is the db of the Env.
+type dbWH struct {
+	want *sql.DB
+}
+
+func (wh dbWH) Handle(w http.ResponseWriter, r *http.Request) Response {
+	var res Response
+	db, err := DBFromContext(r.Context())
+	if err != nil {
+		res.Error(err, http.StatusInternalServerError)
+		return res
+	}
+	if db != wh.want {
+		res.Error(errors.New("db is not the db of the env"), http.StatusInternalServerError)
+	}
+	return res
+}
+
+func TestTenantResolver(t *testing.T) {
+	claim := func(r *http.Request) (string, error) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer acme-token":
+			return "acme", nil
+		case "Bearer empty-token":
+			//the valid token without the tenant claim.
+			return "", nil
+		}
+		return "", errors.New("invalid token")
+	}
+	open := TenantResolver{Header: "X-Tenant-ID", Domain: "example.com"}
+	claimed := open
+	claimed.Claim = claim
+	testCase := []struct {
+		tr     TenantResolver
+		host   string
+		header map[string]string
+		tenant string
+		code   int
+	}{
+		{tr: open, host: "acme.example.com", tenant: "acme"},
+		{tr: open, host: "acme.example.com:8082", tenant: "acme"},
+		{tr: open, host: "localhost", header: map[string]string{"X-Tenant-ID": "acme"}, tenant: "acme"},
+		{tr: open, host: "acme.example.com", header: map[string]string{"X-Tenant-ID": "acme"}, tenant: "acme"},
+		{tr: open, host: "localhost", code: http.StatusUnauthorized},
+		{tr: open, host: "example.com", code: http.StatusUnauthorized},
+		{tr: open, host: "other.example.com", header: map[string]string{"X-Tenant-ID": "acme"}, code: http.StatusForbidden},
+		{tr: claimed, host: "localhost", header: map[string]string{"Authorization": "Bearer acme-token"}, tenant: "acme"},
+		{tr: claimed, host: "acme.example.com", header: map[string]string{"X-Tenant-ID": "acme", "Authorization": "Bearer acme-token"}, tenant: "acme"},
+		{tr: claimed, host: "other.example.com", header: map[string]string{"Authorization": "Bearer acme-token"}, code: http.StatusForbidden},
+		{tr: claimed, host: "localhost", header: map[string]string{"X-Tenant-ID": "other", "Authorization": "Bearer acme-token"}, code: http.StatusForbidden},
+		{tr: claimed, host: "acme.example.com", header: map[string]string{"Authorization": "Bearer forged"}, code: http.StatusUnauthorized},
+		//the header and the domain can not supply the tenant of the claim.
+		{tr: claimed, host: "acme.example.com", header: map[string]string{"X-Tenant-ID": "acme"}, code: http.StatusUnauthorized},
+		{tr: claimed, host: "acme.example.com", header: map[string]string{"X-Tenant-ID": "acme", "Authorization": "Bearer empty-token"}, code: http.StatusUnauthorized},
+	}
+	for i, tc := range testCase {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = tc.host
+		for k, v := range tc.header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		New(tc.tr, tenantWH{}).ServeHTTP(w, r)
+		code := tc.code
+		if code == 0 {
+			code = http.StatusOK
+		}
+		if w.Code != code {
+			t.Errorf("tc:%d got code:%d want:%d", i, w.Code, code)
+			continue
+		}
+		if tc.tenant != "" && w.Header().Get("X-Got-Tenant") != tc.tenant {
+			t.Errorf("tc:%d got tenant:%s want:%s", i, w.Header().Get("X-Got-Tenant"), tc.tenant)
+		}
+	}
+}
+
+// tenantWH write the tenant of the context to the header.
+type tenantWH struct{}
+
+func (tenantWH) Handle(w http.ResponseWriter, r *http.Request) Response {
+	tenant, _ := TenantFromContext(r.Context())
+	w.Header().Set("X-Got-Tenant", tenant)
+	return Response{}
+}
+
+func TestCORS(t *testing.T) {
+	cors := CORS{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type"},
+		MaxAge:         time.Hour,
+	}
+	h := New(cors, mockWH{msg: "handled", handled: true})
+	testCase := []struct {
+		method, origin, origins string
+		code                    int
+		body                    string
+	}{
+		{method: "GET", origin: "https://app.example.com", origins: "https://app.example.com", code: http.StatusOK, body: "handled"},
+		{method: "GET", origin: "https://evil.example.com", code: http.StatusOK, body: "handled"},
+		{method: "GET", code: http.StatusOK, body: "handled"},
+		{method: "OPTIONS", origin: "https://app.example.com", origins: "https://app.example.com", code: http.StatusNoContent},
+		{method: "OPTIONS", origin: "https://evil.example.com", code: http.StatusNoContent},
+	}
+	for i, tc := range testCase {
+		r := httptest.NewRequest(tc.method, "/", nil)
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if tc.method == "OPTIONS" {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.code || w.Body.String() != tc.body {
+			t.Errorf("tc:%d got code:%d body:%q want:%d %q", i, w.Code, w.Body, tc.code, tc.body)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tc.origins {
+			t.Errorf("tc:%d got allow origin:%q want:%q", i, got, tc.origins)
+		}
+		preflight := tc.method == "OPTIONS" && tc.origins != ""
+		if got := w.Header().Get("Access-Control-Max-Age"); (got == "3600") != preflight {
+			t.Errorf("tc:%d got max age:%q", i, got)
+		}
+	}
+}
+
+func TestBearerClaim(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	sign := func(key []byte, alg string, claims map[string]interface{}) string {
+		h, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+		c, _ := json.Marshal(claims)
+		unsigned := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(unsigned))
+		return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	valid := map[string]interface{}{"tid": "T1", "exp": time.Now().Add(time.Hour).Unix()}
+	testCase := []struct {
+		auth   string
+		tenant string
+	}{
+		{auth: "Bearer " + sign(secret, "HS256", valid), tenant: "T1"},
+		{auth: "bearer " + sign(secret, "HS256", map[string]interface{}{"tid": "T2"}), tenant: "T2"},
+		{auth: ""},
+		{auth: "Basic dXNlcjpwYXNz"},
+		{auth: "Bearer " + sign([]byte("other secret"), "HS256", valid)},
+		{auth: "Bearer " + sign(secret, "none", valid)},
+		{auth: "Bearer " + sign(secret, "HS256", map[string]interface{}{"tid": "T1", "exp": time.Now().Add(-time.Minute).Unix()})},
+		{auth: "Bearer " + sign(secret, "HS256", map[string]interface{}{"tid": 1})},
+		{auth: "Bearer a.b"},
+	}
+	claim := BearerClaim(secret, "tid")
+	for i, tc := range testCase {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", tc.auth)
+		tenant, err := claim(r)
+		if tc.tenant == "" {
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("tc:%d got tenant:%q err:%v want:%v", i, tenant, err, ErrInvalidToken)
+			}
+			continue
+		}
+		if err != nil || tenant != tc.tenant {
+			t.Errorf("tc:%d got tenant:%q err:%v want:%s", i, tenant, err, tc.tenant)
+		}
+	}
+}
+
+func TestDBStats(t *testing.T) {
+	db, err := sql.Open("mysql", "root@/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(7)
+	h := New(DBStats{"primary": db, "a-replica": db})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/db", nil))
+	var got struct {
+		Data []PoolStats `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("err:%v body:%s", err, w.Body)
+	}
+	if len(got.Data) != 2 || got.Data[0].Name != "a-replica" || got.Data[1].MaxOpenConnections != 7 {
+		t.Errorf("got stats:%+v", got.Data)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/debug/db", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status code:%d want:%d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	get := func(h http.Handler, path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("err:%v body:%s", err, w.Body)
+		}
+		return w.Code, body
+	}
+	if code, body := get(New(Liveness{}), "/healthz"); code != http.StatusOK || body["status"] != "ok" {
+		t.Errorf("liveness got code:%d body:%v", code, body)
+	}
+	down, err := sql.Open("mysql", "root@tcp(127.0.0.1:1)/down")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer down.Close()
+	ok := CheckerFunc(func(ctx context.Context) error { return nil })
+	slow := CheckerFunc(func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	testCase := []struct {
+		checkers map[string]Checker
+		code     int
+		checks   map[string]interface{}
+	}{
+		{checkers: map[string]Checker{"ok": ok}, code: http.StatusOK, checks: map[string]interface{}{"ok": "ok"}},
+		{checkers: map[string]Checker{"ok": ok, "slow": slow}, code: http.StatusServiceUnavailable,
+			checks: map[string]interface{}{"ok": "ok", "slow": "timeout"}},
+		{checkers: map[string]Checker{"db": PingDB}, code: http.StatusServiceUnavailable},
+	}
+	for i, tc := range testCase {
+		h := Env{DB: down}.New(Readiness{Checkers: tc.checkers, Timeout: 50 * time.Millisecond})
+		code, body := get(h, "/readyz")
+		if code != tc.code {
+			t.Errorf("tc:%d got code:%d want:%d body:%v", i, code, tc.code, body)
+		}
+		checks, _ := body["checks"].(map[string]interface{})
+		if tc.checks != nil && !reflect.DeepEqual(checks, tc.checks) {
+			t.Errorf("tc:%d got checks:%v want:%v", i, checks, tc.checks)
+		}
+		if tc.checks == nil && (checks["db"] == "ok" || checks["db"] == nil) {
+			t.Errorf("tc:%d got checks:%v want db error", i, checks)
+		}
+	}
+	info := BuildInfo{Version: "v1.0.0", Commit: "abc"}
+	if code, body := get(New(Version(ReadBuildInfo(info))), "/version"); code != http.StatusOK ||
+		body["version"] != "v1.0.0" || body["commit"] != "abc" || body["go_version"] == "" {
+		t.Errorf("version got code:%d body:%v", code, body)
+	}
+}
+
+//recordObserver record the observed requests.
+type recordObserver struct {
+	infos []RequestInfo
+}
+
+func (o *recordObserver) ObserveRequest(r *http.Request, info RequestInfo) {
+	o.infos = append(o.infos, info)
+}
+
+//queryWH respond with the result of a query on the db of the request.
+type queryWH struct{}
+
+func (queryWH) Handle(w http.ResponseWriter, r *http.Request) Response {
+	var res Response
+	db, err := DBFromContext(r.Context())
+	if err != nil {
+		res.Error(err, http.StatusInternalServerError)
+		return res
+	}
+	var one int
+	if err = db.QueryRow("SELECT 1").Scan(&one); err != nil {
+		res.Error(err, http.StatusInternalServerError)
+		return res
+	}
+	res.Data = one
+	return res
+}
+
+func TestRequestObserver(t *testing.T) {
+	o := &recordObserver{}
+	var queries []dbaccess.QueryEvent
+	qo := dbaccess.ObserverFunc(func(e dbaccess.QueryEvent) { queries = append(queries, e) })
+	db, err := sql.Open("mysql", "root@/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	env := Env{DB: db, Route: "/one", Observer: o, QueryObserver: qo}
+	w := httptest.NewRecorder()
+	env.New(queryWH{}).ServeHTTP(w, httptest.NewRequest("GET", "/one", nil))
+	env.New(errWH{code: http.StatusNotFound, err: errors.New("not found")}).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/one", nil))
+	if len(o.infos) != 2 {
+		t.Fatalf("got %d observed requests want:2", len(o.infos))
+	}
+	got := o.infos[0]
+	if got.Route != "/one" || got.Method != "GET" || got.Status != http.StatusOK ||
+		got.Bytes != int64(w.Body.Len()) || got.Err != nil {
+		t.Errorf("got info:%+v body:%d", got, w.Body.Len())
+	}
+	got = o.infos[1]
+	if got.Method != "DELETE" || got.Status != http.StatusNotFound || got.Err == nil {
+		t.Errorf("got info:%+v", got)
+	}
+	if len(queries) != 1 || queries[0].Query != "SELECT 1" {
+		t.Fatalf("got queries:%+v", queries)
+	}
+	//the query has the context of the request.
+	if queries[0].Ctx == nil || RequestIDFromContext(queries[0].Ctx) != w.Header().Get(RequestIDHeader) {
+		t.Errorf("got query ctx:%v want the request context", queries[0].Ctx)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	db, err := sql.Open("mysql", "root@/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	exp := &tracing.InMemoryExporter{}
+	env := Env{DB: db, Route: "/one", Tracer: tracing.NewTracer(exp)}
+	r := httptest.NewRequest("GET", "/one", nil)
+	r.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	env.New(mockWH{}, queryWH{}).ServeHTTP(httptest.NewRecorder(), r)
+	spans := map[string]tracing.SpanData{}
+	for _, s := range exp.Spans() {
+		spans[s.Name] = s
+	}
+	if len(spans) != 5 {
+		t.Fatalf("got spans:%+v want 5 spans", exp.Spans())
+	}
+	root, ok := spans["GET /one"]
+	if !ok || root.Parent.SpanID.String() != "00f067aa0ba902b7" ||
+		root.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("got root:%+v", root)
+	}
+	for _, name := range []string{"webhandler.mockWH", "webhandler.queryWH", "webhandler.write"} {
+		if s := spans[name]; s.Parent.SpanID != root.SpanContext.SpanID {
+			t.Errorf("span %s got parent:%v want the root span", name, s.Parent.SpanID)
+		}
+	}
+	if s := spans["dbaccess.query"]; s.Parent.SpanID != spans["webhandler.queryWH"].SpanContext.SpanID {
+		t.Errorf("got query span:%+v want the child of the queryWH span", s)
+	}
+}
+
+//principalWH log with the logger of the request and set the principal.
+type principalWH struct{}
+
+func (principalWH) Handle(w http.ResponseWriter, r *http.Request) Response {
+	SetPrincipal(r.Context(), "user-1")
+	LoggerFromContext(r.Context()).Info("handled")
+	return Response{Data: "ok"}
+}
+
+func TestAccessLog(t *testing.T) {
+	var b bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&b, nil))
+	env := Env{Logger: logger, Route: "/e/", Debug: true}
+	r := httptest.NewRequest("GET", "/e/employed?fields=name&cursor=abc&Token=t1", nil)
+	r.Header.Set(RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	env.New(principalWH{}).ServeHTTP(w, r)
+	if got := w.Header().Get(RequestIDHeader); got != "req-1" {
+		t.Errorf("got request id:%q want:req-1", got)
+	}
+	var lines []map[string]interface{}
+	dec := json.NewDecoder(&b)
+	for dec.More() {
+		var m map[string]interface{}
+		if err := dec.Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, m)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got log:%v want 2 lines", lines)
+	}
+	if lines[0]["msg"] != "handled" || lines[0]["request_id"] != "req-1" {
+		t.Errorf("got handler log:%v", lines[0])
+	}
+	access := lines[1]
+	want := map[string]interface{}{
+		"msg":        "request",
+		"level":      "INFO",
+		"request_id": "req-1",
+		"method":     "GET",
+		"route":      "/e/",
+		"path":       "/e/employed?fields=name&cursor=%5BREDACTED%5D&Token=%5BREDACTED%5D",
+		"status":     float64(200),
+		"bytes":      float64(w.Body.Len()),
+		"principal":  "user-1",
+	}
+	for k, v := range want {
+		if access[k] != v {
+			t.Errorf("got %s:%v want:%v", k, access[k], v)
+		}
+	}
+	if _, ok := access["handle_ms"].(float64); !ok {
+		t.Errorf("got access log:%v without handle_ms", access)
+	}
+
+	//the new request ID is generated for the invalid ID.
+	b.Reset()
+	r = httptest.NewRequest("GET", "/e/", nil)
+	r.Header.Set(RequestIDHeader, "has space")
+	w = httptest.NewRecorder()
+	env.New(errWH{code: http.StatusInternalServerError, err: errors.New("detail of the error")}).ServeHTTP(w, r)
+	if id := w.Header().Get(RequestIDHeader); len(id) != 32 {
+		t.Errorf("got request id:%q want a new id", id)
+	}
+	if !strings.Contains(b.String(), `"level":"ERROR"`) || !strings.Contains(b.String(), `"error_detail":"detail of the error"`) {
+		t.Errorf("got log:%s", b.String())
+	}
+
+	//one of every 3 successful requests is logged, the failed request is always logged.
+	b.Reset()
+	env.LogSample = 3
+	ok := env.New(mockWH{msg: "ok"})
+	failed := env.New(errWH{code: http.StatusInternalServerError, err: errors.New("failed")})
+	for i := 0; i < 6; i++ {
+		ok.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/e/", nil))
+		failed.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/e/", nil))
+	}
+	if info, errs := strings.Count(b.String(), `"level":"INFO"`), strings.Count(b.String(), `"level":"ERROR"`); info != 2 || errs != 6 {
+		t.Errorf("got %d info and %d error logs want 2 and 6", info, errs)
+	}
+}
+
+type encodeRecord struct {
+	Code  string   `json:"code"`
+	Name  string   `json:"name"`
+	Note  *string  `json:"note"`
+	Tags  []string `json:"tags"`
+	Score float64  `json:"score"`
+}
+
+type dataWH struct {
+	data   interface{}
+	cursor dbaccess.Cursor
+}
+
+func (wh dataWH) Handle(w http.ResponseWriter, r *http.Request) Response {
+	return Response{Data: wh.data, Cursor: wh.cursor}
+}
+
+func TestEncoders(t *testing.T) {
+	note := `say "hi", <b>`
+	data := []encodeRecord{
+		{Code: "A", Name: "Ani", Note: &note, Tags: []string{"x", "y"}, Score: 1.5},
+		{Code: "B", Name: "Budi"},
+	}
+	cursor := dbaccess.Cursor{Fields: []string{"name", "code", "note"}, Limit: 2, LastArgs: []interface{}{"B"}}
+	h := New(dataWH{data: data, cursor: cursor})
+	serve := func(target, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	//the columns of the CSV is the fields of the request in order.
+	w := serve("/e/employed?format=csv&fields=name,code,note", "")
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("got content type:%q", ct)
+	}
+	wantCSV := "name,code,note\nAni,A,\"say \"\"hi\"\", <b>\"\nBudi,B,\n"
+	if got := w.Body.String(); got != wantCSV {
+		t.Errorf("got csv:%q want:%q", got, wantCSV)
+	}
+	if got := w.Header().Get(NextCursorHeader); got != cursor.String() {
+		t.Errorf("got next cursor:%q want:%q", got, cursor.String())
+	}
+	wantLink := "</e/employed?cursor=" + url.QueryEscape(cursor.String()) + "&fields=" +
+		url.QueryEscape("name,code,note") + `&format=csv>; rel="next"`
+	if got := w.Header().Get("Link"); got != wantLink {
+		t.Errorf("got link:%q want:%q", got, wantLink)
+	}
+
+	//the fields of the cursor is not used without the fields of the request.
+	w = serve("/e/employed?format=csv", "")
+	if got, want := w.Body.String(), "code,name,note,tags,score\nA,Ani,\"say \"\"hi\"\", <b>\",\"[\"\"x\"\",\"\"y\"\"]\",1.5\nB,Budi,,,0\n"; got != want {
+		t.Errorf("got csv:%q want:%q", got, want)
+	}
+
+	//the Accept header select the encoder by the quality.
+	w = serve("/e/employed?fields=name,%20code,note", "application/json;q=0.5, application/x-ndjson")
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson; charset=utf-8" {
+		t.Errorf("got content type:%q", ct)
+	}
+	wantNDJSON := `{"name":"Ani","code":"A","note":"say \"hi\", \u003cb\u003e"}` + "\n" +
+		`{"name":"Budi","code":"B","note":null}` + "\n"
+	if got := w.Body.String(); got != wantNDJSON {
+		t.Errorf("got ndjson:%q want:%q", got, wantNDJSON)
+	}
+
+	w = serve("/e/employed?fields=name,code,note", "text/xml")
+	wantXML := xml.Header + "<response><data><record><name>Ani</name><code>A</code><note>say &#34;hi&#34;, &lt;b&gt;</note></record>" +
+		"<record><name>Budi</name><code>B</code><note></note></record></data><err></err></response>\n"
+	if got := w.Body.String(); got != wantXML {
+		t.Errorf("got xml:%q want:%q", got, wantXML)
+	}
+
+	//the record without projection has every field, the nested value is written as is.
+	w = httptest.NewRecorder()
+	New(dataWH{data: data[0]}).ServeHTTP(w, httptest.NewRequest("GET", "/e/employed/A?format=CSV", nil))
+	if got, want := w.Body.String(), "code,name,note,tags,score\nA,Ani,\"say \"\"hi\"\", <b>\",\"[\"\"x\"\",\"\"y\"\"]\",1.5\n"; got != want {
+		t.Errorf("got csv:%q want:%q", got, want)
+	}
+	if w.Header().Get(NextCursorHeader) != "" || w.Header().Get("Link") != "" {
+		t.Errorf("got cursor header:%v without cursor", w.Header())
+	}
+
+	//the iterator error is on the trailer.
+	it := &mockIterator{data: []string{"A", "B"}, err: errors.New("iteration error")}
+	ts := httptest.NewServer(New(iterWH{it: it}))
+	defer ts.Close()
+	res, err := http.Get(ts.URL + "?format=ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if want := "{\"code\":\"A\"}\n{\"code\":\"B\"}\n{\"err\":\"iteration error\"}\n"; string(body) != want {
+		t.Errorf("got ndjson:%q want:%q", body, want)
+	}
+	if got := res.Trailer.Get(ErrorTrailer); got != "iteration error" {
+		t.Errorf("got trailer:%q", got)
+	}
+	if !it.closed {
+		t.Error("iterator is not closed")
+	}
+
+	if w = serve("/e/employed?format=yaml", ""); w.Code != http.StatusNotAcceptable {
+		t.Errorf("got status:%d want:%d", w.Code, http.StatusNotAcceptable)
+	}
+	for _, accept := range []string{"", "*/*", "text/html", "text/csv;q=0.1, application/json"} {
+		w = serve("/e/employed", accept)
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") || w.Header().Get(NextCursorHeader) != "" {
+			t.Errorf("accept:%q got header:%v want JSON", accept, w.Header())
+		}
+	}
+}
+
+//countWH count the handled requests and respond with the count and the body, the
+//request wait for release if it is not nil.
+type countWH struct {
+	mu      sync.Mutex
+	n       int
+	code    int
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (wh *countWH) Handle(w http.ResponseWriter, r *http.Request) Response {
+	var res Response
+	wh.mu.Lock()
+	wh.n++
+	n, code := wh.n, wh.code
+	wh.mu.Unlock()
+	if wh.release != nil {
+		wh.entered <- struct{}{}
+		<-wh.release
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if code != 0 {
+		res.Error(errors.New("failed"), code)
+		return res
+	}
+	w.Header().Set("X-Count", fmt.Sprint(n))
+	res.Data = fmt.Sprintf("%d:%s", n, body)
+	return res
+}
+
+func (wh *countWH) count() int {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	return wh.n
+}
+
+func TestIdempotency(t *testing.T) {
+	db, err := sql.Open("mysql", "root@/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("CREATE DATABASE IF NOT EXISTS idempotency"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if db, err = sql.Open("mysql", "root@/idempotency?parseTime=true"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.Exec("DROP TABLE IF EXISTS " + IdempotencyTableName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(models.IdempotencyKeyTable); err != nil {
+		t.Fatal(err)
+	}
+	tr := TenantResolver{Header: "X-Tenant-ID"}
+	wh := &countWH{}
+	h := Env{DB: db}.New(tr, Idempotency{}, wh)
+	serve := func(method, key, tenant, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/e/employed", strings.NewReader(body))
+		r.Header.Set("X-Tenant-ID", tenant)
+		if key != "" {
+			r.Header.Set(IdempotencyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	first := serve("POST", "k1", "acme", `{"id":"1"}`)
+	if first.Code != http.StatusOK || wh.count() != 1 {
+		t.Fatalf("got code:%d count:%d", first.Code, wh.count())
+	}
+	//the retry is replayed without calling the handler.
+	w := serve("POST", "k1", "acme", `{"id":"1"}`)
+	if wh.count() != 1 || w.Code != first.Code || w.Body.String() != first.Body.String() ||
+		w.Header().Get("X-Count") != "1" || w.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("got code:%d body:%s header:%v count:%d want the first response", w.Code, w.Body, w.Header(), wh.count())
+	}
+	if w.Header().Get(RequestIDHeader) == first.Header().Get(RequestIDHeader) {
+		t.Error("replay has the request ID of the first request")
+	}
+	if w = serve("POST", "k1", "acme", `{"id":"2"}`); w.Code != http.StatusUnprocessableEntity || wh.count() != 1 {
+		t.Errorf("different body got code:%d count:%d want 422", w.Code, wh.count())
+	}
+	if w = serve("PUT", "k1", "acme", `{"id":"1"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("different method got code:%d want 422", w.Code)
+	}
+	//the key is scoped to the tenant.
+	if w = serve("POST", "k1", "other", `{"id":"1"}`); w.Code != http.StatusOK || wh.count() != 2 {
+		t.Errorf("other tenant got code:%d count:%d", w.Code, wh.count())
+	}
+	//the request without key and the safe method is not replayed.
+	serve("POST", "", "acme", `{"id":"1"}`)
+	serve("GET", "k1", "acme", "")
+	if wh.count() != 4 {
+		t.Errorf("got count:%d want:4", wh.count())
+	}
+	long := strings.Repeat("k", 256)
+	if w = serve("POST", long, "acme", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("long key got code:%d want 400", w.Code)
+	}
+	h = Env{DB: db}.New(tr, Idempotency{MaxRequest: 4}, wh)
+	if w = serve("POST", "k6", "acme", "12345"); w.Code != http.StatusRequestEntityTooLarge || wh.count() != 4 {
+		t.Errorf("large body got code:%d count:%d want 413", w.Code, wh.count())
+	}
+	h = Env{DB: db}.New(tr, Idempotency{}, wh)
+
+	//the failed response is not kept, so the request can be retried.
+	wh.code = http.StatusInternalServerError
+	if w = serve("POST", "k2", "acme", "x"); w.Code != http.StatusInternalServerError {
+		t.Errorf("got code:%d want 500", w.Code)
+	}
+	wh.code = 0
+	if w = serve("POST", "k2", "acme", "x"); w.Code != http.StatusOK || w.Header().Get(ReplayedHeader) != "" {
+		t.Errorf("retry of the failed request got code:%d header:%v", w.Code, w.Header())
+	}
+
+	//the concurrent request of the key is rejected until the first is finished.
+	wh.entered, wh.release = make(chan struct{}), make(chan struct{})
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve("POST", "k3", "acme", "y") }()
+	<-wh.entered
+	if w = serve("POST", "k3", "acme", "y"); w.Code != http.StatusConflict {
+		t.Errorf("concurrent request got code:%d want 409", w.Code)
+	}
+	close(wh.release)
+	first = <-done
+	wh.entered, wh.release = nil, nil
+	if w = serve("POST", "k3", "acme", "y"); w.Body.String() != first.Body.String() || w.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("got body:%s want:%s", w.Body, first.Body)
+	}
+
+	//the key of the crashed request is claimed again after the lease.
+	stale := &idempotencyRecord{TenantId: "acme", Key: "k5", Method: "POST", Path: "/e/employed",
+		Fingerprint: fingerprint(httptest.NewRequest("POST", "/e/employed", nil), []byte("s")),
+		CreatedAt:   time.Now().UTC().Add(-time.Second), ExpiresAt: time.Now().UTC().Add(time.Hour)}
+	if err = dbaccess.Insert(db, stale); err != nil {
+		t.Fatal(err)
+	}
+	if w = serve("POST", "k5", "acme", "s"); w.Code != http.StatusConflict {
+		t.Errorf("key in the lease got code:%d want 409", w.Code)
+	}
+	h = Env{DB: db}.New(tr, Idempotency{Lease: time.Millisecond}, wh)
+	n := wh.count()
+	if w = serve("POST", "k5", "acme", "s"); w.Code != http.StatusOK || wh.count() != n+1 {
+		t.Errorf("stale key got code:%d count:%d want:%d", w.Code, wh.count(), n+1)
+	}
+	if w = serve("POST", "k5", "acme", "s"); w.Header().Get(ReplayedHeader) != "true" || wh.count() != n+1 {
+		t.Errorf("got header:%v count:%d want the replay", w.Header(), wh.count())
+	}
+
+	//the expired key is handled again and purged.
+	h = Env{DB: db}.New(tr, Idempotency{TTL: time.Millisecond}, wh)
+	n = wh.count()
+	serve("POST", "k4", "acme", "z")
+	time.Sleep(5 * time.Millisecond)
+	if w = serve("POST", "k4", "acme", "z"); w.Code != http.StatusOK || wh.count() != n+2 {
+		t.Errorf("expired key got code:%d count:%d want:%d", w.Code, wh.count(), n+2)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err = (Idempotency{}).Purge(db); err != nil {
+		t.Fatal(err)
+	}
+	var left int
+	if err = db.QueryRow("SELECT COUNT(*) FROM " + IdempotencyTableName + " WHERE idem_key = 'k4'").Scan(&left); err != nil || left != 0 {
+		t.Errorf("got %d expired keys err:%v want purged", left, err)
 	}
 }
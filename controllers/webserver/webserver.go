@@ -1,7 +1,10 @@
 package webserver
 
 import (
+	"context"
+	"net"
 	"net/http"
+	"sync"
 )
 
 type mux struct {
@@ -11,7 +14,7 @@ type mux struct {
 
 func newMux() *mux {
 	hm := http.NewServeMux()
-	return &mux{ServeMux: hm}
+	return &mux{ServeMux: hm, notFound: http.NotFoundHandler()}
 }
 
 func (m *mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -30,8 +33,15 @@ func (m *mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+//Server is the http server that drain the in-flight requests on Shutdown.
 type Server struct {
 	*http.Server
+	//CertFile and KeyFile is the TLS certificate and key, the server use https if
+	//they are set.
+	CertFile string
+	KeyFile  string
+	mu       sync.Mutex
+	listener net.Listener
 }
 
 func NewServer() *Server {
@@ -42,10 +52,63 @@ func NewServer() *Server {
 	return &s
 }
 
-func (s *Server) Start() {
+//Listen listen on the Addr, Start call it if it is not called before.
+//It is useful to get the ListenAddr before Start when the port of Addr is 0.
+func (s *Server) Listen() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil {
+		return nil
+	}
+	addr := s.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.listener = ln
+	return nil
+}
 
+//ListenAddr return the address the server listen on, nil before Listen.
+func (s *Server) ListenAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
 }
 
-func (s *Server) Shutdown() {
+//Start serve the requests until Shutdown, it return nil when the server is shut down.
+func (s *Server) Start() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	ln := s.listener
+	s.mu.Unlock()
+	var err error
+	if s.CertFile != "" || s.KeyFile != "" {
+		err = s.ServeTLS(ln, s.CertFile, s.KeyFile)
+	} else {
+		err = s.Serve(ln)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
 
+//Shutdown stop accepting new requests and wait the in-flight requests to finish.
+//If ctx is done before the requests finish the connections are closed and the
+//error of ctx is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.Server.Shutdown(ctx)
+	if err != nil && ctx.Err() != nil {
+		s.Server.Close()
+	}
+	return err
 }
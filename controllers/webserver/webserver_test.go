@@ -0,0 +1,81 @@
+package webserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServerShutdown(t *testing.T) {
+	s := NewServer()
+	s.Addr = "127.0.0.1:0"
+	started := make(chan struct{})
+	s.Handler.(*mux).HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + s.ListenAddr().String()
+	errc := make(chan error, 1)
+	go func() { errc <- s.Start() }()
+
+	res, err := http.Get(url + "/notfound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("got status code:%d want:%d", res.StatusCode, http.StatusNotFound)
+	}
+
+	body := make(chan string, 1)
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		body <- string(b)
+	}()
+	<-started
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-body; got != "done" {
+		t.Errorf("got in-flight response:%q want:done", got)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("got start err:%v want nil", err)
+	}
+	if _, err := http.Get(url + "/slow"); err == nil {
+		t.Error("request after shutdown want error got nil")
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	s := NewServer()
+	s.Addr = "127.0.0.1:0"
+	started := make(chan struct{})
+	s.Handler.(*mux).HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	go http.Get("http://" + s.ListenAddr().String() + "/slow")
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got err:%v want:%v", err, context.DeadlineExceeded)
+	}
+}
@@ -11,6 +11,9 @@ import (
 
 var defaultdb, user, password, dbtest string
 
+//testTenant is the tenant of the test records.
+const testTenant = "T01"
+
 func init() {
 	dbtest = "test_dbaccess"
 	defaultdb = "mysql"
@@ -90,6 +93,12 @@ func PrepareTest() *sql.DB {
 	if _, err := db.Exec(EmployedTable); err != nil {
 		log.Fatal(err)
 	}
+	if _, err := db.Exec(EmployedHistoryTable); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Exec(DepartmentTable); err != nil {
+		log.Fatal(err)
+	}
 	// for _, ne := range number {
 	// 	if err := ne.Insert(db); err != nil {
 	// 		log.Fatal(err)
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"github.com/riyan/apiatex/controllers/dbaccess"
+)
+
+var DepartmentTable = `CREATE TABLE IF NOT EXISTS department
+(
+		id varchar(10) not null,
+    name_department varchar(100) not null,
+    tenant_id varchar(36) not null,
+    PRIMARY KEY (tenant_id, id)
+	);`
+
+type Department struct {
+	Id             string `json:"id"`
+	NameDepartment string `json:"name_department"`
+	//TenantId diisi oleh dbaccess dari tenant request
+	TenantId string `json:"-"`
+	//Employees is loaded with include employees.
+	Employees []*Employed `json:"employees,omitempty"`
+}
+
+//Name return nama table
+func (d *Department) Name() string {
+	return "department"
+}
+
+//PrimaryKey return PrimaryKey table
+func (d *Department) PrimaryKey() (fields []string, dst []interface{}) {
+	fields = []string{"id"}
+	dst = []interface{}{&d.Id}
+	return fields, dst
+}
+
+//TenantField return field tenant
+func (d *Department) TenantField() string {
+	return "tenant_id"
+}
+
+//New Membuat baru
+func (d *Department) New() dbaccess.Table {
+	return &Department{}
+}
+
+//Fields Deklarasi column yang ada di department
+func (d *Department) Fields() (fields []string, dst []interface{}) {
+	fields = []string{"id", "name_department", "tenant_id"}
+	dst = []interface{}{&d.Id, &d.NameDepartment, &d.TenantId}
+	return fields, dst
+}
+
+//HasAutoIncrementField false karna tidak ada AUTO NUMBER/SERIAL
+func (d *Department) HasAutoIncrementField() bool {
+	return false
+}
+
+//Relations relasi department dengan employed
+func (d *Department) Relations() []dbaccess.Relation {
+	return []dbaccess.Relation{
+		{
+			Name:        "employees",
+			Kind:        dbaccess.HasMany,
+			Target:      &Employed{},
+			Field:       "id",
+			TargetField: "department_id",
+			Set: func(related []dbaccess.Table) {
+				d.Employees = make([]*Employed, len(related))
+				for i, t := range related {
+					d.Employees[i] = t.(*Employed)
+				}
+			},
+		},
+	}
+}
+
+//Insert menambah data department
+func (d *Department) Insert(db dbaccess.DBExecer) error {
+	return dbaccess.Insert(db, d)
+}
+
+//Get mengambil data department
+func (d *Department) Get(db dbaccess.DBExecer, include ...string) error {
+	return dbaccess.Get(db, d, include...)
+}
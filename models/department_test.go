@@ -0,0 +1,89 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/riyan/apiatex/controllers/dbaccess"
+)
+
+func TestDepartmentRelations(t *testing.T) {
+	sdb := PrepareTest()
+	defer sdb.Close()
+	db := dbaccess.WithTenant(sdb, testTenant)
+
+	departments := []*Department{
+		{Id: "D01", NameDepartment: "Finance"},
+		{Id: "D02", NameDepartment: "Payroll"},
+		{Id: "D03", NameDepartment: "Empty"},
+	}
+	employeds := []*Employed{
+		{Id: "E01", NameEmployed: "Tony Agus", Email: "tony@atex.co.id", Phone: "0852", DepartmentId: "D01"},
+		{Id: "E02", NameEmployed: "Agus Tony", Email: "agus@atex.co.id", Phone: "0852", DepartmentId: "D02"},
+		{Id: "E03", NameEmployed: "John Doe", Email: "john@atex.co.id", Phone: "0852", DepartmentId: "D01"},
+		{Id: "E04", NameEmployed: "Doe John", Email: "doe@atex.co.id", Phone: "0852"},
+	}
+	for _, d := range departments {
+		if err := d.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, e := range employeds {
+		if err := e.Insert(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("BelongsTo", func(t *testing.T) {
+		got, _, err := dbaccess.Fetch(db, &Employed{}, dbaccess.Cursor{Include: []string{"department"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(employeds) {
+			t.Fatalf("got data:%d want:%d", len(got), len(employeds))
+		}
+		for i, g := range got {
+			e := g.(*Employed)
+			want := employeds[i]
+			if want.DepartmentId == "" {
+				if e.Department != nil {
+					t.Errorf("employed:%s got department:%v want:nil", e.Id, e.Department)
+				}
+				continue
+			}
+			if e.Department == nil || e.Department.Id != want.DepartmentId {
+				t.Errorf("employed:%s got department:%v want:%s", e.Id, e.Department, want.DepartmentId)
+			}
+		}
+	})
+
+	t.Run("HasMany", func(t *testing.T) {
+		testCase := []struct {
+			id   string
+			want []string
+		}{
+			{id: "D01", want: []string{"E01", "E03"}},
+			{id: "D02", want: []string{"E02"}},
+			{id: "D03", want: nil},
+		}
+		for _, tc := range testCase {
+			got := &Department{Id: tc.id}
+			if err := got.Get(db, "employees"); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Employees) != len(tc.want) {
+				t.Fatalf("department:%s got employees:%d want:%d", tc.id, len(got.Employees), len(tc.want))
+			}
+			for i, e := range got.Employees {
+				if e.Id != tc.want[i] {
+					t.Errorf("department:%s got employed:%s want:%s", tc.id, e.Id, tc.want[i])
+				}
+			}
+		}
+	})
+
+	t.Run("Unknown relation", func(t *testing.T) {
+		if _, _, err := dbaccess.Fetch(db, &Employed{}, dbaccess.Cursor{Include: []string{"manager"}}); err == nil {
+			t.Error("fetch unknown relation want error got nil")
+		}
+	})
+}
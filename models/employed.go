@@ -1,16 +1,38 @@
 package models
 
 import (
+	"fmt"
+	"net/mail"
+	"time"
+
 	"github.com/riyan/apiatex/controllers/dbaccess"
 )
 
-var EmployedTable = `CREATE TABLE employed
+var EmployedTable = `CREATE TABLE IF NOT EXISTS employed
+(
+		id varchar(10) not null,
+    name_employed varchar(100) not null,
+    email varchar(100) not null,
+    phone varchar(13) not null,
+    address varchar(400),
+    department_id varchar(10) not null default '',
+    tenant_id varchar(36) not null,
+    PRIMARY KEY (tenant_id, id)
+	);`
+
+//EmployedHistoryTable keep every version of the employed records.
+var EmployedHistoryTable = `CREATE TABLE IF NOT EXISTS employed_history
 (
-		id varchar(10) PRIMARY KEY,
+		id varchar(10) not null,
     name_employed varchar(100) not null,
     email varchar(100) not null,
     phone varchar(13) not null,
-    address varchar(400)
+    address varchar(400),
+    department_id varchar(10) not null default '',
+    tenant_id varchar(36) not null,
+    valid_from datetime(6) not null,
+    valid_to datetime(6) not null,
+    PRIMARY KEY (tenant_id, id, valid_from)
 	);`
 
 type Employed struct {
@@ -19,6 +41,11 @@ type Employed struct {
 	Email        string `json:"email"`
 	Phone        string `json:"phone"`
 	Address      string `json:"address"`
+	DepartmentId string `json:"department_id"`
+	//TenantId diisi oleh dbaccess dari tenant request
+	TenantId string `json:"-"`
+	//Department is loaded with include department.
+	Department *Department `json:"department,omitempty"`
 }
 
 //Name resturn nama table
@@ -26,6 +53,21 @@ func (e *Employed) Name() string {
 	return "employed"
 }
 
+//HistoryName return nama table history
+func (e *Employed) HistoryName() string {
+	return "employed_history"
+}
+
+//TenantField return field tenant
+func (e *Employed) TenantField() string {
+	return "tenant_id"
+}
+
+//SearchFields return field yang dicari dengan full-text search
+func (e *Employed) SearchFields() []string {
+	return []string{"name_employed", "email", "address"}
+}
+
 //PrimaryKey return PrimaryKey table
 func (em *Employed) PrimaryKey() (fields []string, dst []interface{}) {
 	fields = []string{"id"}
@@ -40,11 +82,58 @@ func (me *Employed) New() dbaccess.Table {
 
 // Fields Deklarei coloumn yang ada di eset
 func (em *Employed) Fields() (fields []string, dst []interface{}) {
-	fields = []string{"id", "name_employed", "email", "phone", "address"}
-	dst = []interface{}{&em.Id, &em.NameEmployed, &em.Email, &em.Phone, &em.Address}
+	fields = []string{"id", "name_employed", "email", "phone", "address", "department_id", "tenant_id"}
+	dst = []interface{}{&em.Id, &em.NameEmployed, &em.Email, &em.Phone, &em.Address, &em.DepartmentId, &em.TenantId}
 	return fields, dst
 }
 
+//Relations relasi employed dengan department
+func (em *Employed) Relations() []dbaccess.Relation {
+	return []dbaccess.Relation{
+		{
+			Name:        "department",
+			Kind:        dbaccess.BelongsTo,
+			Target:      &Department{},
+			Field:       "department_id",
+			TargetField: "id",
+			Set: func(related []dbaccess.Table) {
+				em.Department = nil
+				if len(related) != 0 {
+					em.Department = related[0].(*Department)
+				}
+			},
+		},
+	}
+}
+
+//Validate memeriksa data employed sesuai dengan kolom table employed
+func (em *Employed) Validate() error {
+	for _, f := range []struct {
+		name     string
+		value    string
+		max      int
+		required bool
+	}{
+		{"id", em.Id, 10, true},
+		{"name_employed", em.NameEmployed, 100, true},
+		{"email", em.Email, 100, true},
+		{"phone", em.Phone, 13, false},
+		{"address", em.Address, 400, false},
+		{"department_id", em.DepartmentId, 10, false},
+	} {
+		if f.required && f.value == "" {
+			return fmt.Errorf("%s must be set", f.name)
+		}
+		if len(f.value) > f.max {
+			return fmt.Errorf("%s must be at most %d characters", f.name, f.max)
+		}
+	}
+	if _, err := mail.ParseAddress(em.Email); err != nil {
+		return fmt.Errorf("email %q is not valid", em.Email)
+	}
+	return nil
+}
+
 //HeAutoIncrementField false karna tidak ada AUTO NUMBER/SERIAL
 func (em *Employed) HasAutoIncrementField() bool {
 	return false
@@ -67,6 +156,11 @@ func (em *Employed) Delete(db dbaccess.DBExecer) error {
 }
 
 //Get fungsi untuk mengambil data eset
-func (em *Employed) Get(db dbaccess.DBExecer) error {
-	return dbaccess.Get(db, em)
+func (em *Employed) Get(db dbaccess.DBExecer, include ...string) error {
+	return dbaccess.Get(db, em, include...)
+}
+
+//GetAsOf mengambil data eset yang berlaku pada waktu at
+func (em *Employed) GetAsOf(db dbaccess.DBExecer, at time.Time) error {
+	return dbaccess.GetAsOf(db, em, at)
 }
@@ -2,12 +2,16 @@ package models
 
 import (
 	"database/sql"
+	"fmt"
 	"testing"
+
+	"github.com/riyan/apiatex/controllers/dbaccess"
 )
 
 func TestEmployedCRUD(t *testing.T) {
-	db := PrepareTest()
-	defer db.Close()
+	sdb := PrepareTest()
+	defer sdb.Close()
+	db := dbaccess.WithTenant(sdb, testTenant)
 
 	data := []*Employed{
 		{
@@ -21,12 +25,12 @@ func TestEmployedCRUD(t *testing.T) {
 	fields, _ := data[0].Fields()
 	t.Run("InsertGet", func(t *testing.T) {
 		//Insert
-		tx, err := db.Begin()
+		tx, err := sdb.Begin()
 		if err != nil {
 			t.Fatal(err)
 		}
 		for i, v := range data {
-			if err := v.Insert(tx); err != nil {
+			if err := v.Insert(dbaccess.WithTenant(tx, testTenant)); err != nil {
 				t.Fatalf("insert  data:%v err:%v", v, err)
 			}
 			data[i] = v
@@ -121,3 +125,66 @@ func compareDataEmployed(t *testing.T, got, want *Employed, fields []string) {
 		}
 	}
 }
+
+func prepareBench(b *testing.B) *sql.DB {
+	db := PrepareTest()
+	for i := 0; i < 100; i++ {
+		em := &Employed{
+			Id: fmt.Sprintf("%08d", i), NameEmployed: "Tony Agus", Email: "tony@atex.co.id",
+			Phone: "08521021312", Address: "Cikupa",
+		}
+		if err := em.Insert(dbaccess.WithTenant(db, testTenant)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	return db
+}
+
+func benchmarkGet(b *testing.B, db dbaccess.DBExecer) {
+	db = dbaccess.WithTenant(db, testTenant)
+	for i := 0; i < b.N; i++ {
+		em := &Employed{Id: fmt.Sprintf("%08d", i%100)}
+		if err := em.Get(db); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkFetch(b *testing.B, db dbaccess.DBExecer) {
+	db = dbaccess.WithTenant(db, testTenant)
+	c := dbaccess.Cursor{Limit: 20}
+	for i := 0; i < b.N; i++ {
+		if _, _, err := dbaccess.Fetch(db, &Employed{}, c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEmployedGet(b *testing.B) {
+	db := prepareBench(b)
+	defer db.Close()
+	benchmarkGet(b, db)
+}
+
+func BenchmarkEmployedGetStmtCache(b *testing.B) {
+	db := prepareBench(b)
+	defer db.Close()
+	cache := dbaccess.NewStmtCache(db, 16)
+	defer cache.Close()
+	benchmarkGet(b, cache)
+}
+
+func BenchmarkEmployedFetch(b *testing.B) {
+	db := prepareBench(b)
+	defer db.Close()
+	benchmarkFetch(b, db)
+}
+
+func BenchmarkEmployedFetchStmtCache(b *testing.B) {
+	db := prepareBench(b)
+	defer db.Close()
+	cache := dbaccess.NewStmtCache(db, 16)
+	defer cache.Close()
+	benchmarkFetch(b, cache)
+}
@@ -0,0 +1,19 @@
+package models
+
+//IdempotencyKeyTable menyimpan response dari request dengan header Idempotency-Key,
+//lihat webhandler.Idempotency.
+var IdempotencyKeyTable = `CREATE TABLE IF NOT EXISTS idempotency_key
+(
+		tenant_id varchar(36) not null default '',
+    idem_key varchar(255) not null,
+    method varchar(10) not null,
+    path varchar(2048) not null,
+    fingerprint char(64) not null,
+    status int not null default 0,
+    header text,
+    body longblob,
+    created_at datetime(6) not null,
+    expires_at datetime(6) not null,
+    PRIMARY KEY (tenant_id, idem_key),
+    INDEX idempotency_key_expires_at (expires_at)
+	);`
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/riyan/apiatex/controllers/dbaccess"
+)
+
+//Migrations adalah perubahan schema database secara berurutan, tambahkan perubahan
+//baru di akhir dan jangan ubah migration yang sudah dijalankan.
+//Table dibuat dengan IF NOT EXISTS, jadi database yang dibuat sebelum migrations
+//diubah oleh AddDepartmentId dan AddTenantId.
+var Migrations = []dbaccess.Migration{
+	dbaccess.Exec(EmployedTable),
+	dbaccess.Exec(EmployedHistoryTable),
+	dbaccess.Exec(DepartmentTable),
+	func(db dbaccess.DBExecer) error {
+		return dbaccess.CreateSearchIndex(db, &Employed{})
+	},
+	dbaccess.Exec(IdempotencyKeyTable),
+	AddDepartmentId,
+	AddTenantId,
+	SeedEmployedHistory,
+}
+
+//AddDepartmentId menambah kolom department_id pada table employed yang dibuat
+//sebelum relasi department.
+func AddDepartmentId(db dbaccess.DBExecer) error {
+	for _, table := range []string{"employed", "employed_history"} {
+		columns, err := dbaccess.TableColumns(db, table)
+		if err != nil {
+			return err
+		}
+		if _, ok := columns["department_id"]; ok || len(columns) == 0 {
+			continue
+		}
+		_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN department_id varchar(10) not null default ''")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//SeedEmployedHistory membuat versi untuk employed yang dibuat sebelum history, versi
+//berlaku dari waktu migration sehingga as_of dan _diff bisa membaca record tersebut.
+func SeedEmployedHistory(db dbaccess.DBExecer) error {
+	return dbaccess.SeedHistory(db, &Employed{})
+}
+
+//tenantKeys adalah PrimaryKey dari table tenant.
+var tenantKeys = []struct {
+	table string
+	key   []string
+}{
+	{"employed", []string{"tenant_id", "id"}},
+	{"employed_history", []string{"tenant_id", "id", "valid_from"}},
+	{"department", []string{"tenant_id", "id"}},
+}
+
+//AddTenantId menambah kolom tenant_id dan mengubah PrimaryKey menjadi per tenant pada
+//table yang dibuat sebelum multi tenant. Record yang sudah ada mempunyai tenant
+//kosong, isi tenant_id dengan UPDATE supaya bisa dibaca oleh tenant.
+func AddTenantId(db dbaccess.DBExecer) error {
+	for _, tk := range tenantKeys {
+		columns, err := dbaccess.TableColumns(db, tk.table)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		if _, ok := columns["tenant_id"]; !ok {
+			_, err = db.Exec("ALTER TABLE " + tk.table + " ADD COLUMN tenant_id varchar(36) not null default ''")
+			if err != nil {
+				return err
+			}
+		}
+		if !columns["tenant_id"] {
+			_, err = db.Exec("ALTER TABLE " + tk.table + " DROP PRIMARY KEY, ADD PRIMARY KEY (" +
+				strings.Join(tk.key, ", ") + ")")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
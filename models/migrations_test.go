@@ -0,0 +1,68 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/riyan/apiatex/controllers/dbaccess"
+)
+
+func TestMigrationsUpgrade(t *testing.T) {
+	db := PrepareTest()
+	defer db.Close()
+	//schema sebelum migrations: employed tanpa department dan tenant, history dan
+	//department tanpa tenant.
+	for _, q := range []string{
+		"DROP TABLE employed", "DROP TABLE employed_history", "DROP TABLE department",
+		`CREATE TABLE employed (id varchar(10) PRIMARY KEY, name_employed varchar(100) not null,
+			email varchar(100) not null, phone varchar(13) not null, address varchar(400))`,
+		`CREATE TABLE employed_history (id varchar(10) not null, name_employed varchar(100) not null,
+			email varchar(100) not null, phone varchar(13) not null, address varchar(400),
+			valid_from datetime(6) not null, valid_to datetime(6) not null, PRIMARY KEY (id, valid_from))`,
+		"CREATE TABLE department (id varchar(10) PRIMARY KEY, name_department varchar(100) not null)",
+		"INSERT INTO employed VALUES ('OLD1', 'Old', 'old@example.com', '0811', '')",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	migrations := []dbaccess.Migration{
+		dbaccess.Exec(EmployedTable), dbaccess.Exec(EmployedHistoryTable), dbaccess.Exec(DepartmentTable),
+		dbaccess.Exec(IdempotencyKeyTable), AddDepartmentId, AddTenantId, SeedEmployedHistory,
+	}
+	//migrations dijalankan dua kali, seperti pada database baru yang sudah benar.
+	for i := 0; i < 2; i++ {
+		for j, m := range migrations {
+			if err := m(db); err != nil {
+				t.Fatalf("run:%d migration:%d err:%v", i, j, err)
+			}
+		}
+	}
+	for _, tk := range tenantKeys {
+		columns, err := dbaccess.TableColumns(db, tk.table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range tk.key {
+			if !columns[key] {
+				t.Errorf("table:%s got columns:%v want %s on the PrimaryKey", tk.table, columns, key)
+			}
+		}
+	}
+	tdb := dbaccess.WithTenant(db, testTenant)
+	em := &Employed{Id: "OLD1", NameEmployed: "New", Email: "new@example.com", DepartmentId: "D01"}
+	if err := em.Insert(tdb); err != nil {
+		t.Fatalf("insert the same id on a tenant err:%v", err)
+	}
+	//record lama tetap ada dengan tenant kosong.
+	var name string
+	err := db.QueryRow("SELECT name_employed FROM employed WHERE tenant_id = '' AND id = 'OLD1'").Scan(&name)
+	if err != nil || name != "Old" {
+		t.Errorf("got old record name:%q err:%v", name, err)
+	}
+	//record lama mempunyai satu versi history walaupun migrations dijalankan dua kali.
+	var versions int
+	err = db.QueryRow("SELECT COUNT(*) FROM employed_history WHERE tenant_id = '' AND id = 'OLD1'").Scan(&versions)
+	if err != nil || versions != 1 {
+		t.Errorf("got old record versions:%d err:%v want:1", versions, err)
+	}
+}
@@ -1,97 +1,285 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	"github.com/riyan/apiatex/config"
 	"github.com/riyan/apiatex/controllers"
+	"github.com/riyan/apiatex/controllers/dbaccess"
+	"github.com/riyan/apiatex/controllers/metrics"
+	"github.com/riyan/apiatex/controllers/tracing"
+	"github.com/riyan/apiatex/controllers/webserver"
 	"github.com/riyan/apiatex/controllers/webserver/webhandler"
 	"github.com/riyan/apiatex/models"
 )
 
-var (
-	db        *sql.DB
-	dbatex    = "atex"
-	defaultdb = "mysql"
-	dbuser    = "root"
-	dbpass    = ""
-	host      = ""
-)
+//defaultdb is the database used to create the database on the first start.
+const defaultdb = "mysql"
+
+//buildVersion, buildCommit and buildTime is set on the build with
+//-ldflags "-X main.buildVersion=v1.2.0 -X main.buildCommit=abc123 -X main.buildTime=..."
+var buildVersion, buildCommit, buildTime string
 
+//main start the server, "server config print [flags]" print the config instead.
 func main() {
-	flag.Parse()
-	var err error
-	db, err := connectDB(dbatex, dbuser, dbpass, host)
+	args := os.Args[1:]
+	printConfig := len(args) >= 2 && args[0] == "config" && args[1] == "print"
+	if printConfig {
+		args = args[2:]
+	}
+	cfg, err := config.Load(flag.CommandLine, args)
 	if err != nil {
-		// if !isErrDBNotExist(err) {
-		// 	log.Fatalf("Gagal Konek database %s", err)
-		// }
-		db, err = prepareDB()
+		log.Fatal(err)
+	}
+	if printConfig {
+		if err = cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		return
+	}
+	slog.SetDefault(newLogger(cfg.Log))
+	db, err := connectDB(cfg.DB, "", "")
+	if isErrDBNotExist(err) {
+		db, err = prepareDB(cfg.DB)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.DB.Migrate {
+		version, err := dbaccess.Migrate(db, models.Migrations)
 		if err != nil {
 			log.Fatal(err)
 		}
+		log.Printf("schema version %d", version)
+	}
+	dbs := webhandler.DBStats{"primary": db}
+	appConfig := webserver.Config{
+		Addr:            cfg.Server.Addr,
+		TLSCert:         cfg.Server.TLSCert,
+		TLSKey:          cfg.Server.TLSKey,
+		Debug:           cfg.Debug,
+		ReadTimeout:     cfg.Server.ReadTimeout,
+		WriteTimeout:    cfg.Server.WriteTimeout,
+		ShutdownTimeout: cfg.Server.ShutdownTimeout,
+		LogSample:       cfg.Log.Sample,
+		RedactParams:    cfg.Log.RedactParams,
+		StmtCacheSize:   cfg.DB.StmtCacheSize,
+	}
+	var app *webserver.App
+	if len(cfg.DB.Replicas) == 0 {
+		app = webserver.NewApp(appConfig, db)
+	} else {
+		replicas := connectReplicas(cfg.DB)
+		for i, rdb := range replicas {
+			dbs[fmt.Sprintf("replica-%d", i)] = rdb
+		}
+		router := dbaccess.NewRouter(db, replicas...)
+		router.Start(10 * time.Second)
+		defer router.Close()
+		app = webserver.NewAppWithRouter(appConfig, router)
+	}
+	m := metrics.New()
+	for name, db := range dbs {
+		m.RegisterDB(name, db)
+	}
+	if c := app.StmtCache(); c != nil {
+		m.RegisterStmtCache("primary", c)
+	}
+	app.SetObserver(m)
+	ql := &dbaccess.QueryLogger{All: cfg.DB.LogQueries, SlowThreshold: cfg.DB.SlowQuery,
+		LoggerFromContext: webhandler.LoggerFromContext}
+	if cfg.DB.LogArgs {
+		ql.Redact = dbaccess.KeepArgs
+	}
+	if cfg.DB.ExplainSlow {
+		ql.Explain = db
+		ql.ExplainFormat = cfg.DB.ExplainFormat
+	}
+	app.SetQueryObserver(dbaccess.Observers(m, ql))
+	var tracer *tracing.Tracer
+	if cfg.Tracing.Endpoint != "" {
+		tracer = tracing.NewTracer(tracing.NewBatcher(&tracing.OTLPExporter{
+			Endpoint:    cfg.Tracing.Endpoint,
+			ServiceName: cfg.Tracing.ServiceName,
+		}, cfg.Tracing.BatchSize, cfg.Tracing.Interval))
+		tracer.OnError = func(err error) { log.Printf("tracing: %v", err) }
+		app.SetTracer(tracer)
 	}
-	webhandler.RegisterDB(db)
-	webhandler.DebugOn()
 	eurl := "/api/v1/e/"
-	http.Handle(eurl, controllers.WebHandler(eurl))
-	addr := ":8082"
-	fmt.Printf("server start on %s\n", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
-}
+	tenant := webhandler.TenantResolver{Header: cfg.Tenant.Header, Domain: cfg.Tenant.Domain}
+	if cfg.Auth.TenantClaim != "" {
+		tenant.Claim = webhandler.BearerClaim([]byte(cfg.Auth.TokenSecret), cfg.Auth.TenantClaim)
+	}
+	var handlers []webhandler.WebHandler
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		handlers = append(handlers, webhandler.CORS{
+			AllowedOrigins: cfg.CORS.AllowedOrigins,
+			AllowedMethods: cfg.CORS.AllowedMethods,
+			AllowedHeaders: cfg.CORS.AllowedHeaders,
+			MaxAge:         cfg.CORS.MaxAge,
+		})
+	}
+	var middlewares []webhandler.WebHandler
+	if cfg.Server.IdempotencyTTL > 0 {
+		idem := webhandler.Idempotency{TTL: cfg.Server.IdempotencyTTL}
+		middlewares = append(middlewares, idem)
+		go purgeIdempotency(db, idem, time.Hour)
+	}
+	handlers = append(handlers, controllers.WebHandlers(eurl, tenant, cfg.Server.MaxPageSize, middlewares...)...)
+	app.Handle(eurl, handlers...)
+	//the health endpoints is not behind the tenant and the authentication.
+	app.Handle("/healthz", webhandler.Liveness{})
+	app.Handle("/readyz", webhandler.Readiness{
+		Checkers: map[string]webhandler.Checker{
+			"db":     webhandler.PingDB,
+			"schema": webhandler.SchemaChecker(len(models.Migrations)),
+		},
+		Timeout: cfg.Server.HealthTimeout,
+	})
+	info := webhandler.BuildInfo{Version: buildVersion, Commit: buildCommit, Time: buildTime}
+	app.Handle("/version", webhandler.Version(webhandler.ReadBuildInfo(info)))
+	app.Handle("/metrics", m)
+	if cfg.Server.Diagnostics {
+		app.Handle("/debug/db", dbs)
+	}
 
-func connectDB(name, user, password, host string) (*sql.DB, error) {
-	if password == "" {
-		password = ":" + password
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	errc := make(chan error, 1)
+	go func() {
+		fmt.Printf("server start on %s\n", appConfig.Addr)
+		errc <- app.Start()
+	}()
+	select {
+	case err = <-errc:
+		log.Fatal(err)
+	case <-stop:
 	}
-	db, err := sql.Open("mysql", fmt.Sprintf("%s%s@%s/%s?parseTime=true", user, password, host, name))
-	if err != nil {
-		return nil, err
+	fmt.Println("server shutting down")
+	if err = app.Shutdown(context.Background()); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	ql.Wait()
+	if tracer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err = tracer.Shutdown(ctx); err != nil {
+			log.Printf("tracing shutdown: %v", err)
+		}
 	}
-	err = db.Ping()
-	return db, err
 }
 
-// func isErrDBNotExist(err error) bool {
-// 	if et, ok := err.(*.Error); ok {
-// 		return et.Code == pq.ErrorCode("3D000")
-// 	}
-// 	return false
-// }
-func prepareDB() (*sql.DB, error) {
-	// deleteDB()
+//purgeIdempotency delete the expired idempotency keys every interval.
+func purgeIdempotency(db *sql.DB, idem webhandler.Idempotency, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := idem.Purge(db); err != nil {
+			log.Printf("purge idempotency keys: %v", err)
+		}
+	}
+}
 
-	db, err := connectDB(defaultdb, dbuser, dbpass, host)
-	if err != nil {
-		return nil, err
+//newLogger return the slog.Logger of the config on stderr, the config is validated.
+func newLogger(c config.Log) *slog.Logger {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.Level))
+	opts := &slog.HandlerOptions{Level: level}
+	if c.Format == "text" {
+		return slog.New(slog.NewTextHandler(os.Stderr, opts))
 	}
+	return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+}
 
-	if err = createDB(db, dbatex); err != nil {
-		return nil, err
+//connectOptions return the pool limits and the retries of the config.
+func connectOptions(c config.DB) *dbaccess.ConnectOptions {
+	retries := c.ConnectRetries
+	if retries == 0 {
+		retries = -1
 	}
-	db.Close()
-	if db, err = connectDB(dbatex, dbuser, dbpass, host); err != nil {
-		return nil, err
+	return &dbaccess.ConnectOptions{
+		Pool: dbaccess.Pool{
+			MaxOpenConns:    c.MaxOpenConns,
+			MaxIdleConns:    c.MaxIdleConns,
+			ConnMaxLifetime: c.ConnMaxLifetime,
+			ConnMaxIdleTime: c.ConnMaxIdleTime,
+		},
+		Retries: retries,
+		Backoff: c.ConnectBackoff,
+		OnRetry: func(attempt int, wait time.Duration, err error) {
+			log.Printf("connect database attempt:%d retry in %s: %v", attempt, wait, err)
+		},
 	}
-	tx, err := db.Begin()
+}
+
+//connectDB connect to the database name on the host, empty host and name is the
+//host and name of the config.
+func connectDB(c config.DB, host, name string) (*sql.DB, error) {
+	dsn, err := c.DataSource(host, name)
 	if err != nil {
 		return nil, err
 	}
+	return dbaccess.Connect(context.Background(), "mysql", dsn, connectOptions(c))
+}
 
-	if _, err := tx.Exec(models.EmployedTable); err != nil {
-		tx.Rollback()
-		return nil, err
+//connectReplicas connect to the replicas, the replica that can not be reached is
+//used after the health check succeed.
+func connectReplicas(c config.DB) []*sql.DB {
+	var dbs []*sql.DB
+	opts := connectOptions(c)
+	opts.Retries = -1
+	for _, h := range c.Replicas {
+		dsn, err := c.DataSource(h, "")
+		if err != nil {
+			log.Fatal(err)
+		}
+		rdb, err := dbaccess.Connect(context.Background(), "mysql", dsn, opts)
+		if err != nil {
+			log.Printf("replica %s: %v", h, err)
+			if rdb, err = sql.Open("mysql", dsn); err != nil {
+				log.Fatal(err)
+			}
+			opts.Pool.Apply(rdb)
+		}
+		dbs = append(dbs, rdb)
 	}
+	return dbs
+}
+
+//isErrDBNotExist return true if the error is the unknown database error.
+func isErrDBNotExist(err error) bool {
+	var me *mysql.MySQLError
+	return errors.As(err, &me) && me.Number == 1049
+}
+
+//prepareDB create the database, the tables is created by the migrations.
+func prepareDB(c config.DB) (*sql.DB, error) {
+	// deleteDB()
 
-	if err := tx.Commit(); err != nil {
+	db, err := connectDB(c, "", defaultdb)
+	if err != nil {
 		return nil, err
 	}
 
-	return db, nil
+	if err = createDB(db, c.Database()); err != nil {
+		return nil, err
+	}
+	db.Close()
+	return connectDB(c, "", "")
 }
 
 func createDB(db *sql.DB, name string) error {